@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/cosmos/cosmos-sdk/crypto/keys"
+	"github.com/cosmos/cosmos-sdk/crypto/keys/hd"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/tendermint/tendermint/crypto/secp256k1"
+	"gopkg.in/yaml.v3"
+
+	"github.com/furya-official/mgtool/config/generate"
+)
+
+// accountsFile lists accounts to fund in a generated genesis.
+//
+//	accounts:
+//	  - address: mage1...
+//	    coins: 1000000000umage
+//	  - mnemonic: "..."
+//	    coins: 1000000000umage,1000000usdx
+type accountsFile struct {
+	Accounts []struct {
+		Address  string `yaml:"address"`
+		Mnemonic string `yaml:"mnemonic"`
+		Coins    string `yaml:"coins"`
+	} `yaml:"accounts"`
+}
+
+// loadAccountsFile reads the accounts to fund from a yaml file.
+func loadAccountsFile(filename string) ([]generate.FundedAccount, error) {
+	bz, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(bz))
+	decoder.KnownFields(true)
+	var file accountsFile
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("could not parse accounts file %s: %w", filename, err)
+	}
+	var accounts []generate.FundedAccount
+	for i, a := range file.Accounts {
+		if (a.Address == "") == (a.Mnemonic == "") {
+			return nil, fmt.Errorf("account %d in %s must have exactly one of address or mnemonic", i, filename)
+		}
+		account, err := newFundedAccount(a.Address+a.Mnemonic, a.Coins)
+		if err != nil {
+			return nil, fmt.Errorf("account %d in %s: %w", i, filename, err)
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// parseFundFlag parses an account to fund in the form <address|mnemonic>=<coins>.
+func parseFundFlag(value string) (generate.FundedAccount, error) {
+	i := strings.LastIndex(value, "=")
+	if i < 0 {
+		return generate.FundedAccount{}, fmt.Errorf("invalid --fund value, expected <address|mnemonic>=<coins>")
+	}
+	return newFundedAccount(value[:i], value[i+1:])
+}
+
+// newFundedAccount creates an account from an address or a mnemonic, and a list of coins like 1000umage,50usdx.
+func newFundedAccount(addressOrMnemonic, coins string) (generate.FundedAccount, error) {
+	parsedCoins, err := sdk.ParseCoins(coins)
+	if err != nil {
+		return generate.FundedAccount{}, fmt.Errorf("invalid coins '%s': %w", coins, err)
+	}
+	if parsedCoins.Empty() {
+		return generate.FundedAccount{}, fmt.Errorf("no coins to fund %s with", addressOrMnemonic)
+	}
+
+	addressOrMnemonic = strings.TrimSpace(addressOrMnemonic)
+	var address sdk.AccAddress
+	if strings.Contains(addressOrMnemonic, " ") {
+		address, err = mnemonicToAddress(addressOrMnemonic)
+	} else {
+		address, err = sdk.AccAddressFromBech32(addressOrMnemonic)
+	}
+	if err != nil {
+		return generate.FundedAccount{}, err
+	}
+	return generate.FundedAccount{Address: address.String(), Coins: parsedCoins}, nil
+}
+
+// mnemonicToAddress derives the address of the first account for a mnemonic, the same as `mage keys add --recover`.
+func mnemonicToAddress(mnemonic string) (sdk.AccAddress, error) {
+	hdPath := hd.NewFundraiserParams(0, sdk.GetConfig().GetCoinType(), 0).String()
+	privKeyBytes, err := keys.SecpDeriveKey(mnemonic, "", hdPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mnemonic: %w", err)
+	}
+	var privKey secp256k1.PrivKeySecp256k1
+	copy(privKey[:], privKeyBytes)
+	return sdk.AccAddress(privKey.PubKey().Address()), nil
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/furya-official/mgtool/config/generate"
+)
+
+// mageRepository is cloned to build images from git refs.
+const mageRepository = "https://github.com/furya-official/mage"
+
+// invalidTagCharacters matches characters that can't be used in docker image tags.
+var invalidTagCharacters = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// buildLocalMage builds a mage image from a source checkout or a git ref and switches the generated config's mage services to it.
+// buildFrom is used as a path if it's an existing directory, otherwise it's fetched from the mage repository.
+func buildLocalMage(generatedConfigDir, buildFrom string) error {
+	sourceDir, tag, err := mageSource(buildFrom)
+	if err != nil {
+		return err
+	}
+	image := fmt.Sprintf("%s:local-%s", generate.MageImageRepository, tag)
+
+	services, err := generate.UseLocalMageBuild(generatedConfigDir, image, sourceDir)
+	if err != nil {
+		return err
+	}
+	backend, err := newBackend()
+	if err != nil {
+		return err
+	}
+	// always rebuild, the checkout has probably changed since the last build
+	if err := backend.Build(context.Background(), sourceDir, "", image); err != nil {
+		return fmt.Errorf("could not build %s: %w", image, err)
+	}
+	fmt.Printf("switched %s to %s\n", strings.Join(services, ", "), image)
+	return nil
+}
+
+// mageSource finds the source directory to build and a tag for the image.
+func mageSource(buildFrom string) (string, string, error) {
+	if info, err := os.Stat(buildFrom); err == nil && info.IsDir() {
+		dir, err := filepath.Abs(buildFrom)
+		if err != nil {
+			return "", "", err
+		}
+		if _, err := os.Stat(filepath.Join(dir, "Dockerfile")); err != nil {
+			return "", "", fmt.Errorf("no Dockerfile found in %s", dir)
+		}
+		return dir, sanitizeTag(filepath.Base(dir)), nil
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", "", err
+	}
+	dir := filepath.Join(cacheDir, "kvtool", "mage-src")
+	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+		fmt.Printf("cloning %s into %s\n", mageRepository, dir)
+		if err := runGit("", "clone", mageRepository, dir); err != nil {
+			return "", "", err
+		}
+	}
+	fmt.Printf("checking out %s\n", buildFrom)
+	if err := runGit(dir, "fetch", "origin", buildFrom); err != nil {
+		return "", "", fmt.Errorf("could not fetch %s, it's not a directory or a git ref: %w", buildFrom, err)
+	}
+	if err := runGit(dir, "checkout", "--force", "FETCH_HEAD"); err != nil {
+		return "", "", err
+	}
+	return dir, sanitizeTag(buildFrom), nil
+}
+
+// runGit runs a git command, in dir if it's not empty.
+func runGit(dir string, args ...string) error {
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git %s failed: %w", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// sanitizeTag converts a branch name or path to a valid image tag, eg feature/evm becomes feature-evm.
+func sanitizeTag(name string) string {
+	tag := strings.Trim(invalidTagCharacters.ReplaceAllString(name, "-"), "-.")
+	if len(tag) > 100 {
+		tag = tag[:100]
+	}
+	if tag == "" {
+		return "build"
+	}
+	return strings.ToLower(tag)
+}
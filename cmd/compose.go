@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/furya-official/mgtool/compose"
+)
+
+// newBackend creates the backend used to run testnet containers.
+// It's a variable so tests can swap in a composetest.Backend.
+var newBackend = func() (compose.Backend, error) {
+	return compose.NewEngineBackend()
+}
+
+// loadProject reads the compose project for a generated config.
+func loadProject(generatedConfigDir string) (compose.Project, error) {
+	project, err := compose.LoadProject(filepath.Join(generatedConfigDir, "docker-compose.yaml"))
+	if err != nil {
+		return compose.Project{}, fmt.Errorf("could not load generated config, has it been generated? %w", err)
+	}
+	return project, nil
+}
+
+// loadProjectAndBackend loads the compose project for a generated config along with a backend to run it.
+func loadProjectAndBackend(generatedConfigDir string) (compose.Project, compose.Backend, error) {
+	project, err := loadProject(generatedConfigDir)
+	if err != nil {
+		return compose.Project{}, nil, err
+	}
+	backend, err := newBackend()
+	if err != nil {
+		return compose.Project{}, nil, err
+	}
+	return project, backend, nil
+}
+
+// removeTestnet takes down the testnet in a generated config folder, if there is one, then deletes the folder.
+func removeTestnet(ctx context.Context, backend compose.Backend, generatedConfigDir string) error {
+	// check that the compose file exists before taking down the old testnet
+	if _, err := os.Stat(filepath.Join(generatedConfigDir, "docker-compose.yaml")); err == nil {
+		oldProject, err := loadProject(generatedConfigDir)
+		if err != nil {
+			return err
+		}
+		if err := backend.Down(ctx, oldProject); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(generatedConfigDir); err != nil {
+		return fmt.Errorf("could not clear old generated config: %v", err)
+	}
+	return nil
+}
+
+// startTestnet pulls the images of a generated testnet, starts it in the background, and waits until it's ready.
+func startTestnet(ctx context.Context, backend compose.Backend, generatedConfigDir string, waitTimeout time.Duration) error {
+	project, err := loadProject(generatedConfigDir)
+	if err != nil {
+		return err
+	}
+	if err := backend.Pull(ctx, project); err != nil {
+		// continue with any images already available locally
+		fmt.Println(err.Error())
+	}
+	if err := backend.Up(ctx, project); err != nil {
+		return err
+	}
+	return waitForEndpoints(generatedConfigDir, nil, 1, waitTimeout, defaultWaitInterval)
+}
+
+// interruptContext returns a context that's cancelled when the process is interrupted, until cancel is called.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-interrupts:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(interrupts)
+	}()
+	return ctx, cancel
+}
+
+// runForeground starts a project and shows its logs until interrupted, then stops it, like `docker-compose up`.
+func runForeground(backend compose.Backend, project compose.Project) error {
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	if err := backend.Up(ctx, project); err != nil {
+		return err
+	}
+	opts := logsOptions{LogOptions: compose.LogOptions{Follow: true}, Color: isTerminal(os.Stdout)}
+	if err := printLogs(ctx, os.Stdout, backend, project, nil, opts); err != nil && ctx.Err() == nil {
+		return err
+	}
+	fmt.Println("gracefully stopping...")
+	cancel()
+	return backend.Stop(context.Background(), project)
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/furya-official/mgtool/compose"
+	"github.com/furya-official/mgtool/compose/composetest"
+	"github.com/furya-official/mgtool/config/generate"
+)
+
+// testPortOffset moves the test testnets' host ports away from the defaults, so they don't clash with a running testnet.
+const testPortOffset = 20000
+
+func TestMain(m *testing.M) {
+	// generate config from the templates in the repo rather than extracting the embedded ones
+	templates, err := filepath.Abs(filepath.Join("..", "config", "templates"))
+	if err != nil {
+		panic(err)
+	}
+	generate.ConfigTemplatesDir = templates
+	os.Exit(m.Run())
+}
+
+// testBackend is a composetest.Backend whose running services serve fake chain node endpoints on their published ports,
+// so commands that wait for a testnet to be ready can run without docker.
+type testBackend struct {
+	*composetest.Backend
+
+	mu      sync.Mutex
+	servers map[string]*http.Server
+	// height is the block height reported by the fake nodes. It goes up each time it's queried.
+	height int64
+}
+
+// useTestBackend makes the testnet commands run containers in a testBackend until the test ends.
+func useTestBackend(t *testing.T) *testBackend {
+	backend := &testBackend{Backend: composetest.NewBackend(), servers: map[string]*http.Server{}}
+	oldNewBackend := newBackend
+	newBackend = func() (compose.Backend, error) { return backend, nil }
+	t.Cleanup(func() {
+		newBackend = oldNewBackend
+		backend.closeServers()
+	})
+	return backend
+}
+
+func (b *testBackend) Up(ctx context.Context, project compose.Project, services ...string) error {
+	if err := b.Backend.Up(ctx, project, services...); err != nil {
+		return err
+	}
+	if len(services) == 0 {
+		services = project.ServiceNames()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, name := range services {
+		for _, mapping := range project.Config.Services[name].Ports {
+			port, err := compose.ParsePort(mapping)
+			if err != nil {
+				return err
+			}
+			if port.HostPort == "" || b.servers[port.HostPort] != nil {
+				continue
+			}
+			listener, err := net.Listen("tcp", "127.0.0.1:"+port.HostPort)
+			if err != nil {
+				return fmt.Errorf("could not serve %s port %s: %w", name, mapping, err)
+			}
+			server := &http.Server{Handler: http.HandlerFunc(b.serveNode)}
+			go server.Serve(listener)
+			b.servers[port.HostPort] = server
+		}
+	}
+	return nil
+}
+
+func (b *testBackend) Down(ctx context.Context, project compose.Project) error {
+	b.closeServers()
+	return b.Backend.Down(ctx, project)
+}
+
+func (b *testBackend) closeServers() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for port, server := range b.servers {
+		server.Close()
+		delete(b.servers, port)
+	}
+}
+
+// serveNode answers the requests used to check a chain node is ready: tendermint's /status, and the EVM's eth_blockNumber.
+func (b *testBackend) serveNode(w http.ResponseWriter, r *http.Request) {
+	height := atomic.AddInt64(&b.height, 1)
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodPost:
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":"0x%x"}`, height)
+	case r.URL.Path == "/status":
+		fmt.Fprintf(w, `{"result":{"node_info":{"network":"test-chain"},"sync_info":{"latest_block_height":"%d","catching_up":false}}}`, height)
+	default:
+		fmt.Fprint(w, `{}`)
+	}
+}
+
+// runTestnetCmd runs a testnet sub command with the given arguments.
+func runTestnetCmd(args ...string) error {
+	cmd := TestnetCmd()
+	cmd.SetArgs(args)
+	cmd.SilenceUsage = true
+	return cmd.Execute()
+}
+
+// equalCalls checks the operations performed on a backend.
+func equalCalls(t *testing.T, expected, actual []string) {
+	t.Helper()
+	if len(expected) != len(actual) {
+		t.Fatalf("expected calls:\n%q\ngot:\n%q", expected, actual)
+	}
+	for i := range expected {
+		if expected[i] != actual[i] {
+			t.Fatalf("expected call %d to be %q, got %q\nall calls:\n%q", i, expected[i], actual[i], actual)
+		}
+	}
+}
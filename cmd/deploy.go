@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/furya-official/mgtool/config/generate"
+	"github.com/furya-official/mgtool/deploy"
+)
+
+// defaultDeployerKey is the private key of the dev account funded in the mage templates' genesis, also used in evm/hardhat.config.js.
+const defaultDeployerKey = "C93F165DF8EC9D318A464CA9304E96D627674DC7CD745B97786BB696480F13B3"
+
+// deploymentsFile is where the addresses of the contracts deployed by bootstrap are written, in the generated config folder.
+const deploymentsFile = "deployments.json"
+
+// mageEVMURL finds the JSON-RPC endpoint of the mage node's EVM.
+func mageEVMURL(generatedConfigDir string) (string, error) {
+	endpoints, err := generate.Endpoints(generatedConfigDir)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range endpoints {
+		if e.ComposeService == generate.MageNodeService && e.Kind == generate.EVMEndpoint {
+			return e.URL(), nil
+		}
+	}
+	return "", fmt.Errorf("the %s service doesn't publish an EVM JSON-RPC port, contracts can only be deployed to templates with the EVM", generate.MageNodeService)
+}
+
+// deployContracts deploys contracts to the mage EVM and records their addresses in the generated config's deployments.json.
+func deployContracts(generatedConfigDir string, key *deploy.Key, contracts []deploy.Contract, timeout time.Duration) error {
+	rpcURL, err := mageEVMURL(generatedConfigDir)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	fmt.Printf("deploying %d contracts from %s\n", len(contracts), key.Address())
+	deployments, err := deploy.Deploy(ctx, rpcURL, key, contracts)
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(generatedConfigDir, deploymentsFile)
+	if err := deploy.WriteDeployments(filename, deployments); err != nil {
+		return err
+	}
+	fmt.Printf("contract addresses written to %s\n", filename)
+	return nil
+}
@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jeffail/gabs/v2"
+
+	"github.com/furya-official/mgtool/compose"
+	"github.com/furya-official/mgtool/config/generate"
+	"github.com/furya-official/mgtool/health"
+)
+
+// chainNode is a compose service running a chain node whose state can be exported.
+type chainNode struct {
+	Service string
+	// Binary is the node's daemon, eg mage or mgd.
+	Binary string
+	// Home is the node's home directory inside the container, eg /root/.mage.
+	Home string
+}
+
+// startCommand matches the daemon started by a node's command, eg "mage start --rpc.laddr=...".
+var startCommand = regexp.MustCompile(`(?:^|[\s/])([a-z0-9]+) start(?:\s|$)`)
+
+// chainNodes finds the services in a project that run a chain node.
+// A node is a service that mounts a config directory into a home folder in /root, and runs "<daemon> start".
+func chainNodes(project compose.Project) []chainNode {
+	var nodes []chainNode
+	for _, name := range project.ServiceNames() {
+		service := project.Config.Services[name]
+
+		command := strings.Join(append(append([]string{}, service.Entrypoint...), service.Command...), " ")
+		match := startCommand.FindStringSubmatch(command)
+		if match == nil {
+			continue
+		}
+		home := ""
+		for _, v := range service.Volumes {
+			parts := strings.Split(v, ":")
+			if len(parts) < 2 {
+				continue
+			}
+			target := path.Clean(parts[1])
+			if strings.HasPrefix(target, "/root/.") && path.Base(target) == "config" {
+				home = path.Dir(target)
+				break
+			}
+		}
+		if home == "" {
+			continue
+		}
+		nodes = append(nodes, chainNode{Service: name, Binary: match[1], Home: home})
+	}
+	return nodes
+}
+
+// selectChainNodes returns the nodes named in services, or all running nodes if none are named.
+func selectChainNodes(nodes []chainNode, containers []compose.ContainerState, services []string) ([]chainNode, error) {
+	running := map[string]bool{}
+	for _, c := range containers {
+		if c.Running() {
+			running[c.Service] = true
+		}
+	}
+	if len(services) == 0 {
+		var selected []chainNode
+		for _, n := range nodes {
+			if running[n.Service] {
+				selected = append(selected, n)
+			}
+		}
+		if len(selected) == 0 {
+			return nil, fmt.Errorf("no running chain services found, is the testnet running?")
+		}
+		return selected, nil
+	}
+
+	var selected []chainNode
+	var available []string
+	for _, n := range nodes {
+		available = append(available, n.Service)
+	}
+	for _, s := range services {
+		found := false
+		for _, n := range nodes {
+			if n.Service == s {
+				selected = append(selected, n)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("'%s' is not a chain service, must be one of %s", s, available)
+		}
+		if !running[s] {
+			return nil, fmt.Errorf("service %s is not running", s)
+		}
+	}
+	return selected, nil
+}
+
+// nodeStatuses queries the rpc endpoints of the generated config's services, keyed by compose service.
+// Services without a known rpc endpoint, or that don't respond, are left out.
+func nodeStatuses(generatedConfigDir string) map[string]health.NodeStatus {
+	statuses := map[string]health.NodeStatus{}
+	endpoints, err := generate.Endpoints(generatedConfigDir)
+	if err != nil {
+		return statuses
+	}
+	for _, e := range endpoints {
+		if e.Kind != generate.RPCEndpoint {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		status, err := health.Status(ctx, e.URL())
+		cancel()
+		if err == nil {
+			statuses[e.ComposeService] = status
+		}
+	}
+	return statuses
+}
+
+// exportChainNode exports the state of a stopped node by running its daemon's export command on a copy of its container.
+// It returns the path of the written file.
+func exportChainNode(ctx context.Context, backend compose.Backend, project compose.Project, container compose.ContainerState, node chainNode, status health.NodeStatus, outputDir string) (string, error) {
+	tempImage := fmt.Sprintf("%s-%s-export-temp", project.Name, node.Service)
+	if err := backend.Commit(ctx, container.ID, tempImage); err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := backend.RemoveImage(ctx, tempImage); err != nil {
+			fmt.Println(err.Error())
+		}
+	}()
+
+	var binds []string
+	for _, v := range project.Config.Services[node.Service].Volumes {
+		binds = append(binds, project.ResolveVolume(v))
+	}
+	exportJSON, err := backend.Run(ctx, compose.RunOptions{
+		Image: tempImage,
+		Cmd:   []string{node.Binary, "export", "--home", node.Home},
+		Binds: binds,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	chainID, height := exportChainIDAndHeight(exportJSON, status)
+	filename := filepath.Join(outputDir, fmt.Sprintf("%s-%s-%d.json", node.Service, chainID, height))
+	if err := ioutil.WriteFile(filename, exportJSON, 0644); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
+// exportChainIDAndHeight reads the chain id and height from an exported genesis, falling back to the node's last status.
+// Exports record the height the chain would restart from, which is one past the exported height.
+func exportChainIDAndHeight(exportJSON []byte, status health.NodeStatus) (string, int64) {
+	chainID, height := status.ChainID, status.LatestHeight
+	export, err := gabs.ParseJSON(exportJSON)
+	if err != nil {
+		return chainID, height
+	}
+	if id, ok := export.Path("chain_id").Data().(string); ok && id != "" {
+		chainID = id
+	}
+	var initialHeight int64
+	switch h := export.Path("initial_height").Data().(type) {
+	case string:
+		initialHeight, _ = strconv.ParseInt(h, 10, 64)
+	case float64:
+		initialHeight = int64(h)
+	}
+	if initialHeight > 0 {
+		height = initialHeight - 1
+	}
+	if chainID == "" {
+		chainID = "unknown"
+	}
+	return chainID, height
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/furya-official/mgtool/compose"
+)
+
+func TestExport(t *testing.T) {
+	backend := useTestBackend(t)
+	dir := filepath.Join(t.TempDir(), "generated")
+	outputDir := t.TempDir()
+
+	if err := runTestnetCmd("gen-config", "mage", "--generated-dir", dir, "--port-offset", strconv.Itoa(testPortOffset)); err != nil {
+		t.Fatal(err)
+	}
+	project, err := loadProject(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Up(context.Background(), project); err != nil {
+		t.Fatal(err)
+	}
+	exportJSON := `{"chain_id":"mage-localnet","initial_height":"21","app_state":{}}`
+	backend.RunFunc = func(opts compose.RunOptions) ([]byte, error) {
+		return []byte(exportJSON), nil
+	}
+	backend.Calls = nil
+
+	if err := runTestnetCmd("export", "--generated-dir", dir, "--output-dir", outputDir); err != nil {
+		t.Fatal(err)
+	}
+	equalCalls(t, []string{
+		"stop",
+		"commit generated_magenode_1 generated-magenode-export-temp",
+		"run generated-magenode-export-temp mage export --home /root/.mage",
+		"rmi generated-magenode-export-temp",
+		"start",
+	}, backend.Calls)
+
+	// the export is named after the chain and the height it was exported at
+	bz, err := ioutil.ReadFile(filepath.Join(outputDir, "magenode-mage-localnet-20.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bz) != exportJSON {
+		t.Fatalf("expected the export to be written unchanged, got %s", bz)
+	}
+}
+
+func TestExportRestartsOnFailure(t *testing.T) {
+	backend := useTestBackend(t)
+	dir := filepath.Join(t.TempDir(), "generated")
+
+	if err := runTestnetCmd("gen-config", "mage", "--generated-dir", dir, "--port-offset", strconv.Itoa(testPortOffset)); err != nil {
+		t.Fatal(err)
+	}
+	project, err := loadProject(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Up(context.Background(), project); err != nil {
+		t.Fatal(err)
+	}
+	backend.RunFunc = func(opts compose.RunOptions) ([]byte, error) {
+		return nil, &compose.Error{Op: "run container", Target: opts.Image, Message: "exited with code 1"}
+	}
+	backend.Calls = nil
+
+	err = runTestnetCmd("export", "--generated-dir", dir, "--output-dir", t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "could not export magenode") {
+		t.Fatalf("expected the export to fail, got %v", err)
+	}
+	// the temporary image is removed and the testnet started again
+	equalCalls(t, []string{
+		"stop",
+		"commit generated_magenode_1 generated-magenode-export-temp",
+		"run generated-magenode-export-temp mage export --home /root/.mage",
+		"rmi generated-magenode-export-temp",
+		"start",
+	}, backend.Calls)
+}
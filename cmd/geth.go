@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/furya-official/mgtool/config/generate"
+)
+
+// gethGenesisFlags are the flags for changing the geth genesis.
+type gethGenesisFlags struct {
+	chainID   int64
+	blockTime time.Duration
+	fund      []string
+	contracts []string
+	storage   []string
+	// dropExportedState allows the changes to drop the template's exported blocks
+	dropExportedState bool
+}
+
+// addGethFlags adds the flags for changing the geth genesis.
+func addGethFlags(cmd *cobra.Command, flags *gethGenesisFlags) {
+	cmd.Flags().Int64Var(&flags.chainID, "geth-chain-id", 0, "chain id of the geth node, defaults to the template's")
+	cmd.Flags().DurationVar(&flags.blockTime, "geth-block-time", 0, "time between geth blocks in whole seconds, defaults to the template's")
+	cmd.Flags().StringArrayVar(&flags.fund, "geth-fund", nil, "fund an account in the geth genesis, in the form <address>=<wei>. Can be repeated")
+	cmd.Flags().StringArrayVar(&flags.contracts, "geth-contract", nil, "deploy a compiled contract in the geth genesis, in the form <address>=<artifact.json>. Its constructor isn't run. Can be repeated")
+	cmd.Flags().StringArrayVar(&flags.storage, "geth-storage", nil, "set a storage slot of a --geth-contract, in the form <address>:<slot>=<value>, with the slot and value in hex. Can be repeated")
+	cmd.Flags().BoolVar(&flags.dropExportedState, "geth-drop-exported-state", false, "allow the geth genesis options to drop the template's exported blocks, which only import on its original genesis, and the bridge and token contracts they deploy. The options fail without it")
+}
+
+// parse checks the geth flags, and reads the contracts' artifacts.
+func (flags gethGenesisFlags) parse() (generate.GethGenesis, error) {
+	if flags.chainID < 0 {
+		return generate.GethGenesis{}, fmt.Errorf("--geth-chain-id must be positive")
+	}
+	if flags.blockTime < 0 || (flags.blockTime > 0 && flags.blockTime < time.Second) {
+		return generate.GethGenesis{}, fmt.Errorf("--geth-block-time must be at least 1s")
+	}
+	genesis := generate.GethGenesis{ChainID: flags.chainID, BlockTime: flags.blockTime, DropExportedState: flags.dropExportedState}
+
+	for _, f := range flags.fund {
+		address, value, err := splitGethFlag(f)
+		if err != nil {
+			return generate.GethGenesis{}, fmt.Errorf("invalid --geth-fund value '%s', expected <address>=<wei>: %w", f, err)
+		}
+		balance, ok := new(big.Int).SetString(value, 10)
+		if !ok || balance.Sign() <= 0 {
+			return generate.GethGenesis{}, fmt.Errorf("invalid --geth-fund amount '%s', must be a positive number of wei", value)
+		}
+		genesis.Accounts = append(genesis.Accounts, generate.GethAccount{Address: address, Balance: balance})
+	}
+
+	// the storage of each contract, by address
+	contracts := map[string]map[string]string{}
+	for _, f := range flags.contracts {
+		address, artifact, err := splitGethFlag(f)
+		if err != nil {
+			return generate.GethGenesis{}, fmt.Errorf("invalid --geth-contract value '%s', expected <address>=<artifact.json>: %w", f, err)
+		}
+		if _, found := contracts[address]; found {
+			return generate.GethGenesis{}, fmt.Errorf("more than one --geth-contract for 0x%s", address)
+		}
+		code, err := generate.LoadContractArtifact(artifact)
+		if err != nil {
+			return generate.GethGenesis{}, err
+		}
+		contracts[address] = map[string]string{}
+		genesis.Contracts = append(genesis.Contracts, generate.GethContract{Address: address, Code: code, Storage: contracts[address]})
+	}
+	for _, f := range flags.storage {
+		i := strings.Index(f, ":")
+		if i < 0 {
+			return generate.GethGenesis{}, fmt.Errorf("invalid --geth-storage value, expected <address>:<slot>=<value>")
+		}
+		address, err := generate.ParseGethAddress(f[:i])
+		if err != nil {
+			return generate.GethGenesis{}, err
+		}
+		storage, found := contracts[address]
+		if !found {
+			return generate.GethGenesis{}, fmt.Errorf("--geth-storage for 0x%s needs a --geth-contract for the same address", address)
+		}
+		parts := strings.SplitN(f[i+1:], "=", 2)
+		if len(parts) != 2 {
+			return generate.GethGenesis{}, fmt.Errorf("invalid --geth-storage value, expected <address>:<slot>=<value>")
+		}
+		slot, err := generate.ParseGethStorageWord(parts[0])
+		if err != nil {
+			return generate.GethGenesis{}, err
+		}
+		value, err := generate.ParseGethStorageWord(parts[1])
+		if err != nil {
+			return generate.GethGenesis{}, err
+		}
+		storage[slot] = value
+	}
+	return genesis, nil
+}
+
+// splitGethFlag splits a flag value in the form <address>=<value>, checking the address.
+func splitGethFlag(flag string) (string, string, error) {
+	parts := strings.SplitN(flag, "=", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", fmt.Errorf("missing value")
+	}
+	address, err := generate.ParseGethAddress(parts[0])
+	if err != nil {
+		return "", "", err
+	}
+	return address, parts[1], nil
+}
+
+// configureGethGenesis applies the geth flags to a generated config, explaining how to drop the template's exported blocks if they're in the way.
+func configureGethGenesis(generatedConfigDir string, genesis generate.GethGenesis) error {
+	err := generate.ConfigureGethGenesis(generatedConfigDir, genesis)
+	if errors.Is(err, generate.ErrGethExportedState) {
+		return fmt.Errorf("could not configure geth genesis: %w. Add --geth-drop-exported-state to generate the geth node without them", err)
+	}
+	if err != nil {
+		return fmt.Errorf("could not configure geth genesis: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenConfigGethExportedState(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "generated")
+	args := []string{"gen-config", "geth", "--generated-dir", dir, "--geth-chain-id", "1337"}
+
+	// changing the genesis would silently lose the template's exported blocks
+	err := runTestnetCmd(args...)
+	if err == nil || !strings.Contains(err.Error(), "--geth-drop-exported-state") {
+		t.Fatalf("expected the geth options to fail without --geth-drop-exported-state, got %v", err)
+	}
+
+	if err := runTestnetCmd(append(args, "--geth-drop-exported-state")...); err != nil {
+		t.Fatal(err)
+	}
+	bz, err := ioutil.ReadFile(filepath.Join(dir, "docker-compose.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(bz), "exported_state") {
+		t.Fatalf("expected the exported blocks not to be mounted, got:\n%s", bz)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "geth", "initstate", "exported_state")); !os.IsNotExist(err) {
+		t.Fatalf("expected the exported blocks to be removed, got %v", err)
+	}
+}
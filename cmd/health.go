@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/furya-official/mgtool/config/generate"
+	"github.com/furya-official/mgtool/health"
+)
+
+const (
+	defaultWaitTimeout  = 2 * time.Minute
+	defaultWaitInterval = time.Second
+)
+
+// waitForEndpoints polls the endpoints published by the generated config until they're ready.
+// If composeServices is empty, all known endpoints are checked.
+func waitForEndpoints(generatedConfigDir string, composeServices []string, minHeight int64, timeout, interval time.Duration) error {
+	endpoints, err := generate.Endpoints(generatedConfigDir)
+	if err != nil {
+		return err
+	}
+	var checks []health.Check
+	for _, e := range endpoints {
+		if len(composeServices) > 0 && !stringSlice(composeServices).contains(e.ComposeService) {
+			continue
+		}
+		switch e.Kind {
+		case generate.RPCEndpoint:
+			checks = append(checks, health.BlocksProduced(e.URL(), minHeight))
+		case generate.RESTEndpoint:
+			checks = append(checks, health.HTTPResponding(e.URL()))
+		case generate.GRPCEndpoint:
+			checks = append(checks, health.TCPListening(e.Address))
+		case generate.EVMEndpoint:
+			checks = append(checks, health.EVMResponding(e.URL()))
+		}
+		fmt.Printf("waiting for %s %s endpoint at %s\n", e.ComposeService, e.Kind, e.Address)
+	}
+	if len(checks) == 0 {
+		return fmt.Errorf("no endpoints found to wait for")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := health.Wait(ctx, interval, checks...); err != nil {
+		return fmt.Errorf("testnet not ready after %s: %w", timeout, err)
+	}
+	return nil
+}
+
+// waitForNextBlocks waits until every chain in the generated config has produced a block past its current height.
+func waitForNextBlocks(generatedConfigDir string, timeout time.Duration) error {
+	endpoints, err := generate.Endpoints(generatedConfigDir)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var checks []health.Check
+	for _, e := range endpoints {
+		if e.Kind != generate.RPCEndpoint {
+			continue
+		}
+		status, err := health.Status(ctx, e.URL())
+		if err != nil {
+			return err
+		}
+		checks = append(checks, health.BlocksProduced(e.URL(), status.LatestHeight+1))
+	}
+	return health.Wait(ctx, defaultWaitInterval, checks...)
+}
@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Jeffail/gabs/v2"
+	"github.com/spf13/cobra"
+
+	"github.com/furya-official/mgtool/compose"
+	"github.com/furya-official/mgtool/config/generate"
+)
+
+// names of the relayers for the --relayer flag
+const (
+	hermesRelayer = "hermes"
+	goRelayer     = "go-relayer"
+)
+
+// relayerServiceName returns the service of a relayer chosen with the --relayer flag.
+func relayerServiceName(relayer string) (string, error) {
+	switch relayer {
+	case hermesRelayer:
+		return generate.HermesServiceName, nil
+	case goRelayer:
+		return generate.RelayerServiceName, nil
+	default:
+		return "", fmt.Errorf("unknown relayer '%s', must be %s or %s", relayer, hermesRelayer, goRelayer)
+	}
+}
+
+// addIbcFlags adds the flags for setting up the ibc chains and the channels between them.
+func addIbcFlags(cmd *cobra.Command, ibcChains *int, ibcChannels *[]string) {
+	cmd.Flags().IntVar(ibcChains, "ibc-chains", 1, "number of ibc chains to run alongside mage, each with its own chain id and ports. Creating more than one needs docker to generate their validators")
+	cmd.Flags().StringArrayVar(ibcChannels, "ibc-channel", nil, "a channel for the relayer to open, in the form [<service>:]<port>=[<service>:]<port>[,ordered|unordered][,<version>], eg ibcnode:transfer=ibcnode-1:transfer. Without services the channel is opened between mage and every ibc chain. Can be repeated, defaults to transfer=transfer")
+}
+
+// parseIbcFlags checks the ibc flags and parses the channels.
+func parseIbcFlags(ibcChains int, ibcChannels []string) ([]generate.IbcChannel, error) {
+	if ibcChains < 1 {
+		return nil, fmt.Errorf("--ibc-chains must be at least 1")
+	}
+	var channels []generate.IbcChannel
+	for _, spec := range ibcChannels {
+		channel, err := generate.ParseIbcChannel(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --ibc-channel: %w", err)
+		}
+		channels = append(channels, channel)
+	}
+	return channels, nil
+}
+
+// generateIbcChains gives the generated config count ibc chains and sets up the channels the relayer opens between them.
+// The ibc chain's image is used to create validators for the extra chains.
+func generateIbcChains(generatedConfigDir string, count int, channels []generate.IbcChannel) error {
+	chains, err := generate.AddIbcChains(generatedConfigDir, count)
+	if err != nil {
+		return err
+	}
+	if len(chains) == 0 {
+		if len(channels) > 0 {
+			return fmt.Errorf("ibc channels can only be set for a config with the ibc service")
+		}
+		return nil
+	}
+	if len(chains) > 1 {
+		if err := createIbcValidators(generatedConfigDir, chains); err != nil {
+			return fmt.Errorf("could not create validators for the ibc chains: %w", err)
+		}
+	}
+	return generate.ConfigureIbcChannels(generatedConfigDir, chains, channels)
+}
+
+// createIbcValidators signs a gentx for the copied ibc chains with the first chain's validator, and collects it into their genesis.
+func createIbcValidators(generatedConfigDir string, chains []generate.IbcChain) error {
+	project, backend, err := loadProjectAndBackend(generatedConfigDir)
+	if err != nil {
+		return err
+	}
+	var ibcNode chainNode
+	for _, n := range chainNodes(project) {
+		if n.Service == generate.IbcNodeService {
+			ibcNode = n
+		}
+	}
+	if ibcNode.Binary == "" {
+		return fmt.Errorf("could not find how to run the %s service", generate.IbcNodeService)
+	}
+
+	genesis, err := gabs.ParseJSONFile(filepath.Join(chains[0].ConfigDir(), "genesis.json"))
+	if err != nil {
+		return err
+	}
+	gentxs := genesis.Path("app_state.genutil.gen_txs").Children()
+	if len(gentxs) == 0 {
+		return fmt.Errorf("the %s genesis has no gentx to copy the validator from", chains[0].ChainID)
+	}
+	message := gentxs[0].Path("body.messages.0")
+	amount, _ := message.Path("value.amount").Data().(string)
+	denom, _ := message.Path("value.denom").Data().(string)
+	if amount == "" || denom == "" {
+		return fmt.Errorf("could not read the validator from the %s genesis", chains[0].ChainID)
+	}
+
+	var binds []string
+	lines := []string{"set -e"}
+	for _, chain := range chains[1:] {
+		home, err := filepath.Abs(chain.Home)
+		if err != nil {
+			return err
+		}
+		mount := "/chains/" + chain.ComposeService
+		binds = append(binds, fmt.Sprintf("%s:%s", home, mount))
+		lines = append(lines,
+			fmt.Sprintf("mkdir -p %s/config/gentx", mount),
+			// gentx takes the name of the key to sign with, the template's keyring has the validator's key as "validator"
+			fmt.Sprintf("%s gentx validator %s%s --moniker %s --chain-id %s --keyring-backend test --home %s --output-document %s/config/gentx/gentx-%s.json",
+				ibcNode.Binary, amount, denom, chain.ComposeService, chain.ChainID, mount, mount, chain.ComposeService),
+			fmt.Sprintf("%s collect-gentxs --home %s > /dev/null 2>&1", ibcNode.Binary, mount),
+		)
+	}
+	fmt.Printf("creating validators for %d ibc chains\n", len(chains)-1)
+	_, err = backend.Run(context.Background(), compose.RunOptions{
+		Image: project.Config.Services[generate.IbcNodeService].Image,
+		Cmd:   []string{"sh", "-c", strings.Join(lines, "\n")},
+		Binds: binds,
+	})
+	return err
+}
+
+// linkIbcChains uses the go relayer to open the channels configured between the ibc chains.
+func linkIbcChains(generatedConfigDir string) error {
+	ctx := context.Background()
+	project, backend, err := loadProjectAndBackend(generatedConfigDir)
+	if err != nil {
+		return err
+	}
+	image, err := generate.ImageOverride(generatedConfigDir, generate.RelayerServiceName)
+	if err != nil {
+		return err
+	}
+	if image == "" {
+		image = defaultRelayerImage
+	}
+	paths, err := generate.IbcPaths(generatedConfigDir)
+	if err != nil {
+		return err
+	}
+	relayerMount := fmt.Sprintf("%s:%s", filepath.Join(project.Dir(), "relayer"), "/relayer/.relayer")
+	for _, path := range paths {
+		fmt.Printf("opening ibc channel %s\n", path.Name)
+		_, err := backend.Run(ctx, compose.RunOptions{
+			Image:   image,
+			Cmd:     []string{"tx", "link", path.Name, "-d", "-o", "3s"},
+			Binds:   []string{relayerMount},
+			Network: project.NetworkName(),
+			Output:  os.Stdout,
+		})
+		if err != nil {
+			return fmt.Errorf("could not open ibc channel %s: %w", path.Name, err)
+		}
+	}
+	fmt.Printf("IBC connection complete, starting relayer process...\n")
+	return waitForNextBlocks(generatedConfigDir, defaultWaitTimeout)
+}
+
+// restoreHermesKeys adds the relayer account to hermes' keyring for each ibc chain.
+func restoreHermesKeys(generatedConfigDir string) error {
+	ctx := context.Background()
+	project, backend, err := loadProjectAndBackend(generatedConfigDir)
+	if err != nil {
+		return err
+	}
+	image, err := hermesImage(generatedConfigDir)
+	if err != nil {
+		return err
+	}
+	chainIDs, err := generate.HermesChainIDs(generatedConfigDir)
+	if err != nil {
+		return err
+	}
+	// the relayers sign with the validator's account, which the templates fund on every chain
+	validator, err := mageValidator()
+	if err != nil {
+		return err
+	}
+	hermesMount := fmt.Sprintf("%s:%s", filepath.Join(project.Dir(), "hermes"), "/home/hermes/.hermes")
+	for _, chainID := range chainIDs {
+		_, err := backend.Run(ctx, compose.RunOptions{
+			Image:  image,
+			Cmd:    []string{"keys", "restore", chainID, "-n", "testkey", "-m", validator.Mnemonic, "--hd-path", "m/44'/459'/0'/0/0"},
+			Binds:  []string{hermesMount},
+			Output: os.Stdout,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createHermesChannels uses hermes to open the channels configured between the ibc chains.
+func createHermesChannels(generatedConfigDir string) error {
+	ctx := context.Background()
+	project, backend, err := loadProjectAndBackend(generatedConfigDir)
+	if err != nil {
+		return err
+	}
+	image, err := hermesImage(generatedConfigDir)
+	if err != nil {
+		return err
+	}
+	paths, err := generate.IbcPaths(generatedConfigDir)
+	if err != nil {
+		return err
+	}
+	hermesMount := fmt.Sprintf("%s:%s", filepath.Join(project.Dir(), "hermes"), "/home/hermes/.hermes")
+	for _, path := range paths {
+		order := "unordered"
+		if path.Ordered {
+			order = "ordered"
+		}
+		fmt.Printf("opening ibc channel %s\n", path.Name)
+		_, err := backend.Run(ctx, compose.RunOptions{
+			Image:   image,
+			Cmd:     []string{"create", "channel", path.Src.ChainID, path.Dst.ChainID, "--port-a", path.Src.Port, "--port-b", path.Dst.Port, "-o", order, "-v", path.Version},
+			Binds:   []string{hermesMount},
+			Network: project.NetworkName(),
+			Output:  os.Stdout,
+		})
+		if err != nil {
+			return fmt.Errorf("could not open ibc channel %s: %w", path.Name, err)
+		}
+	}
+	fmt.Printf("IBC connection complete, starting relayer process...\n")
+	return waitForNextBlocks(generatedConfigDir, defaultWaitTimeout)
+}
+
+// hermesImage returns the image hermes is run with.
+func hermesImage(generatedConfigDir string) (string, error) {
+	image, err := generate.ImageOverride(generatedConfigDir, generate.HermesServiceName)
+	if err != nil {
+		return "", err
+	}
+	if image == "" {
+		image = defaultHermesImage
+	}
+	return image, nil
+}
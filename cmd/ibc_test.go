@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/furya-official/mgtool/compose"
+)
+
+func TestGenConfigIbcChainsValidators(t *testing.T) {
+	backend := useTestBackend(t)
+	var script string
+	backend.RunFunc = func(opts compose.RunOptions) ([]byte, error) {
+		script = opts.Cmd[len(opts.Cmd)-1]
+		return nil, nil
+	}
+	dir := filepath.Join(t.TempDir(), "generated")
+
+	err := runTestnetCmd("gen-config", "mage", "ibc", "--ibc-chains", "2", "--generated-dir", dir, "--port-offset", strconv.Itoa(testPortOffset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backend.Calls) != 1 || !strings.HasPrefix(backend.Calls[0], "run ") {
+		t.Fatalf("expected the validators to be created in a container, got calls %v", backend.Calls)
+	}
+	// gentx is given the name of the validator's key in the template's keyring, not its address
+	if !strings.Contains(script, "mage gentx validator ") {
+		t.Fatalf("expected the gentx to be signed with the validator key, got script:\n%s", script)
+	}
+	if !strings.Contains(script, "--home /chains/ibcnode-1 ") {
+		t.Fatalf("expected a gentx for ibcnode-1, got script:\n%s", script)
+	}
+}
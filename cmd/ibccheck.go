@@ -0,0 +1,278 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/furya-official/mgtool/config/generate"
+	"github.com/furya-official/mgtool/health"
+)
+
+const (
+	// defaultIbcCheckChainID is the chain id of the first ibc chain, as set in the ibcchain template
+	defaultIbcCheckChainID = "mage-localnet-2"
+	transferPort           = "transfer"
+)
+
+// ibcCheckOptions are the settings of an ibc-check transfer.
+type ibcCheckOptions struct {
+	// ChainID is the chain the tokens are sent to.
+	ChainID string
+	// Channel is the mage channel to send on, found from the open transfer channels if empty.
+	Channel string
+	// Amount is the coin sent, eg 1000umage.
+	Amount string
+	// Receiver is the address on the counterparty chain, defaults to the sender's address.
+	Receiver string
+	Timeout  time.Duration
+}
+
+// ibcTransfer is the outcome of a transfer sent by ibc-check.
+type ibcTransfer struct {
+	Channel             string
+	CounterpartyChannel string
+	Sequence            uint64
+	Voucher             string
+	Balance             *big.Int
+	// time from the transfer being included in a block until the packet was received, and until it was acknowledged
+	Received     time.Duration
+	Acknowledged time.Duration
+}
+
+var coinPattern = regexp.MustCompile(`^([0-9]+)([a-zA-Z][a-zA-Z0-9/:._-]*)$`)
+
+// checkIbcTransfer sends an ICS-20 transfer from the mage validator to another chain, waits for the relayer to deliver the packet
+// and return its acknowledgement, then checks the receiver's balance of the voucher increased by the amount sent.
+func checkIbcTransfer(generatedConfigDir string, opts ibcCheckOptions) (ibcTransfer, error) {
+	match := coinPattern.FindStringSubmatch(opts.Amount)
+	if match == nil {
+		return ibcTransfer{}, fmt.Errorf("invalid amount '%s', must be a whole number of a denom, eg 1000umage", opts.Amount)
+	}
+	amount, _ := new(big.Int).SetString(match[1], 10)
+	denom := match[2]
+
+	validator, err := mageValidator()
+	if err != nil {
+		return ibcTransfer{}, err
+	}
+	receiver := opts.Receiver
+	if receiver == "" {
+		// the ibc chains use mage's address prefix and coin type, so the validator has the same address on them
+		receiver = validator.Address
+	}
+	chain, err := loadUpgradeChain(generatedConfigDir)
+	if err != nil {
+		return ibcTransfer{}, err
+	}
+	mageREST, counterpartyREST, err := ibcCheckEndpoints(generatedConfigDir, opts.ChainID)
+	if err != nil {
+		return ibcTransfer{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+	transfer := ibcTransfer{Channel: opts.Channel}
+	transfer.CounterpartyChannel, err = transferCounterparty(ctx, mageREST, opts.ChainID, &transfer.Channel)
+	if err != nil {
+		return ibcTransfer{}, err
+	}
+	transfer.Voucher = voucherDenom(transferPort, transfer.CounterpartyChannel, denom)
+	balanceBefore, err := bankBalance(ctx, counterpartyREST, receiver, transfer.Voucher)
+	if err != nil {
+		return ibcTransfer{}, fmt.Errorf("could not query the receiver's balance on %s: %w", opts.ChainID, err)
+	}
+
+	fmt.Printf("sending %s to %s on %s over %s\n", opts.Amount, receiver, opts.ChainID, transfer.Channel)
+	output, err := runMageTx(ctx, chain, validator.Mnemonic, "ibc-transfer", "transfer", transferPort, transfer.Channel, receiver, opts.Amount)
+	if err != nil {
+		return ibcTransfer{}, fmt.Errorf("could not send the transfer: %w", err)
+	}
+	sent := time.Now()
+	transfer.Sequence, err = packetSequence(output)
+	if err != nil {
+		return ibcTransfer{}, err
+	}
+
+	fmt.Printf("waiting for packet %d to be received\n", transfer.Sequence)
+	if err := waitForPacket(ctx, counterpartyREST, "unreceived_packets", transfer.CounterpartyChannel, transfer.Sequence); err != nil {
+		return ibcTransfer{}, fmt.Errorf("packet %d was not received on %s, check the relayer is running: %w", transfer.Sequence, opts.ChainID, err)
+	}
+	transfer.Received = time.Since(sent)
+	fmt.Printf("waiting for packet %d to be acknowledged\n", transfer.Sequence)
+	if err := waitForPacket(ctx, mageREST, "unreceived_acks", transfer.Channel, transfer.Sequence); err != nil {
+		return ibcTransfer{}, fmt.Errorf("packet %d was not acknowledged on %s: %w", transfer.Sequence, chain.chainID, err)
+	}
+	transfer.Acknowledged = time.Since(sent)
+
+	transfer.Balance, err = bankBalance(ctx, counterpartyREST, receiver, transfer.Voucher)
+	if err != nil {
+		return ibcTransfer{}, fmt.Errorf("could not query the receiver's balance on %s: %w", opts.ChainID, err)
+	}
+	if expected := new(big.Int).Add(balanceBefore, amount); transfer.Balance.Cmp(expected) != 0 {
+		return ibcTransfer{}, fmt.Errorf("receiver's %s balance is %s after the transfer, expected %s", transfer.Voucher, transfer.Balance, expected)
+	}
+	return transfer, nil
+}
+
+// ibcCheckEndpoints finds the REST endpoints of the mage node and the node of the counterparty chain.
+func ibcCheckEndpoints(generatedConfigDir, chainID string) (string, string, error) {
+	endpoints, err := generate.Endpoints(generatedConfigDir)
+	if err != nil {
+		return "", "", err
+	}
+	var counterpartyService string
+	for service, status := range nodeStatuses(generatedConfigDir) {
+		if status.ChainID == chainID {
+			counterpartyService = service
+		}
+	}
+	if counterpartyService == "" {
+		return "", "", fmt.Errorf("no running node found for chain %s, start one with 'testnet bootstrap --ibc'", chainID)
+	}
+	var mageREST, counterpartyREST string
+	for _, e := range endpoints {
+		if e.Kind != generate.RESTEndpoint {
+			continue
+		}
+		switch e.ComposeService {
+		case generate.MageNodeService:
+			mageREST = e.URL()
+		case counterpartyService:
+			counterpartyREST = e.URL()
+		}
+	}
+	if mageREST == "" {
+		return "", "", fmt.Errorf("the %s service doesn't publish its rest port", generate.MageNodeService)
+	}
+	if counterpartyREST == "" {
+		return "", "", fmt.Errorf("the %s service doesn't publish its rest port", counterpartyService)
+	}
+	return mageREST, counterpartyREST, nil
+}
+
+// transferCounterparty finds the open mage transfer channel to a chain, or checks the given one leads to it, and returns the channel on the other end.
+func transferCounterparty(ctx context.Context, restURL, chainID string, channel *string) (string, error) {
+	var response struct {
+		Channels []struct {
+			State        string `json:"state"`
+			PortID       string `json:"port_id"`
+			ChannelID    string `json:"channel_id"`
+			Counterparty struct {
+				ChannelID string `json:"channel_id"`
+			} `json:"counterparty"`
+		} `json:"channels"`
+	}
+	if err := health.GetJSON(ctx, restURL+"/ibc/core/channel/v1/channels?pagination.limit=1000", &response); err != nil {
+		return "", fmt.Errorf("could not query the ibc channels: %w", err)
+	}
+	for _, c := range response.Channels {
+		if c.PortID != transferPort || c.State != "STATE_OPEN" || (*channel != "" && c.ChannelID != *channel) {
+			continue
+		}
+		var clientState struct {
+			IdentifiedClientState struct {
+				ClientState struct {
+					ChainID string `json:"chain_id"`
+				} `json:"client_state"`
+			} `json:"identified_client_state"`
+		}
+		if err := health.GetJSON(ctx, fmt.Sprintf("%s/ibc/core/channel/v1/channels/%s/ports/%s/client_state", restURL, c.ChannelID, c.PortID), &clientState); err != nil {
+			return "", fmt.Errorf("could not query the client of %s: %w", c.ChannelID, err)
+		}
+		if clientState.IdentifiedClientState.ClientState.ChainID == chainID {
+			*channel = c.ChannelID
+			return c.Counterparty.ChannelID, nil
+		}
+	}
+	if *channel != "" {
+		return "", fmt.Errorf("%s is not an open transfer channel to %s", *channel, chainID)
+	}
+	return "", fmt.Errorf("no open transfer channel to %s", chainID)
+}
+
+// voucherDenom is the denom a chain gives tokens received over one of its channels, eg ibc/27394FB092D2ECCD56123C74F36E4C1F926001CEADA9CA97EA622B25F41E5EB2.
+func voucherDenom(port, channel, denom string) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s/%s/%s", port, channel, denom)))
+	return "ibc/" + strings.ToUpper(hex.EncodeToString(hash[:]))
+}
+
+// bankBalance queries an account's balance of a denom.
+func bankBalance(ctx context.Context, restURL, address, denom string) (*big.Int, error) {
+	var response struct {
+		Balance struct {
+			Amount string `json:"amount"`
+		} `json:"balance"`
+	}
+	if err := health.GetJSON(ctx, fmt.Sprintf("%s/cosmos/bank/v1beta1/balances/%s/by_denom?denom=%s", restURL, address, url.QueryEscape(denom)), &response); err != nil {
+		return nil, err
+	}
+	balance, ok := new(big.Int).SetString(response.Balance.Amount, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid %s balance '%s'", denom, response.Balance.Amount)
+	}
+	return balance, nil
+}
+
+// packetSequence reads the sequence of the packet sent by a transfer from its transaction's events.
+func packetSequence(txOutput []byte) (uint64, error) {
+	var result struct {
+		Logs []struct {
+			Events []struct {
+				Type       string `json:"type"`
+				Attributes []struct {
+					Key   string `json:"key"`
+					Value string `json:"value"`
+				} `json:"attributes"`
+			} `json:"events"`
+		} `json:"logs"`
+	}
+	if err := json.Unmarshal(txOutput, &result); err != nil {
+		return 0, fmt.Errorf("could not parse transaction result: %w", err)
+	}
+	for _, log := range result.Logs {
+		for _, event := range log.Events {
+			if event.Type != "send_packet" {
+				continue
+			}
+			for _, a := range event.Attributes {
+				if a.Key == "packet_sequence" {
+					return strconv.ParseUint(a.Value, 10, 64)
+				}
+			}
+		}
+	}
+	return 0, fmt.Errorf("no send_packet event in the transfer transaction")
+}
+
+// waitForPacket polls a chain until a packet's sequence is no longer listed by one of its unreceived queries.
+// unreceived_packets is queried on the destination chain to wait for the packet to be received,
+// unreceived_acks on the source chain to wait for the acknowledgement to be relayed back.
+func waitForPacket(ctx context.Context, restURL, query, channel string, sequence uint64) error {
+	queryURL := fmt.Sprintf("%s/ibc/core/channel/v1/channels/%s/ports/%s/packet_commitments/%d/%s", restURL, channel, transferPort, sequence, query)
+	for {
+		var response struct {
+			Sequences []string `json:"sequences"`
+		}
+		err := health.GetJSON(ctx, queryURL, &response)
+		if err == nil && len(response.Sequences) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return fmt.Errorf("%w: %v", ctx.Err(), err)
+			}
+			return ctx.Err()
+		case <-time.After(defaultWaitInterval):
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/furya-official/mgtool/compose"
+)
+
+// ansi escape codes used to colour log output
+const (
+	ansiReset   = "\x1b[0m"
+	ansiBoldRed = "\x1b[1;31m"
+	ansiRed     = "\x1b[31m"
+	ansiYellow  = "\x1b[33m"
+)
+
+// serviceColors are cycled through to tell services apart in merged logs.
+var serviceColors = []string{"\x1b[36m", "\x1b[32m", "\x1b[35m", "\x1b[34m", "\x1b[33m", "\x1b[96m", "\x1b[92m", "\x1b[95m"}
+
+// logHighlight colours log lines that match a pattern, to make known problems stand out.
+type logHighlight struct {
+	pattern *regexp.Regexp
+	color   string
+	// services limits the highlight to services whose name contains one of these, eg deputy. Empty matches all services.
+	services []string
+}
+
+var logHighlights = []logHighlight{
+	// go panics and tendermint halting on a consensus failure or a pending upgrade
+	{pattern: regexp.MustCompile(`panic:|^goroutine \d+ \[|CONSENSUS FAILURE|UPGRADE NEEDED`), color: ansiBoldRed},
+	// tendermint consensus errors, in the log formats of the different tendermint versions
+	{pattern: regexp.MustCompile(`(^E\[|\bERR\b|level=error|"level":"error").*(module=consensus|"module":"consensus")`), color: ansiRed},
+	{pattern: regexp.MustCompile(`(?i)wrong Block\.Header\.AppHash|conflicting votes`), color: ansiRed},
+	// deputy swaps that couldn't be completed
+	{pattern: regexp.MustCompile(`(?i)swap.*\b(fail(ed|ure)?|error|refund(ed)?)\b`), color: ansiYellow, services: []string{"deputy"}},
+}
+
+// logsOptions configures how testnet logs are printed.
+type logsOptions struct {
+	compose.LogOptions
+	// Grep, if set, only prints lines that match.
+	Grep *regexp.Regexp
+	// Color colours the service prefixes and highlights known errors.
+	Color bool
+}
+
+// logLine is a line of a container's logs.
+type logLine struct {
+	service string
+	time    time.Time
+	text    string
+}
+
+// printLogs prints the logs of a project's containers, prefixed with their service name, like `docker-compose logs`.
+// All containers are included if services is empty. Without Follow the logs are merged in time order, with Follow lines
+// are printed as they arrive until the context is done.
+func printLogs(ctx context.Context, w io.Writer, backend compose.Backend, project compose.Project, services []string, opts logsOptions) error {
+	for _, s := range services {
+		if _, found := project.Config.Services[s]; !found {
+			return fmt.Errorf("no service %s in %s, must be one of %s", s, project.File, strings.Join(project.ServiceNames(), ", "))
+		}
+	}
+	allContainers, err := backend.Containers(ctx, project)
+	if err != nil {
+		return err
+	}
+	var containers []compose.ContainerState
+	width := 0
+	for _, c := range allContainers {
+		if len(services) > 0 && !stringSlice(services).contains(c.Service) {
+			continue
+		}
+		containers = append(containers, c)
+		if len(c.Service) > width {
+			width = len(c.Service)
+		}
+	}
+	if len(containers) == 0 {
+		return fmt.Errorf("no containers found, has the testnet been started?")
+	}
+	prefixes := map[string]string{}
+	for i, c := range containers {
+		prefix := fmt.Sprintf("%-*s |", width, c.Service)
+		if opts.Color {
+			prefix = serviceColors[i%len(serviceColors)] + prefix + ansiReset
+		}
+		prefixes[c.Service] = prefix
+	}
+	printLine := func(line logLine) {
+		if opts.Grep != nil && !opts.Grep.MatchString(line.text) {
+			return
+		}
+		text := line.text
+		if opts.Color {
+			text = highlightLogLine(line.service, text)
+		}
+		fmt.Fprintf(w, "%s %s\n", prefixes[line.service], text)
+	}
+
+	if opts.Follow {
+		var mu sync.Mutex
+		return readLogs(ctx, backend, containers, opts.LogOptions, func(line logLine) {
+			mu.Lock()
+			defer mu.Unlock()
+			printLine(line)
+		})
+	}
+
+	// timestamps are needed to merge the logs, they're only printed if requested
+	readOpts := opts.LogOptions
+	readOpts.Timestamps = true
+	var mu sync.Mutex
+	var lines []logLine
+	err = readLogs(ctx, backend, containers, readOpts, func(line logLine) {
+		line.time, line.text = splitLogTimestamp(line.text, opts.Timestamps)
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, line)
+	})
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].time.Before(lines[j].time) })
+	for _, line := range lines {
+		printLine(line)
+	}
+	return nil
+}
+
+// readLogs streams the logs of containers concurrently, calling handle for each line.
+func readLogs(ctx context.Context, backend compose.Backend, containers []compose.ContainerState, opts compose.LogOptions, handle func(logLine)) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, len(containers))
+	for _, c := range containers {
+		logs, err := backend.Logs(ctx, c.ID, opts)
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func(service string) {
+			defer wg.Done()
+			defer logs.Close()
+			scanner := bufio.NewScanner(logs)
+			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+			for scanner.Scan() {
+				handle(logLine{service: service, text: scanner.Text()})
+			}
+			errs <- scanner.Err()
+		}(c.Service)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitLogTimestamp parses the timestamp docker adds to the start of log lines, optionally removing it.
+// Lines without a timestamp are returned unchanged with a zero time.
+func splitLogTimestamp(line string, keep bool) (time.Time, string) {
+	i := strings.Index(line, " ")
+	if i < 0 {
+		return time.Time{}, line
+	}
+	t, err := time.Parse(time.RFC3339Nano, line[:i])
+	if err != nil {
+		return time.Time{}, line
+	}
+	if keep {
+		return t, line
+	}
+	return t, line[i+1:]
+}
+
+// highlightLogLine colours a line if it matches one of the known problems.
+func highlightLogLine(service, line string) string {
+	for _, h := range logHighlights {
+		if len(h.services) > 0 && !containsAny(service, h.services) {
+			continue
+		}
+		if h.pattern.MatchString(line) {
+			return h.color + line + ansiReset
+		}
+	}
+	return line
+}
+
+func containsAny(s string, substrings []string) bool {
+	for _, sub := range substrings {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTerminal reports if a file is an interactive terminal, so output can be coloured.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,271 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/otiai10/copy"
+
+	"github.com/furya-official/mgtool/compose"
+)
+
+const (
+	// snapshotManifestFile describes the contents of a snapshot folder.
+	snapshotManifestFile = "snapshot.json"
+	// snapshotConfigDir is the copy of the generated config in a snapshot folder.
+	snapshotConfigDir = "config"
+)
+
+var snapshotNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// snapshotManifest records what a snapshot contains so it can be restored.
+type snapshotManifest struct {
+	Name    string         `json:"name"`
+	Created time.Time      `json:"created"`
+	Project string         `json:"project"`
+	Nodes   []snapshotNode `json:"nodes"`
+}
+
+// snapshotNode is the archived data directory of a chain node.
+type snapshotNode struct {
+	Service string `json:"service"`
+	// Home is the node's home directory in the container, the archive holds its data folder.
+	Home string `json:"home"`
+	// Archive is the gzipped tar file in the snapshot folder.
+	Archive string `json:"archive"`
+}
+
+// snapshotPath returns the folder a snapshot is stored in.
+func snapshotPath(snapshotsDir, name string) (string, error) {
+	if !snapshotNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid snapshot name '%s', it can only contain letters, numbers, '.', '_', and '-'", name)
+	}
+	return filepath.Join(snapshotsDir, name), nil
+}
+
+// saveSnapshot stops a testnet, archives its chain nodes' data and its generated config into a snapshot folder,
+// then starts the services that were running again.
+// The data of nodes that mount their data directory from the generated config is saved with the config.
+func saveSnapshot(ctx context.Context, backend compose.Backend, generatedConfigDir, snapshotDir, name string, force bool) (err error) {
+	if _, err := os.Stat(snapshotDir); err == nil && !force {
+		return fmt.Errorf("snapshot %s already exists, use --force to overwrite it", name)
+	}
+	project, err := loadProject(generatedConfigDir)
+	if err != nil {
+		return err
+	}
+	containers, err := backend.Containers(ctx, project)
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return fmt.Errorf("no containers found, has the testnet been started?")
+	}
+	var running []string
+	for _, c := range containers {
+		if c.Running() {
+			running = append(running, c.Service)
+		}
+	}
+
+	// write to a temporary folder so a failed save doesn't leave a partial snapshot, or remove an existing one
+	tempDir := snapshotDir + ".partial"
+	if err := os.RemoveAll(tempDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tempDir, os.ModePerm); err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	if len(running) > 0 {
+		defer func() {
+			fmt.Println("Restarting testnet...")
+			if startErr := backend.Start(ctx, project, running...); startErr != nil {
+				if err == nil {
+					err = startErr
+				} else {
+					fmt.Println(startErr.Error())
+				}
+			}
+		}()
+	}
+	if err := backend.Stop(ctx, project); err != nil {
+		return err
+	}
+
+	manifest := snapshotManifest{Name: name, Created: time.Now().UTC(), Project: project.Name, Nodes: []snapshotNode{}}
+	for _, node := range chainNodes(project) {
+		if hasDataVolume(project.Config.Services[node.Service], node.Home) {
+			continue
+		}
+		container, err := findContainer(containers, node.Service)
+		if err != nil {
+			// services that were never created have no data to save
+			continue
+		}
+		fmt.Printf("Saving %s data...\n", node.Service)
+		archive := node.Service + "-data.tar.gz"
+		if err := saveNodeData(ctx, backend, container.ID, path.Join(node.Home, "data"), filepath.Join(tempDir, archive)); err != nil {
+			return fmt.Errorf("could not save %s data: %w", node.Service, err)
+		}
+		manifest.Nodes = append(manifest.Nodes, snapshotNode{Service: node.Service, Home: node.Home, Archive: archive})
+	}
+
+	if err := copy.Copy(generatedConfigDir, filepath.Join(tempDir, snapshotConfigDir)); err != nil {
+		return fmt.Errorf("could not save generated config: %w", err)
+	}
+	bz, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tempDir, snapshotManifestFile), bz, 0644); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(snapshotDir); err != nil {
+		return err
+	}
+	if err := os.Rename(tempDir, snapshotDir); err != nil {
+		return err
+	}
+	fmt.Printf("Saved snapshot %s to %s\n", name, snapshotDir)
+	return nil
+}
+
+// saveNodeData writes a gzipped tar archive of a directory in a container to a file.
+func saveNodeData(ctx context.Context, backend compose.Backend, containerID, dir, filename string) error {
+	archive, err := backend.CopyFrom(ctx, containerID, dir)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	if _, err := io.Copy(gz, archive); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// hasDataVolume reports if a service mounts a volume over a node's data directory.
+func hasDataVolume(service compose.Service, home string) bool {
+	for _, v := range service.Volumes {
+		parts := strings.Split(v, ":")
+		if len(parts) >= 2 && path.Clean(parts[1]) == path.Join(home, "data") {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreSnapshot replaces the testnet in a generated config folder with the one saved in a snapshot, and starts it.
+func restoreSnapshot(ctx context.Context, backend compose.Backend, generatedConfigDir, snapshotDir string, waitTimeout time.Duration) error {
+	manifest, err := readSnapshotManifest(snapshotDir)
+	if err != nil {
+		return err
+	}
+	if err := removeTestnet(ctx, backend, generatedConfigDir); err != nil {
+		return err
+	}
+	if err := copy.Copy(filepath.Join(snapshotDir, snapshotConfigDir), generatedConfigDir); err != nil {
+		return fmt.Errorf("could not restore generated config: %w", err)
+	}
+	project, err := loadProject(generatedConfigDir)
+	if err != nil {
+		return err
+	}
+
+	// data is copied into the new containers before they start, so the nodes don't initialize fresh state
+	if err := backend.Create(ctx, project); err != nil {
+		return err
+	}
+	for _, node := range manifest.Nodes {
+		fmt.Printf("Restoring %s data...\n", node.Service)
+		if err := restoreNodeData(ctx, backend, project.ContainerName(node.Service), node.Home, filepath.Join(snapshotDir, node.Archive)); err != nil {
+			return fmt.Errorf("could not restore %s data: %w", node.Service, err)
+		}
+	}
+	if err := backend.Up(ctx, project); err != nil {
+		return err
+	}
+	if err := waitForEndpoints(generatedConfigDir, nil, 1, waitTimeout, defaultWaitInterval); err != nil {
+		return err
+	}
+	fmt.Printf("Restored snapshot %s\n", manifest.Name)
+	return nil
+}
+
+// restoreNodeData extracts a gzipped tar archive into a directory in a container.
+func restoreNodeData(ctx context.Context, backend compose.Backend, containerID, dir, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	return backend.CopyTo(ctx, containerID, dir, gz)
+}
+
+// readSnapshotManifest reads the manifest of a snapshot folder.
+func readSnapshotManifest(snapshotDir string) (snapshotManifest, error) {
+	var manifest snapshotManifest
+	bz, err := ioutil.ReadFile(filepath.Join(snapshotDir, snapshotManifestFile))
+	if os.IsNotExist(err) {
+		return manifest, fmt.Errorf("no snapshot found in %s", snapshotDir)
+	}
+	if err != nil {
+		return manifest, err
+	}
+	if err := json.Unmarshal(bz, &manifest); err != nil {
+		return manifest, fmt.Errorf("could not parse %s: %w", snapshotManifestFile, err)
+	}
+	return manifest, nil
+}
+
+// listSnapshots reads the manifests of the snapshots in a folder, oldest first.
+func listSnapshots(snapshotsDir string) ([]snapshotManifest, error) {
+	entries, err := ioutil.ReadDir(snapshotsDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var manifests []snapshotManifest
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		manifest, err := readSnapshotManifest(filepath.Join(snapshotsDir, e.Name()))
+		if err != nil {
+			// skip folders that aren't snapshots, eg partially saved ones
+			continue
+		}
+		manifests = append(manifests, manifest)
+	}
+	sort.Slice(manifests, func(i, j int) bool { return manifests[i].Created.Before(manifests[j].Created) })
+	return manifests, nil
+}
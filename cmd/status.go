@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/furya-official/mgtool/compose"
+)
+
+// serviceStatus describes the state of one of a testnet's services.
+type serviceStatus struct {
+	Service   string   `json:"service" yaml:"service"`
+	Container string   `json:"container" yaml:"container"`
+	Image     string   `json:"image,omitempty" yaml:"image,omitempty"`
+	State     string   `json:"state" yaml:"state"`
+	Status    string   `json:"status,omitempty" yaml:"status,omitempty"`
+	Ports     []string `json:"ports" yaml:"ports"`
+	// Chain is only set for chain nodes that respond to rpc requests.
+	Chain *chainStatus `json:"chain,omitempty" yaml:"chain,omitempty"`
+}
+
+// chainStatus is the sync state of a chain node.
+type chainStatus struct {
+	ChainID    string `json:"chain_id" yaml:"chain_id"`
+	Height     int64  `json:"height" yaml:"height"`
+	CatchingUp bool   `json:"catching_up" yaml:"catching_up"`
+}
+
+// testnetStatus lists the state of every service in a generated config, including services without a container.
+func testnetStatus(ctx context.Context, generatedConfigDir string) ([]serviceStatus, error) {
+	project, backend, err := loadProjectAndBackend(generatedConfigDir)
+	if err != nil {
+		return nil, err
+	}
+	containers, err := backend.Containers(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	byService := map[string]compose.ContainerState{}
+	for _, c := range containers {
+		byService[c.Service] = c
+	}
+	chains := nodeStatuses(generatedConfigDir)
+
+	var statuses []serviceStatus
+	for _, name := range project.ServiceNames() {
+		status := serviceStatus{
+			Service:   name,
+			Container: project.ContainerName(name),
+			Image:     project.Config.Services[name].Image,
+			State:     "not created",
+			Ports:     []string{},
+		}
+		if c, found := byService[name]; found {
+			status.State = c.State
+			status.Status = c.Status
+			status.Ports = formatPorts(c.Ports)
+			if c.Running() {
+				if chain, found := chains[name]; found {
+					status.Chain = &chainStatus{ChainID: chain.ChainID, Height: chain.LatestHeight, CatchingUp: chain.CatchingUp}
+				}
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// formatPorts lists published ports like docker ps, without the duplicate ipv6 bindings.
+func formatPorts(bindings []compose.PortBinding) []string {
+	ports := []string{}
+	seen := map[string]bool{}
+	for _, b := range bindings {
+		if b.HostPort == "" {
+			continue
+		}
+		port := fmt.Sprintf("%s->%s/%s", b.HostPort, b.ContainerPort, b.Protocol)
+		if b.HostIP != "" && b.HostIP != "0.0.0.0" && b.HostIP != "::" {
+			port = b.HostIP + ":" + port
+		}
+		if !seen[port] {
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// printStatus writes service statuses as a table, json, or yaml.
+func printStatus(w io.Writer, statuses []serviceStatus, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		// keep the arrows in port mappings readable
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(statuses)
+	case "yaml":
+		bz, err := yaml.Marshal(statuses)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(bz)
+		return err
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(tw, "SERVICE\tSTATE\tPORTS\tCHAIN ID\tHEIGHT\tCATCHING UP")
+		for _, s := range statuses {
+			chainID, height, catchingUp := "", "", ""
+			if s.Chain != nil {
+				chainID = s.Chain.ChainID
+				height = strconv.FormatInt(s.Chain.Height, 10)
+				catchingUp = strconv.FormatBool(s.Chain.CatchingUp)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", s.Service, s.State, strings.Join(s.Ports, ", "), chainID, height, catchingUp)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown output format '%s', must be one of table, json, or yaml", format)
+	}
+}
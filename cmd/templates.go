@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"gopkg.in/yaml.v3"
+
+	"github.com/furya-official/mgtool/config/generate"
+)
+
+// printTemplates writes template descriptions as a table, json, or yaml.
+func printTemplates(w io.Writer, templates []generate.TemplateInfo, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(templates)
+	case "yaml":
+		bz, err := yaml.Marshal(templates)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(bz)
+		return err
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(tw, "SERVICE\tVERSION\tIMAGE\tCHAIN ID\tDENOM\tHOME")
+		for _, t := range templates {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", t.Service, t.Version, t.Image, t.ChainID, t.Denom, t.HomeDir)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown output format '%s', must be one of table, json, or yaml", format)
+	}
+}
+
+// verifyTemplateGenesis decodes the genesis of mage templates with the mage app, other genesis files are only checked to be json.
+func verifyTemplateGenesis(serviceName string, genesisJSON []byte) error {
+	if serviceName != generate.MageServiceName {
+		return nil
+	}
+	return validateMageGenesis(genesisJSON)
+}
+
+// printComposeChanges generates templates into a temporary folder and prints a diff of how each one changed the
+// generated docker-compose.yaml, so the merged result can be checked without replacing the current config.
+func printComposeChanges(w io.Writer, generateTemplates func(generatedConfigDir string) error) error {
+	dir, err := ioutil.TempDir("", "kvtool-dry-run-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	changes, err := generate.RecordComposeChanges(func() error {
+		return generateTemplates(dir)
+	})
+	if err != nil {
+		return err
+	}
+	for _, c := range changes {
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(c.Before)),
+			B:        difflib.SplitLines(string(c.After)),
+			FromFile: "docker-compose.yaml",
+			ToFile:   "docker-compose.yaml + " + c.Source,
+			Context:  3,
+		})
+		if err != nil {
+			return err
+		}
+		if diff == "" {
+			fmt.Fprintf(w, "%s made no changes\n\n", c.Source)
+			continue
+		}
+		fmt.Fprintln(w, diff)
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestTemplatesVerify(t *testing.T) {
+	// the templates in the repo must pass, genesis files the mage app can't decode are only warnings
+	if err := runTestnetCmd("templates", "verify"); err != nil {
+		t.Fatal(err)
+	}
+}
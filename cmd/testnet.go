@@ -1,37 +1,48 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
-	"syscall"
 	"time"
 
+	"github.com/furya-official/mage/app"
 	"github.com/spf13/cobra"
 
+	"github.com/furya-official/mgtool/compose"
+	"github.com/furya-official/mgtool/config"
 	"github.com/furya-official/mgtool/config/generate"
+	"github.com/furya-official/mgtool/deploy"
+	"github.com/furya-official/mgtool/health"
 )
 
+// images of the relayers used to link the ibc chains, unless overridden with --image
 const (
-	mageServiceName    = "mage"
-	binanceServiceName = "binance"
-	deputyServiceName  = "deputy"
+	defaultRelayerImage = "mage/relayer:v1.0.0"
+	defaultHermesImage  = "mage/hermes:latest"
 )
 
 var (
-	defaultGeneratedConfigDir string = filepath.Join(generate.ConfigTemplatesDir, "../..", "full_configs", "generated")
-
-	supportedServices = []string{mageServiceName, binanceServiceName, deputyServiceName}
+	supportedServices = generate.ServiceNames()
 )
 
 // TestnetCmd cli command for starting mage testnets with docker
 func TestnetCmd() *cobra.Command {
 
 	var generatedConfigDir string
+	defaultGeneratedConfigDir := generate.DefaultGeneratedConfigDir()
+
+	// linking the ibc chains needs docker, so it's added to the generated services' hooks here
+	postStartHooks := map[string][]generate.PostStartHook{
+		generate.RelayerServiceName: {linkIbcChains},
+		generate.HermesServiceName:  {restoreHermesKeys, createHermesChannels},
+	}
 
 	rootCmd := &cobra.Command{
 		Use:     "testnet",
@@ -39,13 +50,18 @@ func TestnetCmd() *cobra.Command {
 		Short:   "Start a default mage and binance local testnet with a deputy. Stop with Ctrl-C and remove with 'testnet down'. Use sub commands for more options.",
 		Long: fmt.Sprintf(`This command helps run local mage testnets composed of various independent processes.
 
-Processes are run via docker-compose. This command generates a docker-compose.yaml and other necessary config files that are synchronized with each so the services all work together.
+Processes are run in docker containers. This command generates a docker-compose.yaml and other necessary config files that are synchronized with each so the services all work together.
+The containers are managed through the docker engine API, so docker-compose doesn't need to be installed, but the generated config can still be used with it.
 
-By default this command will generate configuration for a mgd node and rest server, a binance node and rest server, and a deputy. And then 'run docker-compose up'.
+By default this command will generate configuration for a mgd node and rest server, a binance node and rest server, and a deputy. And then start them, like 'docker-compose up'.
 This is the equivalent of running 'testnet gen-config mage binance deputy' then 'testnet up'.
 
 Docker compose files are (by default) written to %s`, defaultGeneratedConfigDir),
 		Args: cobra.NoArgs,
+		PersistentPreRunE: func(_ *cobra.Command, _ []string) error {
+			// the templates built into kvtool are written out the first time they're needed
+			return generate.ExtractTemplates()
+		},
 		RunE: func(_ *cobra.Command, args []string) error {
 
 			// 1) clear out generated config folder
@@ -58,13 +74,12 @@ Docker compose files are (by default) written to %s`, defaultGeneratedConfigDir)
 				return fmt.Errorf("could not generate config: %v", err)
 			}
 
-			// 3) run docker-compose up
-			cmd := []string{"docker-compose", "--file", filepath.Join(generatedConfigDir, "docker-compose.yaml"), "up"}
-			fmt.Println("running:", strings.Join(cmd, " "))
-			if err := replaceCurrentProcess(cmd...); err != nil {
-				return fmt.Errorf("could not run command: %v", err)
+			// 3) start the services
+			project, backend, err := loadProjectAndBackend(generatedConfigDir)
+			if err != nil {
+				return err
 			}
-			return nil
+			return runForeground(backend, project)
 		},
 	}
 	rootCmd.PersistentFlags().StringVar(&generatedConfigDir, "generated-dir", defaultGeneratedConfigDir, "output directory for the generated config")
@@ -72,6 +87,24 @@ Docker compose files are (by default) written to %s`, defaultGeneratedConfigDir)
 	var mageConfigTemplate string
 	var ibcFlag bool
 	var gethFlag bool
+	var waitTimeout time.Duration
+	var topologyFile string
+	var genesisImport generate.GenesisImport
+	var genesisPatchFile string
+	var fundFlags []string
+	var accountsFile string
+	var validatorCount int
+	var projectName string
+	var portOffset int
+	var buildFrom string
+	var imageFlags []string
+	var dryRun bool
+	var ibcChainCount int
+	var ibcChannelFlags []string
+	var ibcRelayer string
+	var gethFlags gethGenesisFlags
+	var deployManifest string
+	var deployerKey string
 
 	genConfigCmd := &cobra.Command{
 		Use:   "gen-config services_to_include...",
@@ -79,11 +112,146 @@ Docker compose files are (by default) written to %s`, defaultGeneratedConfigDir)
 		Long: fmt.Sprintf(`Generate a docker-compose.yaml file and any other necessary config files needed by services.
 
 available services: %s
+
+Services that the listed ones depend on are included too, eg 'gen-config deputy' also generates mage and binance.
+
+Instead of listing services, a topology file can be passed with --from. It lists the services to include, their template versions, port overrides, images, and extra environment variables:
+
+services:
+  - name: mage
+    version: v0.16
+    ports:
+      "26657": "36657"
+  - name: binance
+  - name: deputy
+    image: mage/deputy:v0.5.0
+    env:
+      LOG_LEVEL: debug
+
+The mage genesis can be edited with --genesis-patch. The patch file lists operations that are applied in order:
+
+- set: app_state.gov.voting_params.voting_period
+  value: "60s"
+- merge: app_state.bep3.params
+  value:
+    min_block_lock: "50"
+- delete: app_state.auth.accounts.0
 `, supportedServices),
-		Example:   "gen-config mage binance deputy --mage.configTemplate v0.10",
+		Example: `gen-config mage binance deputy --mage.configTemplate v0.10
+gen-config --from kvtool.yaml
+gen-config mage --genesis-from magenode-mage_2222-10-1000.json
+gen-config mage --genesis-patch patch.yaml
+gen-config mage --validators 4
+gen-config mage --build-from ../mage
+gen-config mage --build-from feature/my-branch
+gen-config mage binance deputy --image deputy=mage/deputy:v0.5.0
+gen-config mage --project-name second --port-offset 1000 --generated-dir ./second
+gen-config mage binance deputy --dry-run
+gen-config mage relayer --ibc-chains 2 --ibc-channel transfer=transfer --ibc-channel ibcnode:transfer=ibcnode-1:transfer
+gen-config mage --fund mage1ypjp0m04pyp73hwgtc0dgkx0e9rrydec59k7y9=1000000000umage,1000000usdx
+gen-config mage geth --geth-chain-id 1337 --geth-block-time 2s --geth-drop-exported-state --geth-fund 0x21e360e198cde35740e88572b59f2cade421e6b1=1000000000000000000000
+gen-config mage geth --geth-drop-exported-state --geth-contract 0x1000000000000000000000000000000000000001=evm/artifacts/contracts/token/Token.sol/Token.json`,
 		ValidArgs: supportedServices,
-		Args:      Minimum1ValidArgs,
-		RunE: func(_ *cobra.Command, args []string) error {
+		Args: func(cmd *cobra.Command, args []string) error {
+			if topologyFile != "" {
+				return cobra.NoArgs(cmd, args)
+			}
+			return Minimum1ValidArgs(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			var genesisPatch generate.GenesisPatch
+			if genesisPatchFile != "" {
+				var err error
+				genesisPatch, err = generate.LoadGenesisPatch(genesisPatchFile)
+				if err != nil {
+					return err
+				}
+			}
+
+			if projectName != "" {
+				if err := generate.ValidateProjectName(projectName); err != nil {
+					return err
+				}
+			}
+			if validatorCount < 1 {
+				return fmt.Errorf("--validators must be at least 1")
+			}
+			if validatorCount > 1 && genesisImport.ExportFile != "" {
+				return fmt.Errorf("--validators can't be used with --genesis-from, an imported genesis only has the template's validator")
+			}
+			if err := generate.CheckVersion(generate.MageServiceName, mageConfigTemplate); err != nil {
+				return fmt.Errorf("invalid --mage.configTemplate: %w", err)
+			}
+			ibcChannels, err := parseIbcFlags(ibcChainCount, ibcChannelFlags)
+			if err != nil {
+				return err
+			}
+			gethGenesis, err := gethFlags.parse()
+			if err != nil {
+				return err
+			}
+
+			images, err := parseImageFlags(imageFlags, buildFrom)
+			if err != nil {
+				return err
+			}
+
+			var fundedAccounts []generate.FundedAccount
+			for _, f := range fundFlags {
+				account, err := parseFundFlag(f)
+				if err != nil {
+					return err
+				}
+				fundedAccounts = append(fundedAccounts, account)
+			}
+			if accountsFile != "" {
+				accounts, err := loadAccountsFile(accountsFile)
+				if err != nil {
+					return err
+				}
+				fundedAccounts = append(fundedAccounts, accounts...)
+			}
+
+			var topology generate.Topology
+			if topologyFile != "" {
+				for _, flag := range []string{"mage.configTemplate", "ibc", "geth"} {
+					if cmd.Flags().Changed(flag) {
+						return fmt.Errorf("--%s can't be used with --from, set it in the topology file instead", flag)
+					}
+				}
+				topology, err = generate.LoadTopology(topologyFile)
+				if err != nil {
+					return err
+				}
+				if topology.Services, err = applyImageOverrides(topology.Services, images); err != nil {
+					return err
+				}
+			}
+
+			generateTemplates := func(dir string) error {
+				if topologyFile != "" {
+					return generate.GenerateFromTopology(topology, dir)
+				}
+				services := args
+				if ibcFlag {
+					services = append(services, generate.IbcServiceName)
+				}
+				if gethFlag {
+					services = append(services, generate.GethServiceName)
+				}
+				configs, err := serviceConfigs(services, mageConfigTemplate)
+				if err != nil {
+					return err
+				}
+				if configs, err = applyImageOverrides(configs, images); err != nil {
+					return err
+				}
+				return generate.GenerateServices(configs, dir)
+			}
+			if dryRun {
+				return printComposeChanges(os.Stdout, generateTemplates)
+			}
 
 			// 1) clear out generated config folder
 			if err := os.RemoveAll(generatedConfigDir); err != nil {
@@ -91,56 +259,130 @@ available services: %s
 			}
 
 			// 2) generate a complete docker-compose config
-			if stringSlice(args).contains(mageServiceName) {
-				if err := generate.GenerateMageConfig(mageConfigTemplate, generatedConfigDir); err != nil {
+			if err := generateTemplates(generatedConfigDir); err != nil {
+				return err
+			}
+
+			// 3) run the mage services from a local build
+			if buildFrom != "" {
+				if err := buildLocalMage(generatedConfigDir, buildFrom); err != nil {
 					return err
 				}
 			}
-			if stringSlice(args).contains(binanceServiceName) {
-				if err := generate.GenerateBnbConfig(generatedConfigDir); err != nil {
-					return err
+
+			// 4) replace the mage genesis with an exported one
+			if genesisImport.ExportFile != "" {
+				if err := generate.ImportGenesis(genesisImport, generatedConfigDir); err != nil {
+					return fmt.Errorf("could not import genesis: %w", err)
 				}
 			}
-			if stringSlice(args).contains(deputyServiceName) {
-				if err := generate.GenerateDeputyConfig(generatedConfigDir); err != nil {
-					return err
+
+			// 5) add funded accounts to the mage genesis
+			if len(fundedAccounts) > 0 {
+				if err := generate.FundAccounts(fundedAccounts, generatedConfigDir, validateMageGenesis); err != nil {
+					return fmt.Errorf("could not fund accounts: %w", err)
 				}
 			}
-			if ibcFlag {
-				if err := generate.GenerateIbcChainConfig(generatedConfigDir); err != nil {
-					return err
+
+			// 6) apply any edits to the mage genesis
+			if genesisPatchFile != "" {
+				if err := generate.PatchGenesis(genesisPatch, generatedConfigDir, validateMageGenesis); err != nil {
+					return fmt.Errorf("could not patch genesis: %w", err)
 				}
 			}
-			if gethFlag {
-				if err := generate.GenerateGethConfig(generatedConfigDir); err != nil {
+
+			// 7) apply any changes to the geth genesis
+			if !gethGenesis.Empty() {
+				if err := configureGethGenesis(generatedConfigDir, gethGenesis); err != nil {
 					return err
 				}
 			}
 
-			return nil
+			// 8) add validators, last so they all share the final genesis
+			if validatorCount > 1 {
+				if err := generateValidators(generatedConfigDir, validatorCount); err != nil {
+					return fmt.Errorf("could not generate validators: %w", err)
+				}
+			}
+
+			// 9) add any extra ibc chains and the channels the relayer opens between them
+			if err := generateIbcChains(generatedConfigDir, ibcChainCount, ibcChannels); err != nil {
+				return fmt.Errorf("could not generate ibc chains: %w", err)
+			}
+
+			// 10) name the project and move its ports so it can run alongside other testnets
+			return generate.ConfigureProject(generatedConfigDir, projectName, portOffset)
 		},
 	}
-	genConfigCmd.Flags().StringVar(&mageConfigTemplate, "mage.configTemplate", "master", "the directory name of the template used to generating the mage config")
+	genConfigCmd.Flags().StringVar(&mageConfigTemplate, "mage.configTemplate", "master", "the directory name of the template used to generating the mage config, see 'testnet templates list'")
 	genConfigCmd.Flags().BoolVar(&ibcFlag, "ibc", false, "flag for if ibc is enabled")
 	genConfigCmd.Flags().BoolVar(&gethFlag, "geth", false, "flag for if geth node is enabled")
+	addIbcFlags(genConfigCmd, &ibcChainCount, &ibcChannelFlags)
+	addGethFlags(genConfigCmd, &gethFlags)
+	genConfigCmd.Flags().StringVar(&topologyFile, "from", "", "path to a topology file (eg kvtool.yaml) listing the services to generate config for")
+	genConfigCmd.Flags().StringVar(&genesisImport.ExportFile, "genesis-from", "", "path to an exported genesis (eg from 'testnet export') for the mage node to start from")
+	addGenesisImportFlags(genConfigCmd, &genesisImport)
+	genConfigCmd.Flags().StringArrayVar(&fundFlags, "fund", nil, "fund an account in the mage genesis, in the form <address|mnemonic>=<coins>. Can be repeated")
+	genConfigCmd.Flags().StringVar(&accountsFile, "accounts-file", "", "path to a yaml file listing accounts to fund in the mage genesis")
+	genConfigCmd.Flags().IntVar(&validatorCount, "validators", 1, "number of mage validator nodes to run. Creating more than one needs docker to generate their keys")
+	addProjectFlags(genConfigCmd, &projectName, &portOffset)
+	addBuildFromFlag(genConfigCmd, &buildFrom)
+	addImageFlag(genConfigCmd, &imageFlags)
+	genConfigCmd.Flags().StringVar(&genesisPatchFile, "genesis-patch", "", "path to a yaml file of set, merge, and delete operations to apply to the mage genesis")
+	genConfigCmd.Flags().BoolVar(&dryRun, "dry-run", false, "print how each template changes the generated docker-compose.yaml without writing any config")
 	rootCmd.AddCommand(genConfigCmd)
 
+	importCmd := &cobra.Command{
+		Use:   "import export.json",
+		Short: "Generate a mage testnet that starts from an exported genesis.",
+		Long: `Generate config for a mage node that starts from the state in a genesis file made by 'testnet export' or 'mage export'.
+
+The exported chain's highest power validator is replaced with the template's validator, and given enough voting power to produce blocks on its own.
+The chain id and genesis time are reset. This is the equivalent of running 'testnet gen-config mage --genesis-from export.json'.`,
+		Example: "import magenode-mage_2222-10-1000.json --mage.configTemplate v0.16",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			genesisImport.ExportFile = args[0]
+			if err := generate.CheckVersion(generate.MageServiceName, mageConfigTemplate); err != nil {
+				return fmt.Errorf("invalid --mage.configTemplate: %w", err)
+			}
+
+			if err := os.RemoveAll(generatedConfigDir); err != nil {
+				return fmt.Errorf("could not clear old generated config: %v", err)
+			}
+			configs, err := serviceConfigs([]string{generate.MageServiceName}, mageConfigTemplate)
+			if err != nil {
+				return err
+			}
+			if err := generate.GenerateServices(configs, generatedConfigDir); err != nil {
+				return err
+			}
+			if err := generate.ImportGenesis(genesisImport, generatedConfigDir); err != nil {
+				return fmt.Errorf("could not import genesis: %w", err)
+			}
+			fmt.Println("generated config, start the testnet with 'testnet up'")
+			return nil
+		},
+	}
+	importCmd.Flags().StringVar(&mageConfigTemplate, "mage.configTemplate", "master", "the directory name of the template used to generating the mage config, see 'testnet templates list'")
+	addGenesisImportFlags(importCmd, &genesisImport)
+	rootCmd.AddCommand(importCmd)
+
 	var runDetachedFlag bool
 
 	upCmd := &cobra.Command{
 		Use:   "up",
-		Short: "A convenience command that runs `docker-compose up` on the generated config.",
+		Short: "A convenience command that starts the generated config, like `docker-compose up`.",
 		Args:  cobra.NoArgs,
 		RunE: func(_ *cobra.Command, _ []string) error {
-			cmd := []string{"docker-compose", "--file", filepath.Join(generatedConfigDir, "docker-compose.yaml"), "up"}
-			if runDetachedFlag {
-				cmd = append(cmd, "-d")
+			project, backend, err := loadProjectAndBackend(generatedConfigDir)
+			if err != nil {
+				return err
 			}
-			fmt.Println("running:", strings.Join(cmd, " "))
-			if err := replaceCurrentProcess(cmd...); err != nil {
-				return fmt.Errorf("could not run command: %v", err)
+			if runDetachedFlag {
+				return backend.Up(context.Background(), project)
 			}
-			return nil
+			return runForeground(backend, project)
 		},
 	}
 	upCmd.Flags().BoolVarP(&runDetachedFlag, "detach", "d", false, "Detached mode: Run containers in the background.")
@@ -148,256 +390,671 @@ available services: %s
 
 	downCmd := &cobra.Command{
 		Use:   "down",
-		Short: "A convenience command that runs `docker-compose down` on the generated config.",
+		Short: "A convenience command that stops and removes the generated config's containers, like `docker-compose down`.",
 		Args:  cobra.NoArgs,
 		RunE: func(_ *cobra.Command, _ []string) error {
-			cmd := []string{"docker-compose", "--file", filepath.Join(generatedConfigDir, "docker-compose.yaml"), "down"}
-			fmt.Println("running:", strings.Join(cmd, " "))
-			if err := replaceCurrentProcess(cmd...); err != nil {
-				return fmt.Errorf("could not run command: %v", err)
+			project, backend, err := loadProjectAndBackend(generatedConfigDir)
+			if err != nil {
+				return err
 			}
-			return nil
+			return backend.Down(context.Background(), project)
 		},
 	}
 	rootCmd.AddCommand(downCmd)
 
 	bootstrapCmd := &cobra.Command{
-		Use:     "bootstrap",
-		Short:   "A convenience command that creates a mage testnet with the input configTemplate (defaults to master)",
-		Example: "bootstrap --mage.configTemplate v0.12",
-		Args:    cobra.NoArgs,
-		RunE: func(_ *cobra.Command, _ []string) error {
-			cmd := exec.Command("docker-compose", "--file", filepath.Join(generatedConfigDir, "docker-compose.yaml"), "down")
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			// check that dockerfile exists before calling 'docker-compose down down'
-			if _, err := os.Stat(filepath.Join(generatedConfigDir, "docker-compose.yaml")); err == nil {
-				if err2 := cmd.Run(); err2 != nil {
-					return err2
+		Use:   "bootstrap",
+		Short: "A convenience command that creates a mage testnet with the input configTemplate (defaults to master)",
+		Example: `bootstrap --mage.configTemplate v0.12
+bootstrap --ibc --ibc-chains 3 --ibc-channel transfer=transfer --ibc-channel ibcnode:transfer=ibcnode-1:transfer
+bootstrap --ibc --relayer go-relayer
+bootstrap --geth --geth-chain-id 1337 --geth-drop-exported-state --geth-fund 0x21e360e198cde35740e88572b59f2cade421e6b1=1000000000000000000000
+bootstrap --deploy-contracts contracts.yaml`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if projectName != "" {
+				if err := generate.ValidateProjectName(projectName); err != nil {
+					return err
 				}
 			}
-			if err := os.RemoveAll(generatedConfigDir); err != nil {
-				return fmt.Errorf("could not clear old generated config: %v", err)
+			if err := generate.CheckVersion(generate.MageServiceName, mageConfigTemplate); err != nil {
+				return fmt.Errorf("invalid --mage.configTemplate: %w", err)
 			}
-			if err := generate.GenerateMageConfig(mageConfigTemplate, generatedConfigDir); err != nil {
+			if !ibcFlag && (cmd.Flags().Changed("ibc-chains") || cmd.Flags().Changed("ibc-channel") || cmd.Flags().Changed("relayer")) {
+				return fmt.Errorf("--ibc-chains, --ibc-channel, and --relayer need --ibc")
+			}
+			ibcChannels, err := parseIbcFlags(ibcChainCount, ibcChannelFlags)
+			if err != nil {
 				return err
 			}
-			if ibcFlag {
-				if err := generate.GenerateIbcChainConfig(generatedConfigDir); err != nil {
+			relayerService, err := relayerServiceName(ibcRelayer)
+			if err != nil {
+				return err
+			}
+			gethGenesis, err := gethFlags.parse()
+			if err != nil {
+				return err
+			}
+			if !gethFlag && !gethGenesis.Empty() {
+				return fmt.Errorf("--geth-chain-id, --geth-block-time, --geth-fund, and --geth-contract need --geth")
+			}
+			var contracts []deploy.Contract
+			var deployer *deploy.Key
+			if deployManifest != "" {
+				if contracts, err = deploy.LoadManifest(deployManifest); err != nil {
 					return err
 				}
-				if err := generate.GenerateHermesRelayerConfig(generatedConfigDir); err != nil {
-					return err
+				if deployer, err = deploy.ParseKey(deployerKey); err != nil {
+					return fmt.Errorf("invalid --deployer-key: %w", err)
 				}
-				if err := generate.GenerateGoRelayerConfig(generatedConfigDir); err != nil {
+			} else if cmd.Flags().Changed("deployer-key") {
+				return fmt.Errorf("--deployer-key needs --deploy-contracts")
+			}
+			images, err := parseImageFlags(imageFlags, buildFrom)
+			if err != nil {
+				return err
+			}
+			ctx := context.Background()
+			backend, err := newBackend()
+			if err != nil {
+				return err
+			}
+			if err := removeTestnet(ctx, backend, generatedConfigDir); err != nil {
+				return err
+			}
+			services := []string{generate.MageServiceName}
+			if ibcFlag {
+				services = append(services, generate.IbcServiceName, relayerService)
+			}
+			if gethFlag {
+				services = append(services, generate.GethServiceName)
+			}
+			configs, err := serviceConfigs(services, mageConfigTemplate)
+			if err != nil {
+				return err
+			}
+			if configs, err = applyImageOverrides(configs, images); err != nil {
+				return err
+			}
+			if err := generate.GenerateServices(configs, generatedConfigDir); err != nil {
+				return err
+			}
+			if ibcFlag {
+				if err := generateIbcChains(generatedConfigDir, ibcChainCount, ibcChannels); err != nil {
+					return fmt.Errorf("could not generate ibc chains: %w", err)
+				}
+			}
+			if !gethGenesis.Empty() {
+				if err := configureGethGenesis(generatedConfigDir, gethGenesis); err != nil {
 					return err
 				}
 			}
-			if gethFlag {
-				if err := generate.GenerateGethConfig(generatedConfigDir); err != nil {
+			if buildFrom != "" {
+				if err := buildLocalMage(generatedConfigDir, buildFrom); err != nil {
 					return err
 				}
 			}
-
-			cmd = exec.Command("docker-compose", "--file", filepath.Join(generatedConfigDir, "docker-compose.yaml"), "pull")
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			if err := cmd.Run(); err != nil {
-				fmt.Println(err.Error())
+			if err := generate.ConfigureProject(generatedConfigDir, projectName, portOffset); err != nil {
+				return err
+			}
+			if deployManifest != "" {
+				// check the template has an EVM before starting it
+				if _, err := mageEVMURL(generatedConfigDir); err != nil {
+					return err
+				}
 			}
 
-			upCmd := exec.Command("docker-compose", "--file", filepath.Join(generatedConfigDir, "docker-compose.yaml"), "up", "-d")
-			upCmd.Stdout = os.Stdout
-			upCmd.Stderr = os.Stderr
-			if err := upCmd.Run(); err != nil {
-				fmt.Println(err.Error())
+			if err := startTestnet(ctx, backend, generatedConfigDir, waitTimeout); err != nil {
+				return err
 			}
 			if ibcFlag {
 				fmt.Printf("Starting ibc connection between chains...\n")
-				time.Sleep(time.Second * 7)
-				restoreKeys1Cmd := exec.Command("docker", "run", "-v", fmt.Sprintf("%s:%s", filepath.Join(generatedConfigDir, "hermes"), "/home/hermes/.hermes"), "mage/hermes:latest", "keys", "restore", "magelocalnet_8888-1", "-n", "testkey", "-m", "very health column only surface project output absent outdoor siren reject era legend legal twelve setup roast lion rare tunnel devote style random food", "--hd-path", "m/44'/459'/0'/0/0")
-				restoreKeys1Cmd.Stdout = os.Stdout
-				restoreKeys1Cmd.Stderr = os.Stderr
-				if err := restoreKeys1Cmd.Run(); err != nil {
-					fmt.Println(err.Error())
-				}
-				restoreKeys2Cmd := exec.Command("docker", "run", "-v", fmt.Sprintf("%s:%s", filepath.Join(generatedConfigDir, "hermes"), "/home/hermes/.hermes"), "mage/hermes:latest", "keys", "restore", "mage-localnet-2", "-n", "testkey", "-m", "very health column only surface project output absent outdoor siren reject era legend legal twelve setup roast lion rare tunnel devote style random food", "--hd-path", "m/44'/459'/0'/0/0")
-				restoreKeys2Cmd.Stdout = os.Stdout
-				restoreKeys2Cmd.Stderr = os.Stderr
-				if err := restoreKeys2Cmd.Run(); err != nil {
-					fmt.Println(err.Error())
-				}
-				generatePathCmd := exec.Command("docker", "run", "-v", fmt.Sprintf("%s:%s", filepath.Join(generatedConfigDir, "relayer"), "/relayer/.relayer"), "--network", "generated_default", "mage/relayer:v1.0.0", "paths", "generate", "mage-localnet-2", "magelocalnet_8888-1", "transfer", "--port", "transfer")
-				generatePathCmd.Stdout = os.Stdout
-				generatePathCmd.Stderr = os.Stderr
-				if err := generatePathCmd.Run(); err != nil {
-					fmt.Println(err.Error())
-				}
-				openConnectionCmd := exec.Command("docker", "run", "-v", fmt.Sprintf("%s:%s", filepath.Join(generatedConfigDir, "relayer"), "/relayer/.relayer"), "--network", "generated_default", "mage/relayer:v1.0.0", "tx", "link", "transfer", "-d", "-o", "3s")
-				openConnectionCmd.Stdout = os.Stdout
-				openConnectionCmd.Stderr = os.Stderr
-				if err := openConnectionCmd.Run(); err != nil {
-					fmt.Println(err.Error())
-				}
-				fmt.Printf("IBC connection complete, starting relayer process...\n")
-				time.Sleep(time.Second * 5)
-				err := generate.AddHermesRelayerToNetwork(generatedConfigDir)
-				if err != nil {
-					return err
-				}
-				restartCmd := exec.Command("docker-compose", "--file", filepath.Join(generatedConfigDir, "docker-compose.yaml"), "up", "-d", "hermes-relayer")
-				restartCmd.Stdout = os.Stdout
-				restartCmd.Stderr = os.Stderr
-
-				err = restartCmd.Run()
+			}
+			if err := generate.RunPostStartHooks(services, generatedConfigDir, postStartHooks); err != nil {
+				return err
+			}
+			if ibcFlag {
+				// start any services added by the hooks
+				project, err := loadProject(generatedConfigDir)
 				if err != nil {
 					return err
 				}
-				pruneCmd := exec.Command("docker", "container", "prune", "-f")
-				pruneCmd.Stdout = os.Stdout
-				pruneCmd.Stderr = os.Stderr
-				err = pruneCmd.Run()
-				if err != nil {
+				if err := backend.Up(ctx, project); err != nil {
 					return err
 				}
 				fmt.Printf("IBC relayer ready!\n")
 			}
+			if deployManifest != "" {
+				if err := deployContracts(generatedConfigDir, deployer, contracts, waitTimeout); err != nil {
+					return fmt.Errorf("could not deploy contracts: %w", err)
+				}
+			}
 			return nil
 		},
 	}
-	bootstrapCmd.Flags().StringVar(&mageConfigTemplate, "mage.configTemplate", "master", "the directory name of the template used to generating the mage config")
+	bootstrapCmd.Flags().StringVar(&mageConfigTemplate, "mage.configTemplate", "master", "the directory name of the template used to generating the mage config, see 'testnet templates list'")
 	bootstrapCmd.Flags().BoolVar(&ibcFlag, "ibc", false, "flag for if ibc is enabled")
+	addIbcFlags(bootstrapCmd, &ibcChainCount, &ibcChannelFlags)
+	bootstrapCmd.Flags().StringVar(&ibcRelayer, "relayer", hermesRelayer, "the relayer that opens the ibc channels and relays packets, one of hermes or go-relayer")
 	bootstrapCmd.Flags().BoolVar(&gethFlag, "geth", false, "flag for if geth is enabled")
+	addGethFlags(bootstrapCmd, &gethFlags)
+	bootstrapCmd.Flags().StringVar(&deployManifest, "deploy-contracts", "", "path to a yaml manifest of compiled contracts to deploy to the mage EVM once it's ready. Their addresses are written to deployments.json in the generated config folder")
+	bootstrapCmd.Flags().StringVar(&deployerKey, "deployer-key", defaultDeployerKey, "hex private key of the funded account that deploys the --deploy-contracts")
+	addProjectFlags(bootstrapCmd, &projectName, &portOffset)
+	addBuildFromFlag(bootstrapCmd, &buildFrom)
+	addImageFlag(bootstrapCmd, &imageFlags)
+	bootstrapCmd.Flags().DurationVar(&waitTimeout, "wait-timeout", defaultWaitTimeout, "how long to wait for the testnet to be ready before failing")
 	rootCmd.AddCommand(bootstrapCmd)
 
-	exportCmd := &cobra.Command{
-		Use:     "export",
-		Short:   "Pauses the current mage testnet, exports the current mage testnet state to a JSON file, then restarts the testnet.",
-		Example: "export",
-		Args:    cobra.NoArgs,
+	var upgradeFrom string
+	var upgradeTo string
+	var upgradeName string
+	var upgradeHeight int64
+	var votingPeriod time.Duration
+	upgradeRehearsalCmd := &cobra.Command{
+		Use:   "upgrade-rehearsal",
+		Short: "Rehearse a software upgrade of the mage chain from one template version to another.",
+		Long: `Start a mage testnet from an old template version, then upgrade it to a new one the same way a live network would.
+
+The testnet is bootstrapped with the --from template and the node's data directory is kept in the generated config folder so it survives the upgrade.
+The template's validator proposes a software upgrade at --height and votes for it, using the mnemonic in config/common/addresses.yaml.
+The governance voting period is shortened so the proposal passes before the upgrade height.
+Once the chain halts for the upgrade, the mage node is restarted with the --to template's image, and the rehearsal succeeds if blocks resume.
+
+Only the image changes, the node keeps the --from template's config files. The upgrade name (defaults to --to) must match an upgrade handler in the new version.`,
+		Example: `upgrade-rehearsal --from v0.16 --to master --height 50
+upgrade-rehearsal --from v0.16 --to master --height 50 --name v0.17.0`,
+		Args: cobra.NoArgs,
 		RunE: func(_ *cobra.Command, _ []string) error {
-			cmd := exec.Command("docker-compose", "--file", filepath.Join(generatedConfigDir, "docker-compose.yaml"), "stop")
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
+			if upgradeHeight < 1 {
+				return fmt.Errorf("--height must be set to the block height to upgrade at")
+			}
+			if upgradeName == "" {
+				upgradeName = upgradeTo
+			}
+			newImage, err := generate.TemplateImage(generate.MageServiceName, upgradeTo, generate.MageNodeService)
+			if err != nil {
+				return err
+			}
+			validator, err := mageValidator()
+			if err != nil {
+				return err
+			}
+			ctx := context.Background()
+			backend, err := newBackend()
+			if err != nil {
+				return err
+			}
 
-			err := cmd.Run()
+			// 1) bootstrap the old version, keeping the chain data outside the container so it can be recreated with the new image
+			if err := removeTestnet(ctx, backend, generatedConfigDir); err != nil {
+				return err
+			}
+			configs, err := serviceConfigs([]string{generate.MageServiceName}, upgradeFrom)
 			if err != nil {
 				return err
 			}
-			// docker ps -aqf "name=containername"
-			mageContainerIDCmd := exec.Command("docker", "ps", "-aqf", "name=generated_magenode_1")
-			mageContainer, err := mageContainerIDCmd.Output()
+			if err := generate.GenerateServices(configs, generatedConfigDir); err != nil {
+				return err
+			}
+			votingPeriodPatch := generate.GenesisPatch{{
+				Set:   "app_state.gov.voting_params.voting_period",
+				Value: fmt.Sprintf("%ds", int64(votingPeriod.Seconds())),
+			}}
+			if err := generate.PatchGenesis(votingPeriodPatch, generatedConfigDir, validateMageGenesis); err != nil {
+				return fmt.Errorf("could not set the voting period: %w", err)
+			}
+			if err := generate.PersistNodeData(generatedConfigDir, generate.MageNodeService); err != nil {
+				return err
+			}
+			if err := startTestnet(ctx, backend, generatedConfigDir, waitTimeout); err != nil {
+				return err
+			}
+
+			// 2) pass an upgrade proposal and wait for the chain to halt
+			chain, err := loadUpgradeChain(generatedConfigDir)
 			if err != nil {
 				return err
 			}
+			if err := proposeUpgrade(ctx, chain, validator.Mnemonic, upgradeName, upgradeHeight); err != nil {
+				return err
+			}
+			fmt.Printf("waiting for the chain to halt at height %d\n", upgradeHeight)
+			if err := waitForUpgradeHalt(ctx, chain.rpcURL, upgradeHeight, waitTimeout); err != nil {
+				return err
+			}
 
-			ibcChainContainerIDCmd := exec.Command("docker", "ps", "-aqf", "name=generated_ibcnode_1")
-			ibcContainer, err := ibcChainContainerIDCmd.Output()
+			// 3) restart the node with the new version
+			if err := backend.Stop(ctx, chain.project, generate.MageNodeService); err != nil {
+				return err
+			}
+			if _, err := generate.SetMageImage(generatedConfigDir, newImage); err != nil {
+				return err
+			}
+			project, err := loadProject(generatedConfigDir)
 			if err != nil {
 				return err
 			}
+			fmt.Printf("upgrading to %s\n", newImage)
+			if err := backend.Up(ctx, project); err != nil {
+				return err
+			}
+			waitCtx, cancel := context.WithTimeout(ctx, waitTimeout)
+			defer cancel()
+			if err := health.Wait(waitCtx, defaultWaitInterval, health.BlocksProduced(chain.rpcURL, upgradeHeight+1)); err != nil {
+				return fmt.Errorf("blocks did not resume after the upgrade: %w", err)
+			}
+			fmt.Printf("upgrade %s succeeded, blocks resumed after height %d\n", upgradeName, upgradeHeight)
+			return nil
+		},
+	}
+	upgradeRehearsalCmd.Flags().StringVar(&upgradeFrom, "from", "v0.16", "the mage template version to start the chain with")
+	upgradeRehearsalCmd.Flags().StringVar(&upgradeTo, "to", "master", "the mage template version whose image the chain is upgraded to")
+	upgradeRehearsalCmd.Flags().StringVar(&upgradeName, "name", "", "name of the upgrade plan, defaults to the --to version")
+	upgradeRehearsalCmd.Flags().Int64Var(&upgradeHeight, "height", 0, "block height to upgrade at, it must leave time for the voting period to end")
+	upgradeRehearsalCmd.Flags().DurationVar(&votingPeriod, "voting-period", 20*time.Second, "governance voting period set in the genesis")
+	upgradeRehearsalCmd.Flags().DurationVar(&waitTimeout, "wait-timeout", defaultWaitTimeout, "how long to wait for the testnet to start, or for blocks to resume after the upgrade, before failing")
+	rootCmd.AddCommand(upgradeRehearsalCmd)
+
+	var waitHeight int64
+	var waitInterval time.Duration
+
+	waitCmd := &cobra.Command{
+		Use:   "wait [compose_services...]",
+		Short: "Wait until the running testnet's chains are producing blocks and their endpoints respond.",
+		Long: `Poll the endpoints published by the generated config until they're ready, exiting with an error if the timeout is reached.
+Chain rpc endpoints are ready once they've reached the minimum height. REST, grpc, and EVM JSON-RPC endpoints are ready once they respond.
+
+By default all endpoints are checked. Pass docker-compose service names (eg magenode) to only check those.`,
+		Example: "wait magenode --height 5 --timeout 1m",
+		RunE: func(_ *cobra.Command, args []string) error {
+			if err := waitForEndpoints(generatedConfigDir, args, waitHeight, waitTimeout, waitInterval); err != nil {
+				return err
+			}
+			fmt.Println("testnet ready")
+			return nil
+		},
+	}
+	waitCmd.Flags().Int64Var(&waitHeight, "height", 1, "minimum block height chains must reach")
+	waitCmd.Flags().DurationVar(&waitTimeout, "timeout", defaultWaitTimeout, "how long to wait before failing")
+	waitCmd.Flags().DurationVar(&waitInterval, "interval", defaultWaitInterval, "how often to poll endpoints")
+	rootCmd.AddCommand(waitCmd)
 
-			makeNewMageImageCmd := exec.Command("docker", "commit", strings.TrimSpace(string(mageContainer)), "mage-export-temp")
+	var ibcCheck ibcCheckOptions
+	ibcCheckCmd := &cobra.Command{
+		Use:   "ibc-check",
+		Short: "Check the relayer delivers an ibc transfer from mage to an ibc chain.",
+		Long: `Send an ICS-20 transfer from the mage validator to an ibc chain of a testnet started with --ibc, and wait for the packet to be received and acknowledged.
+The receiver's balance of the ibc/<hash> voucher on the ibc chain is then checked to have increased by the amount sent.
 
-			mageImageOutput, err := makeNewMageImageCmd.Output()
+The transfer is sent over the open transfer channel to --chain-id, unless --channel is set. The channel, packet sequence,
+and the time taken for the packet to be received and acknowledged are printed. It exits with an error if the transfer isn't delivered before the timeout.`,
+		Example: `ibc-check
+ibc-check --chain-id mage-localnet-3 --amount 5000umage --timeout 5m`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			transfer, err := checkIbcTransfer(generatedConfigDir, ibcCheck)
 			if err != nil {
 				return err
 			}
+			fmt.Printf("ibc transfer succeeded\n")
+			fmt.Printf("channel:      %s (%s on %s)\n", transfer.Channel, transfer.CounterpartyChannel, ibcCheck.ChainID)
+			fmt.Printf("sequence:     %d\n", transfer.Sequence)
+			fmt.Printf("received:     %s\n", transfer.Received.Round(time.Millisecond))
+			fmt.Printf("acknowledged: %s\n", transfer.Acknowledged.Round(time.Millisecond))
+			fmt.Printf("balance:      %s%s\n", transfer.Balance, transfer.Voucher)
+			return nil
+		},
+	}
+	ibcCheckCmd.Flags().StringVar(&ibcCheck.ChainID, "chain-id", defaultIbcCheckChainID, "chain id of the ibc chain to send to")
+	ibcCheckCmd.Flags().StringVar(&ibcCheck.Channel, "channel", "", "mage channel to send on, defaults to the open transfer channel to --chain-id")
+	ibcCheckCmd.Flags().StringVar(&ibcCheck.Amount, "amount", "1000umage", "coin to send")
+	ibcCheckCmd.Flags().StringVar(&ibcCheck.Receiver, "receiver", "", "address to send to on the ibc chain, defaults to the validator's address")
+	ibcCheckCmd.Flags().DurationVar(&ibcCheck.Timeout, "timeout", defaultWaitTimeout, "how long to wait for the transfer to be delivered before failing")
+	rootCmd.AddCommand(ibcCheckCmd)
 
-			makeNewIbcImageCmd := exec.Command("docker", "commit", strings.TrimSpace(string(ibcContainer)), "ibc-export-temp")
-			ibcImageOutput, err := makeNewIbcImageCmd.Output()
+	var statusOutput string
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the state of the generated testnet's services.",
+		Long: `List each service in the generated docker-compose.yaml with its container state and published ports.
+Running chain nodes also show their chain id, latest block height, and whether they're catching up, from their rpc endpoint.`,
+		Example: `status
+status --output json`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			statuses, err := testnetStatus(context.Background(), generatedConfigDir)
 			if err != nil {
 				return err
 			}
+			return printStatus(os.Stdout, statuses, statusOutput)
+		},
+	}
+	statusCmd.Flags().StringVarP(&statusOutput, "output", "o", "table", "output format, one of table, json, or yaml")
+	rootCmd.AddCommand(statusCmd)
 
-			localMageMountPath := filepath.Join(generatedConfigDir, "mage", "initstate", ".mage", "config")
-			localIbcMountPath := filepath.Join(generatedConfigDir, "ibcchain", "initstate", ".mage", "config")
+	var logOptions compose.LogOptions
+	var logGrep string
+	var noColor bool
+	logsCmd := &cobra.Command{
+		Use:   "logs [compose_services...]",
+		Short: "Show the logs of the generated testnet's services.",
+		Long: `Print the logs of the testnet's containers merged together, each line prefixed with its service, like 'docker-compose logs'.
+Pass docker-compose service names (eg magenode bnb_deputy) to only show those.
 
-			mageExportCmd := exec.Command(
-				"docker", "run",
-				"-v", strings.TrimSpace(fmt.Sprintf("%s:/root/.mage/config", localMageMountPath)),
-				"mage-export-temp",
-				"mage", "export")
-			mageExportJSON, err := mageExportCmd.Output()
+When printing to a terminal, panics, tendermint consensus errors, and failed deputy swaps are highlighted.`,
+		Example: `logs
+logs magenode --since 10m --grep "module=consensus"
+logs bnb_deputy btcb_deputy --follow`,
+		RunE: func(_ *cobra.Command, args []string) error {
+			opts := logsOptions{LogOptions: logOptions, Color: !noColor && isTerminal(os.Stdout)}
+			if logGrep != "" {
+				var err error
+				if opts.Grep, err = regexp.Compile(logGrep); err != nil {
+					return fmt.Errorf("invalid --grep pattern: %w", err)
+				}
+			}
+			project, backend, err := loadProjectAndBackend(generatedConfigDir)
 			if err != nil {
 				return err
 			}
+			ctx, cancel := interruptContext()
+			defer cancel()
+			return printLogs(ctx, os.Stdout, backend, project, args, opts)
+		},
+	}
+	logsCmd.Flags().BoolVarP(&logOptions.Follow, "follow", "f", false, "keep printing new logs until interrupted")
+	logsCmd.Flags().StringVar(&logOptions.Since, "since", "", "only show logs after a time, as a unix timestamp or a duration relative to now, eg 10m")
+	logsCmd.Flags().StringVar(&logOptions.Tail, "tail", "", "number of lines to show from the end of each service's logs, defaults to all")
+	logsCmd.Flags().BoolVarP(&logOptions.Timestamps, "timestamps", "t", false, "show the time of each line")
+	logsCmd.Flags().StringVar(&logGrep, "grep", "", "only show lines matching a regular expression")
+	logsCmd.Flags().BoolVar(&noColor, "no-color", false, "don't colour the output")
+	rootCmd.AddCommand(logsCmd)
+
+	var exportServices []string
+	var exportOutputDir string
 
-			ibcExportCmd := exec.Command(
-				"docker", "run",
-				"-v", strings.TrimSpace(fmt.Sprintf("%s:/root/.mage/config", localIbcMountPath)),
-				"ibc-export-temp",
-				"mage", "export")
-			ibcExportJSON, err := ibcExportCmd.Output()
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Pauses the current testnet, exports the state of its chains to JSON files, then restarts the testnet.",
+		Long: `Stop the testnet, export the state of each chain node to a JSON file, then start the testnet again.
+The testnet is restarted even if an export fails.
+
+Chain nodes are found from the generated docker-compose.yaml. By default every running node is exported, pass --services to choose which.
+Files are named <service>-<chain-id>-<height>.json.`,
+		Example: "export --services magenode --output-dir ./exports",
+		Args:    cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) (err error) {
+			ctx := context.Background()
+			project, backend, err := loadProjectAndBackend(generatedConfigDir)
+			if err != nil {
+				return err
+			}
+			containers, err := backend.Containers(ctx, project)
 			if err != nil {
 				return err
 			}
-			ts := time.Now().Unix()
-			mageFilename := fmt.Sprintf("mage-export-%d.json", ts)
-			ibcFilename := fmt.Sprintf("ibc-export-%d.json", ts)
+			nodes, err := selectChainNodes(chainNodes(project), containers, exportServices)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(exportOutputDir, os.ModePerm); err != nil {
+				return err
+			}
+			// record chain ids and heights while the nodes are still serving rpc requests
+			statuses := nodeStatuses(generatedConfigDir)
 
-			fmt.Printf("Created exports %s and %s\nCleaning up...", mageFilename, ibcFilename)
+			defer func() {
+				fmt.Println("Restarting testnet...")
+				if startErr := backend.Start(ctx, project); startErr != nil {
+					if err == nil {
+						err = startErr
+					} else {
+						fmt.Println(startErr.Error())
+					}
+				}
+			}()
+			if err := backend.Stop(ctx, project); err != nil {
+				return err
+			}
 
-			err = ioutil.WriteFile(mageFilename, mageExportJSON, 0644)
+			for _, node := range nodes {
+				container, err := findContainer(containers, node.Service)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Exporting %s...\n", node.Service)
+				filename, err := exportChainNode(ctx, backend, project, container, node, statuses[node.Service], exportOutputDir)
+				if err != nil {
+					return fmt.Errorf("could not export %s: %w", node.Service, err)
+				}
+				fmt.Printf("Created export %s\n", filename)
+			}
+			return nil
+		},
+	}
+	exportCmd.Flags().StringSliceVar(&exportServices, "services", nil, "docker-compose services to export (eg magenode,ibcnode), defaults to all running chain nodes")
+	exportCmd.Flags().StringVar(&exportOutputDir, "output-dir", ".", "directory to write the exported files to")
+	rootCmd.AddCommand(exportCmd)
+
+	templatesCmd := &cobra.Command{
+		Use:   "templates",
+		Short: "List and check the config templates testnets are generated from.",
+	}
+	rootCmd.AddCommand(templatesCmd)
+
+	var templatesOutput string
+	templatesListCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the template versions of each service.",
+		Long: fmt.Sprintf(`List the templates with the image, chain id, staking denom, and initstate home folder of each.
+The templates built into kvtool are listed unless %s is set to another templates folder.
+The version column is the value to pass to --mage.configTemplate, or to set as the version in a topology file.`, generate.TemplatesDirEnv),
+		Example: `templates list
+templates list --output json`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			templates, err := generate.ListTemplates()
 			if err != nil {
 				return err
 			}
-			err = ioutil.WriteFile(ibcFilename, ibcExportJSON, 0644)
+			return printTemplates(os.Stdout, templates, templatesOutput)
+		},
+	}
+	templatesListCmd.Flags().StringVarP(&templatesOutput, "output", "o", "table", "output format, one of table, json, or yaml")
+	templatesCmd.AddCommand(templatesListCmd)
+
+	templatesVerifyCmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Check each template's genesis is valid and its docker-compose.yaml only mounts files in the template.",
+		Long: `Check every template for problems that would only show up once a testnet is started:
+the genesis in its initstate folder must be valid json, and each volume in its docker-compose.yaml must refer to a file or folder in the template.
+
+Mage genesis files are also validated with the mage app kvtool is built with, which uses the cosmos-sdk v0.39 format.
+Genesis files it can't decode, such as the sdk v0.40+ format of the master and v0.16 templates, are only checked to be valid json
+and are listed as warnings, which don't fail the check.`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			problems, err := generate.VerifyTemplates(verifyTemplateGenesis)
 			if err != nil {
 				return err
 			}
+			failed := 0
+			for _, p := range problems {
+				if p.Warning {
+					fmt.Printf("WARNING: %s\n", p)
+					continue
+				}
+				fmt.Println(p)
+				failed++
+			}
+			if failed > 0 {
+				return fmt.Errorf("found %d problems in the templates", failed)
+			}
+			fmt.Println("templates ok")
+			return nil
+		},
+	}
+	templatesCmd.AddCommand(templatesVerifyCmd)
 
-			// docker ps -aqf "name=containername"
-			tempMageContainerIDCmd := exec.Command("docker", "ps", "-aqf", "ancestor=mage-export-temp")
-			tempMageContainer, err := tempMageContainerIDCmd.Output()
+	templatesExtractCmd := &cobra.Command{
+		Use:   "extract <dir>",
+		Short: "Write the templates built into kvtool to a directory so they can be modified.",
+		Long: fmt.Sprintf(`Write the templates built into kvtool, and the common files they use, into templates and common folders in a directory.
+Set %s to the extracted templates folder to generate testnets from them.`, generate.TemplatesDirEnv),
+		Example: "templates extract ./my-templates",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			dir, err := filepath.Abs(args[0])
 			if err != nil {
 				return err
 			}
-			tempIbcContainerIDCmd := exec.Command("docker", "ps", "-aqf", "ancestor=ibc-export-temp")
-			tempIbcContainer, err := tempIbcContainerIDCmd.Output()
-			if err != nil {
+			if _, err := os.Stat(filepath.Join(dir, "templates")); err == nil {
+				return fmt.Errorf("%s already contains a templates folder", dir)
+			}
+			if err := config.Extract(dir); err != nil {
 				return err
 			}
+			fmt.Printf("extracted templates, use them with:\nexport %s=%s\n", generate.TemplatesDirEnv, filepath.Join(dir, "templates"))
+			return nil
+		},
+	}
+	templatesCmd.AddCommand(templatesExtractCmd)
 
-			deleteMageContainerCmd := exec.Command("docker", "rm", strings.TrimSpace(string(tempMageContainer)))
-			err = deleteMageContainerCmd.Run()
+	var snapshotsDir string
+	// snapshots are stored next to the generated config by default, so they aren't cleared when it's regenerated
+	snapshotsPath := func() string {
+		if snapshotsDir != "" {
+			return snapshotsDir
+		}
+		return filepath.Join(filepath.Dir(filepath.Clean(generatedConfigDir)), "snapshots")
+	}
+
+	snapshotCmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Save the state of the testnet and restore it later.",
+		Long: `Snapshots hold the generated config and the data directories of the testnet's chain nodes (eg mage, ibc, and binance).
+They can be used to return to a known state, for example to rerun tests against a testnet with contracts deployed and swaps completed.`,
+	}
+	snapshotCmd.PersistentFlags().StringVar(&snapshotsDir, "snapshot-dir", "", "directory snapshots are stored in, defaults to 'snapshots' next to the generated config")
+	rootCmd.AddCommand(snapshotCmd)
+
+	var forceSnapshot bool
+	snapshotSaveCmd := &cobra.Command{
+		Use:   "save <name>",
+		Short: "Pauses the current testnet, saves its chain data and config, then restarts the testnet.",
+		Long: `Stop the testnet, archive each chain node's data directory and copy the generated config into a named snapshot, then start the testnet again.
+The testnet is restarted even if saving fails.`,
+		Example: "snapshot save contracts-deployed",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			dir, err := snapshotPath(snapshotsPath(), args[0])
 			if err != nil {
 				return err
 			}
-			deleteIbcContainerCmd := exec.Command("docker", "rm", strings.TrimSpace(string(tempIbcContainer)))
-			err = deleteIbcContainerCmd.Run()
+			backend, err := newBackend()
 			if err != nil {
 				return err
 			}
+			return saveSnapshot(context.Background(), backend, generatedConfigDir, dir, args[0], forceSnapshot)
+		},
+	}
+	snapshotSaveCmd.Flags().BoolVar(&forceSnapshot, "force", false, "overwrite an existing snapshot with the same name")
+	snapshotCmd.AddCommand(snapshotSaveCmd)
 
-			deleteMageImageCmd := exec.Command("docker", "rmi", strings.TrimSpace(string(mageImageOutput)))
-			err = deleteMageImageCmd.Run()
+	snapshotRestoreCmd := &cobra.Command{
+		Use:   "restore <name>",
+		Short: "Replace the current testnet with a saved snapshot and start it.",
+		Long: `Take down the current testnet, replace the generated config with the snapshot's, and start the testnet from the saved chain data.
+The command waits until the testnet's endpoints are ready before exiting.`,
+		Example: "snapshot restore contracts-deployed",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			dir, err := snapshotPath(snapshotsPath(), args[0])
 			if err != nil {
 				return err
 			}
-			deleteIbcImageCmd := exec.Command("docker", "rmi", strings.TrimSpace(string(ibcImageOutput)))
-			err = deleteIbcImageCmd.Run()
+			backend, err := newBackend()
 			if err != nil {
 				return err
 			}
+			return restoreSnapshot(context.Background(), backend, generatedConfigDir, dir, waitTimeout)
+		},
+	}
+	snapshotRestoreCmd.Flags().DurationVar(&waitTimeout, "wait-timeout", defaultWaitTimeout, "how long to wait for the restored testnet to start before failing")
+	snapshotCmd.AddCommand(snapshotRestoreCmd)
 
-			fmt.Printf("Restarting testnet...")
-			restartCmd := exec.Command("docker-compose", "--file", filepath.Join(generatedConfigDir, "docker-compose.yaml"), "start")
-			restartCmd.Stdout = os.Stdout
-			restartCmd.Stderr = os.Stderr
-
-			err = restartCmd.Run()
+	snapshotListCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the saved snapshots.",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			snapshots, err := listSnapshots(snapshotsPath())
 			if err != nil {
 				return err
 			}
+			for _, s := range snapshots {
+				fmt.Printf("%s\t%s\t%s\n", s.Name, s.Created.Local().Format(time.RFC3339), s.Project)
+			}
 			return nil
 		},
 	}
-	rootCmd.AddCommand(exportCmd)
+	snapshotCmd.AddCommand(snapshotListCmd)
 
 	return rootCmd
 }
 
+// addGenesisImportFlags adds the options for starting a testnet from an exported genesis to a command.
+func addGenesisImportFlags(cmd *cobra.Command, config *generate.GenesisImport) {
+	cmd.Flags().StringVar(&config.ChainID, "chain-id", "", "chain id for the imported genesis, defaults to the template's chain id")
+	cmd.Flags().Float64Var(&config.MinPowerPercent, "min-power", 0.7, "minimum share of voting power given to the template's validator in the imported genesis, must be over 2/3 to produce blocks")
+}
+
+// addProjectFlags adds the options for running a testnet alongside others to a command.
+func addProjectFlags(cmd *cobra.Command, projectName *string, portOffset *int) {
+	cmd.Flags().StringVar(projectName, "project-name", "", "name of the docker-compose project, used to prefix container and network names. Defaults to the generated config folder's name")
+	cmd.Flags().IntVar(portOffset, "port-offset", 0, "amount to shift every published host port by, eg 1000 publishes the mage rpc on 27657")
+}
+
+// addBuildFromFlag adds the flag for running mage from a local build.
+func addBuildFromFlag(cmd *cobra.Command, buildFrom *string) {
+	cmd.Flags().StringVar(buildFrom, "build-from", "", "build the mage image from a source checkout directory or a git ref (branch, tag, or commit) of the mage repo, instead of using the template's published image")
+}
+
+// addImageFlag adds the flag for overriding the images of services.
+func addImageFlag(cmd *cobra.Command, imageFlags *[]string) {
+	cmd.Flags().StringArrayVar(imageFlags, "image", nil, "run a service with a different image, in the form <service>=<repo>:<tag>, eg deputy=mage/deputy:v0.5.0. Can be repeated")
+}
+
+// validateMageGenesis checks the app state of a genesis file can be decoded by the mage app's modules.
+// The app kvtool is built with uses the amino json of cosmos-sdk v0.39, so genesis files in the protobuf json of sdk v0.40+,
+// or with modules the app doesn't have, can't be checked. They return generate.ErrGenesisNotValidated.
+func validateMageGenesis(genesisJSON []byte) error {
+	var genesis struct {
+		AppState map[string]json.RawMessage `json:"app_state"`
+	}
+	if err := json.Unmarshal(genesisJSON, &genesis); err != nil {
+		return err
+	}
+	var bank struct {
+		Balances json.RawMessage `json:"balances"`
+	}
+	if err := json.Unmarshal(genesis.AppState["bank"], &bank); err == nil && bank.Balances != nil {
+		return fmt.Errorf("%w, it's in the cosmos-sdk v0.40+ format which the mage app kvtool is built with can't decode", generate.ErrGenesisNotValidated)
+	}
+	var unknownModules []string
+	for name := range genesis.AppState {
+		if _, found := app.ModuleBasics[name]; !found {
+			unknownModules = append(unknownModules, name)
+		}
+	}
+	if len(unknownModules) > 0 {
+		sort.Strings(unknownModules)
+		return fmt.Errorf("%w, the mage app kvtool is built with doesn't have the %s modules", generate.ErrGenesisNotValidated, strings.Join(unknownModules, ", "))
+	}
+	return app.ModuleBasics.ValidateGenesis(genesis.AppState)
+}
+
 // Minimum1ValidArgs checks if the input command has valid args
 func Minimum1ValidArgs(cmd *cobra.Command, args []string) error {
 	if len(args) < 1 {
@@ -406,23 +1063,90 @@ func Minimum1ValidArgs(cmd *cobra.Command, args []string) error {
 	return cobra.OnlyValidArgs(cmd, args)
 }
 
-func replaceCurrentProcess(command ...string) error {
-	if len(command) < 1 {
-		panic("must provide name of executable to run")
+// findContainer returns the container running a compose service.
+func findContainer(containers []compose.ContainerState, service string) (compose.ContainerState, error) {
+	for _, c := range containers {
+		if c.Service == service {
+			return c, nil
+		}
 	}
-	executable, err := exec.LookPath(command[0])
+	return compose.ContainerState{}, fmt.Errorf("no container found for service %s, is the testnet running?", service)
+}
+
+// serviceConfigs lists the config for each named service, using the mage template version for the mage service
+// if it's named or needed by another service.
+func serviceConfigs(names []string, mageConfigTemplate string) ([]generate.ServiceConfig, error) {
+	resolved, err := generate.ResolveServices(names)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	var configs []generate.ServiceConfig
+	for _, s := range resolved {
+		if s.Name() == generate.MageServiceName {
+			configs = append(configs, generate.ServiceConfig{Name: s.Name(), Version: mageConfigTemplate})
+		}
+	}
+	for _, name := range names {
+		if name != generate.MageServiceName {
+			configs = append(configs, generate.ServiceConfig{Name: name})
+		}
 	}
+	return configs, nil
+}
 
-	// pass the current environment variables to the command
-	env := os.Environ()
+// parseImageFlags parses image overrides in the form <service>=<image> into a map of service names to images.
+func parseImageFlags(values []string, buildFrom string) (map[string]string, error) {
+	images := map[string]string{}
+	for _, v := range values {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid --image value '%s', expected <service>=<repo>:<tag>", v)
+		}
+		if _, found := generate.GetService(parts[0]); !found {
+			return nil, fmt.Errorf("invalid --image value '%s', unknown service '%s'", v, parts[0])
+		}
+		if _, found := images[parts[0]]; found {
+			return nil, fmt.Errorf("--image set more than once for %s", parts[0])
+		}
+		images[parts[0]] = parts[1]
+	}
+	if _, found := images[generate.MageServiceName]; found && buildFrom != "" {
+		return nil, fmt.Errorf("--image can't be used for %s with --build-from", generate.MageServiceName)
+	}
+	return images, nil
+}
 
-	err = syscall.Exec(executable, command, env)
+// applyImageOverrides sets the images of service configs. Overrides for dependencies that aren't listed are added as new configs.
+// It errors if an override is for a service that isn't part of the testnet.
+func applyImageOverrides(configs []generate.ServiceConfig, images map[string]string) ([]generate.ServiceConfig, error) {
+	var names []string
+	for _, c := range configs {
+		names = append(names, c.Name)
+	}
+	resolved, err := generate.ResolveServices(names)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	included := map[string]bool{}
+	for _, s := range resolved {
+		included[s.Name()] = true
+	}
+	for service, image := range images {
+		if !included[service] {
+			return nil, fmt.Errorf("--image set for %s, but it's not part of the testnet", service)
+		}
+		found := false
+		for i := range configs {
+			if configs[i].Name == service {
+				configs[i].Image = image
+				found = true
+			}
+		}
+		if !found {
+			configs = append(configs, generate.ServiceConfig{Name: service, Image: image})
+		}
 	}
-	return nil
+	return configs, nil
 }
 
 type stringSlice []string
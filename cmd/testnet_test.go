@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Jeffail/gabs/v2"
+
+	"github.com/furya-official/mgtool/config/generate"
+)
+
+func TestBootstrap(t *testing.T) {
+	backend := useTestBackend(t)
+	dir := filepath.Join(t.TempDir(), "generated")
+	args := []string{"bootstrap", "--generated-dir", dir, "--port-offset", strconv.Itoa(testPortOffset)}
+
+	if err := runTestnetCmd(args...); err != nil {
+		t.Fatal(err)
+	}
+	equalCalls(t, []string{
+		"pull",
+		"up magenode",
+	}, backend.Calls)
+	if _, err := os.Stat(filepath.Join(dir, "mage", "initstate")); err != nil {
+		t.Fatalf("expected the mage template to be generated: %v", err)
+	}
+
+	// bootstrapping again replaces the running testnet
+	backend.Calls = nil
+	if err := runTestnetCmd(args...); err != nil {
+		t.Fatal(err)
+	}
+	equalCalls(t, []string{
+		"down",
+		"pull",
+		"up magenode",
+	}, backend.Calls)
+}
+
+// validatorMnemonic is the mage validator's mnemonic in config/common/addresses.yaml, the relayers sign with its account.
+const validatorMnemonic = "very health column only surface project output absent outdoor siren reject era legend legal twelve setup roast lion rare tunnel devote style random food"
+
+func TestBootstrapIbcLink(t *testing.T) {
+	testCases := []struct {
+		relayer  string
+		expected []string
+	}{
+		{
+			relayer: goRelayer,
+			expected: []string{
+				"pull",
+				"up ibcnode magenode",
+				"run mage/relayer:v1.0.0 tx link magenode-ibcnode-transfer -d -o 3s",
+				"up ibcnode magenode relayer-magenode-ibcnode-transfer",
+			},
+		},
+		{
+			relayer: hermesRelayer,
+			expected: []string{
+				"pull",
+				"up ibcnode magenode",
+				"run mage/hermes:latest keys restore magelocalnet_8888-1 -n testkey -m " + validatorMnemonic + " --hd-path m/44'/459'/0'/0/0",
+				"run mage/hermes:latest keys restore mage-localnet-2 -n testkey -m " + validatorMnemonic + " --hd-path m/44'/459'/0'/0/0",
+				"run mage/hermes:latest create channel magelocalnet_8888-1 mage-localnet-2 --port-a transfer --port-b transfer -o unordered -v ics20-1",
+				"up hermes-relayer ibcnode magenode",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.relayer, func(t *testing.T) {
+			backend := useTestBackend(t)
+			dir := filepath.Join(t.TempDir(), "generated")
+
+			err := runTestnetCmd("bootstrap", "--generated-dir", dir, "--port-offset", strconv.Itoa(testPortOffset), "--ibc", "--relayer", tc.relayer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			equalCalls(t, tc.expected, backend.Calls)
+		})
+	}
+}
+
+func TestValidateMageGenesisNewerFormat(t *testing.T) {
+	// the default template uses the sdk v0.40+ genesis format, which the linked mage app can't decode
+	bz, err := ioutil.ReadFile(filepath.Join(generate.ConfigTemplatesDir, "mage", "master", "initstate", ".kava", "config", "genesis.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateMageGenesis(bz); !errors.Is(err, generate.ErrGenesisNotValidated) {
+		t.Fatalf("expected the genesis not to be validated, got %v", err)
+	}
+}
+
+func TestGenConfigGenesisPatch(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "generated")
+	patchFile := filepath.Join(t.TempDir(), "patch.yaml")
+	patch := "- set: app_state.gov.voting_params.voting_period\n  value: 60s\n"
+	if err := ioutil.WriteFile(patchFile, []byte(patch), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runTestnetCmd("gen-config", "mage", "--generated-dir", dir, "--genesis-patch", patchFile); err != nil {
+		t.Fatal(err)
+	}
+	genesis, err := gabs.ParseJSONFile(filepath.Join(dir, "mage", "initstate", ".kava", "config", "genesis.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if period := genesis.Path("app_state.gov.voting_params.voting_period").Data(); period != "60s" {
+		t.Fatalf("expected the voting period to be patched, got %v", period)
+	}
+}
+
+func TestGenConfigProjectName(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "generated")
+
+	if err := runTestnetCmd("gen-config", "mage", "--generated-dir", dir, "--project-name", "second"); err != nil {
+		t.Fatal(err)
+	}
+	compose, err := ioutil.ReadFile(filepath.Join(dir, "docker-compose.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// docker-compose v1 only accepts the name in an extension field, which needs version 3.4
+	if !strings.Contains(string(compose), "x-kvtool-project-name: second") || strings.Contains(string(compose), "\nname:") {
+		t.Fatalf("expected the name in an extension field, got:\n%s", compose)
+	}
+	if !strings.Contains(string(compose), "version: \"3.4\"") && !strings.Contains(string(compose), "version: '3.4'") {
+		t.Fatalf("expected compose file version 3.4, got:\n%s", compose)
+	}
+	project, err := loadProject(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.Name != "second" {
+		t.Fatalf("expected project second, got %s", project.Name)
+	}
+}
+
+func TestGenConfigImageOverride(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "generated")
+
+	// the relayer only runs in hooks, so its image is recorded in an extension field
+	if err := runTestnetCmd("gen-config", "mage", "relayer", "--generated-dir", dir, "--image", "relayer=mage/relayer:v2"); err != nil {
+		t.Fatal(err)
+	}
+	compose, err := ioutil.ReadFile(filepath.Join(dir, "docker-compose.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// docker-compose v1 only accepts extension fields from version 3.4
+	if !strings.Contains(string(compose), "version: \"3.4\"") && !strings.Contains(string(compose), "version: '3.4'") {
+		t.Fatalf("expected compose file version 3.4, got:\n%s", compose)
+	}
+	image, err := generate.ImageOverride(dir, generate.RelayerServiceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image != "mage/relayer:v2" {
+		t.Fatalf("expected the relayer image mage/relayer:v2, got %s", image)
+	}
+}
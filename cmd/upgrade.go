@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jeffail/gabs/v2"
+	"gopkg.in/yaml.v3"
+
+	"github.com/furya-official/mgtool/compose"
+	"github.com/furya-official/mgtool/config/generate"
+	"github.com/furya-official/mgtool/health"
+)
+
+// upgradeKeyringHome is where the validator's key is restored in the containers that send the upgrade transactions.
+const upgradeKeyringHome = "/tmp/kvtool"
+
+// upgradeChain is the running mage chain an upgrade is rehearsed on, or transactions are sent to.
+type upgradeChain struct {
+	project compose.Project
+	backend compose.Backend
+	node    chainNode
+	rpcURL  string
+	chainID string
+	deposit string
+}
+
+// loadUpgradeChain finds the mage node in a running testnet and reads the settings needed to send it transactions.
+func loadUpgradeChain(generatedConfigDir string) (upgradeChain, error) {
+	project, backend, err := loadProjectAndBackend(generatedConfigDir)
+	if err != nil {
+		return upgradeChain{}, err
+	}
+	chain := upgradeChain{project: project, backend: backend}
+	for _, n := range chainNodes(project) {
+		if n.Service == generate.MageNodeService {
+			chain.node = n
+		}
+	}
+	if chain.node.Binary == "" {
+		return upgradeChain{}, fmt.Errorf("could not find how to run the %s service", generate.MageNodeService)
+	}
+	endpoints, err := generate.Endpoints(generatedConfigDir)
+	if err != nil {
+		return upgradeChain{}, err
+	}
+	for _, e := range endpoints {
+		if e.ComposeService == generate.MageNodeService && e.Kind == generate.RPCEndpoint {
+			chain.rpcURL = e.URL()
+		}
+	}
+	if chain.rpcURL == "" {
+		return upgradeChain{}, fmt.Errorf("the %s service doesn't publish its rpc port", generate.MageNodeService)
+	}
+
+	genesisFile, err := generate.MageGenesisFile(generatedConfigDir)
+	if err != nil {
+		return upgradeChain{}, err
+	}
+	bz, err := ioutil.ReadFile(genesisFile)
+	if err != nil {
+		return upgradeChain{}, err
+	}
+	genesis, err := gabs.ParseJSON(bz)
+	if err != nil {
+		return upgradeChain{}, err
+	}
+	if !genesis.Exists("app_state", "bank", "balances") {
+		return upgradeChain{}, fmt.Errorf("sending transactions is only supported from templates using cosmos-sdk v0.40 or later")
+	}
+	chain.chainID, _ = genesis.Path("chain_id").Data().(string)
+	var deposit []string
+	for _, c := range genesis.Path("app_state.gov.deposit_params.min_deposit").Children() {
+		deposit = append(deposit, fmt.Sprintf("%v%v", c.Path("amount").Data(), c.Path("denom").Data()))
+	}
+	chain.deposit = strings.Join(deposit, ",")
+	return chain, nil
+}
+
+// proposeUpgrade submits a software upgrade proposal, and votes for it with the validator so it passes.
+// The upgrade name must match an upgrade handler in the new version.
+func proposeUpgrade(ctx context.Context, chain upgradeChain, mnemonic, name string, height int64) error {
+	status, err := health.Status(ctx, chain.rpcURL)
+	if err != nil {
+		return err
+	}
+	if height <= status.LatestHeight {
+		return fmt.Errorf("upgrade height %d has already passed, the chain is at height %d", height, status.LatestHeight)
+	}
+
+	fmt.Printf("proposing upgrade %s at height %d\n", name, height)
+	_, err = runMageTx(ctx, chain, mnemonic, "gov", "submit-proposal", "software-upgrade", name,
+		"--upgrade-height", strconv.FormatInt(height, 10),
+		"--title", "Upgrade to "+name,
+		"--description", "Upgrade rehearsal",
+		"--deposit", chain.deposit,
+	)
+	if err != nil {
+		return fmt.Errorf("could not submit upgrade proposal: %w", err)
+	}
+
+	output, err := chain.backend.Run(ctx, compose.RunOptions{
+		Image:   chain.project.Config.Services[chain.node.Service].Image,
+		Cmd:     []string{chain.node.Binary, "query", "gov", "proposals", "--node", chain.nodeAddress(), "--output", "json"},
+		Network: chain.project.NetworkName(),
+	})
+	if err != nil {
+		return fmt.Errorf("could not query proposals: %w", err)
+	}
+	var proposals struct {
+		Proposals []struct {
+			ProposalID string `json:"proposal_id"`
+		} `json:"proposals"`
+	}
+	if err := json.Unmarshal(output, &proposals); err != nil {
+		return fmt.Errorf("could not parse proposals: %w", err)
+	}
+	if len(proposals.Proposals) == 0 {
+		return fmt.Errorf("submitted upgrade proposal not found")
+	}
+	// proposals are listed in order, so the latest is the one just submitted
+	proposalID := proposals.Proposals[len(proposals.Proposals)-1].ProposalID
+
+	fmt.Printf("voting for proposal %s\n", proposalID)
+	if _, err := runMageTx(ctx, chain, mnemonic, "gov", "vote", proposalID, "yes"); err != nil {
+		return fmt.Errorf("could not vote for the upgrade proposal: %w", err)
+	}
+	return nil
+}
+
+// nodeAddress is the mage node's rpc address on the project's network.
+func (c upgradeChain) nodeAddress() string {
+	return fmt.Sprintf("tcp://%s:26657", c.node.Service)
+}
+
+// runMageTx sends a transaction signed by the account of a mnemonic, using the mage node's image.
+// It errors if the transaction fails to be included in a block.
+func runMageTx(ctx context.Context, chain upgradeChain, mnemonic string, args ...string) ([]byte, error) {
+	binary := chain.node.Binary
+	txArgs := append([]string{binary, "tx"}, args...)
+	txArgs = append(txArgs,
+		"--from", "validator",
+		"--keyring-backend", "test",
+		"--home", upgradeKeyringHome,
+		"--chain-id", chain.chainID,
+		"--node", chain.nodeAddress(),
+		"--gas", "500000",
+		"--broadcast-mode", "block",
+		"--output", "json",
+		"--yes",
+	)
+	var quoted []string
+	for _, a := range txArgs {
+		quoted = append(quoted, shellQuote(a))
+	}
+	script := strings.Join([]string{
+		"set -e",
+		fmt.Sprintf("echo %s | %s keys add validator --recover --keyring-backend test --home %s > /dev/null 2>&1", shellQuote(mnemonic), binary, upgradeKeyringHome),
+		strings.Join(quoted, " "),
+	}, "\n")
+	output, err := chain.backend.Run(ctx, compose.RunOptions{
+		Image:   chain.project.Config.Services[chain.node.Service].Image,
+		Cmd:     []string{"sh", "-c", script},
+		Network: chain.project.NetworkName(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Code   int    `json:"code"`
+		RawLog string `json:"raw_log"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("could not parse transaction result: %w", err)
+	}
+	if result.Code != 0 {
+		return nil, fmt.Errorf("transaction failed with code %d: %s", result.Code, result.RawLog)
+	}
+	return output, nil
+}
+
+// shellQuote quotes a string so it's passed to a command as a single argument by sh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// waitForUpgradeHalt waits for a chain to stop at the block before an upgrade height, as it does when an upgrade is due.
+// It errors if the chain continues past the upgrade height, which happens if the upgrade proposal didn't pass,
+// or if it stops producing blocks for longer than stallTimeout before reaching it.
+func waitForUpgradeHalt(ctx context.Context, rpcURL string, upgradeHeight int64, stallTimeout time.Duration) error {
+	// the chain has halted once its height hasn't changed for a few block times
+	const haltedAfter = 10 * time.Second
+	lastChange := time.Now()
+	var lastHeight int64
+	for {
+		status, err := health.Status(ctx, rpcURL)
+		switch {
+		case err != nil && lastHeight == upgradeHeight-1:
+			// nodes can stop responding once halted
+			return nil
+		case err != nil:
+			// the node may be busy, keep waiting
+		case status.LatestHeight >= upgradeHeight:
+			return fmt.Errorf("chain did not halt at upgrade height %d, check the upgrade proposal passed", upgradeHeight)
+		case status.LatestHeight != lastHeight:
+			lastHeight = status.LatestHeight
+			lastChange = time.Now()
+		case lastHeight == upgradeHeight-1 && time.Since(lastChange) > haltedAfter:
+			return nil
+		}
+		if lastHeight < upgradeHeight-1 && time.Since(lastChange) > stallTimeout {
+			return fmt.Errorf("chain stopped at height %d before reaching upgrade height %d", lastHeight, upgradeHeight)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(defaultWaitInterval):
+		}
+	}
+}
+
+// validatorAccount is the account of the mage validator used by the templates.
+type validatorAccount struct {
+	Mnemonic string `yaml:"mnemonic"`
+	Address  string `yaml:"address"`
+}
+
+// mageValidator reads the account of the mage validator used by the templates from the common addresses file.
+func mageValidator() (validatorAccount, error) {
+	filename := filepath.Join(generate.ConfigTemplatesDir, "..", "common", "addresses.yaml")
+	bz, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return validatorAccount{}, err
+	}
+	var addresses struct {
+		Mage struct {
+			Validators []validatorAccount `yaml:"validators"`
+		} `yaml:"mage"`
+	}
+	if err := yaml.Unmarshal(bz, &addresses); err != nil {
+		return validatorAccount{}, fmt.Errorf("could not parse %s: %w", filename, err)
+	}
+	if len(addresses.Mage.Validators) == 0 || addresses.Mage.Validators[0].Mnemonic == "" {
+		return validatorAccount{}, fmt.Errorf("no mage validator mnemonic in %s", filename)
+	}
+	return addresses.Mage.Validators[0], nil
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/Jeffail/gabs/v2"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+
+	"github.com/furya-official/mgtool/compose"
+	"github.com/furya-official/mgtool/config/generate"
+)
+
+// defaultSelfDelegation is the stake of added validators if the template's validator's stake can't be found.
+const defaultSelfDelegation = 1000000000
+
+// generateValidators turns the generated config's mage node into a network of count validators.
+// The mage image is used to create keys and gentxs for the new validators and collect them into a genesis shared by every node.
+func generateValidators(generatedConfigDir string, count int) error {
+	nodes, err := generate.AddValidatorNodes(generatedConfigDir, count)
+	if err != nil {
+		return err
+	}
+	project, backend, err := loadProjectAndBackend(generatedConfigDir)
+	if err != nil {
+		return err
+	}
+	var mageNode chainNode
+	for _, n := range chainNodes(project) {
+		if n.Service == generate.MageNodeService {
+			mageNode = n
+		}
+	}
+	if mageNode.Binary == "" {
+		return fmt.Errorf("could not find how to run the %s service", generate.MageNodeService)
+	}
+
+	genesisJSON, err := ioutil.ReadFile(filepath.Join(nodes[0].ConfigDir(), "genesis.json"))
+	if err != nil {
+		return err
+	}
+	genesis, err := gabs.ParseJSON(genesisJSON)
+	if err != nil {
+		return err
+	}
+	if !genesis.Exists("app_state", "bank", "balances") {
+		return fmt.Errorf("multiple validators are only supported for templates using cosmos-sdk v0.40 or later")
+	}
+	chainID, _ := genesis.Path("chain_id").Data().(string)
+	bondDenom, _ := genesis.Path("app_state.staking.params.bond_denom").Data().(string)
+	selfDelegation := sdk.NewInt(defaultSelfDelegation)
+	for _, tx := range genesis.Path("app_state.genutil.gen_txs").Children() {
+		// give the new validators the same power as the template's
+		if amount, ok := tx.Path("body.messages.0.value.amount").Data().(string); ok {
+			stake, ok := sdk.NewIntFromString(amount)
+			if !ok || !stake.IsPositive() {
+				return fmt.Errorf("invalid self delegation '%s' in the template's gentx", amount)
+			}
+			selfDelegation = stake
+			break
+		}
+	}
+
+	var binds []string
+	for i, node := range nodes {
+		home, err := filepath.Abs(node.Home)
+		if err != nil {
+			return err
+		}
+		binds = append(binds, fmt.Sprintf("%s:/nodes/node%d", home, i))
+	}
+	fmt.Printf("creating %d validators for %s\n", count-1, chainID)
+	_, err = backend.Run(context.Background(), compose.RunOptions{
+		Image: project.Config.Services[generate.MageNodeService].Image,
+		Cmd:   []string{"sh", "-c", validatorsScript(mageNode.Binary, nodes, chainID, bondDenom, selfDelegation)},
+		Binds: binds,
+	})
+	if err != nil {
+		return fmt.Errorf("could not create validators: %w", err)
+	}
+
+	if err := generate.CopyNodeConfig(nodes); err != nil {
+		return err
+	}
+	return generate.ConnectValidatorNodes(nodes)
+}
+
+// validatorsScript creates a shell script that initializes the new nodes' homes, funds a validator account for each,
+// and collects their gentxs into the first node's genesis.
+func validatorsScript(binary string, nodes []generate.ValidatorNode, chainID, bondDenom string, selfDelegation sdk.Int) string {
+	// fund the validators with 10 times their stake so they can pay fees
+	funding := selfDelegation.MulRaw(10).String() + bondDenom
+	stake := selfDelegation.String() + bondDenom
+	lines := []string{"set -e"}
+	for i, node := range nodes[1:] {
+		home := fmt.Sprintf("/nodes/node%d", i+1)
+		lines = append(lines,
+			fmt.Sprintf("%s init %s --chain-id %s --home %s > /dev/null 2>&1", binary, node.ComposeService, chainID, home),
+			fmt.Sprintf("rm -rf %s/data %s/config/genesis.json", home, home),
+			fmt.Sprintf("%s keys add validator --keyring-backend test --home %s > /dev/null 2>&1", binary, home),
+			fmt.Sprintf("%s add-genesis-account $(%s keys show validator -a --keyring-backend test --home %s) %s --home /nodes/node0", binary, binary, home, funding),
+		)
+	}
+	for i, node := range nodes[1:] {
+		home := fmt.Sprintf("/nodes/node%d", i+1)
+		lines = append(lines,
+			fmt.Sprintf("cp /nodes/node0/config/genesis.json %s/config/genesis.json", home),
+			fmt.Sprintf("%s gentx validator %s --moniker %s --chain-id %s --keyring-backend test --home %s --output-document /nodes/node0/config/gentx/gentx-%s.json",
+				binary, stake, node.ComposeService, chainID, home, node.ComposeService),
+			fmt.Sprintf("rm %s/config/genesis.json", home),
+		)
+	}
+	lines = append(lines, fmt.Sprintf("%s collect-gentxs --home /nodes/node0 > /dev/null 2>&1", binary))
+	return strings.Join(lines, "\n")
+}
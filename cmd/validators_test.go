@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+
+	"github.com/furya-official/mgtool/config/generate"
+)
+
+func TestValidatorsScriptFunding(t *testing.T) {
+	// stakes can be larger than an int64
+	selfDelegation, ok := sdk.NewIntFromString("99999999999999999999")
+	if !ok {
+		t.Fatal("invalid self delegation")
+	}
+	nodes := []generate.ValidatorNode{{ComposeService: "magenode"}, {ComposeService: "magenode-1"}}
+
+	script := validatorsScript("mage", nodes, "mage-localnet", "ukava", selfDelegation)
+
+	// validators are funded with 10 times their stake
+	if !strings.Contains(script, " 999999999999999999990ukava --home /nodes/node0") {
+		t.Fatalf("expected the validator to be funded with 999999999999999999990ukava, got script:\n%s", script)
+	}
+	if !strings.Contains(script, "gentx validator 99999999999999999999ukava ") {
+		t.Fatalf("expected the validator to stake 99999999999999999999ukava, got script:\n%s", script)
+	}
+}
@@ -0,0 +1,144 @@
+package compose
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Backend runs the containers of a compose project.
+type Backend interface {
+	// Up creates and starts the named services, or all services if none are named.
+	// Containers that are already up to date with the compose file are left as they are.
+	Up(ctx context.Context, project Project, services ...string) error
+	// Create creates the named services' containers without starting them, or all services if none are named.
+	Create(ctx context.Context, project Project, services ...string) error
+	// Down stops and removes the project's containers and network.
+	Down(ctx context.Context, project Project) error
+	// Stop stops the named services, or all services if none are named.
+	Stop(ctx context.Context, project Project, services ...string) error
+	// Start starts the named services' existing containers, or all containers if none are named.
+	Start(ctx context.Context, project Project, services ...string) error
+	// Pull fetches the latest images for all services that don't build their own.
+	Pull(ctx context.Context, project Project) error
+	// Containers lists the state of the project's containers.
+	Containers(ctx context.Context, project Project) ([]ContainerState, error)
+	// Run runs a one off container to completion and returns its stdout.
+	// It returns an error if the container exits with a non zero code.
+	Run(ctx context.Context, opts RunOptions) ([]byte, error)
+	// Build builds an image from a directory containing a Dockerfile. dockerfile can be empty to use the default name.
+	Build(ctx context.Context, contextDir, dockerfile, image string) error
+	// Commit creates an image from a container's current state.
+	Commit(ctx context.Context, containerID, image string) error
+	// RemoveImage deletes an image.
+	RemoveImage(ctx context.Context, image string) error
+	// Logs streams a container's stdout and stderr.
+	Logs(ctx context.Context, containerID string, opts LogOptions) (io.ReadCloser, error)
+	// CopyFrom returns a tar archive of a file or directory in a container.
+	CopyFrom(ctx context.Context, containerID, path string) (io.ReadCloser, error)
+	// CopyTo extracts a tar archive into a directory in a container. The container doesn't need to be running.
+	CopyTo(ctx context.Context, containerID, dir string, archive io.Reader) error
+}
+
+// ContainerState describes a container belonging to a compose project.
+type ContainerState struct {
+	ID      string
+	Name    string
+	Service string
+	Image   string
+	// State is the container's state, eg running or exited.
+	State string
+	// Status is a human readable description of the state, eg "Up 5 minutes".
+	Status string
+	Ports  []PortBinding
+}
+
+// Running reports if the container is running.
+func (c ContainerState) Running() bool {
+	return c.State == "running"
+}
+
+// PortBinding is a container port published on the host.
+type PortBinding struct {
+	HostIP        string
+	HostPort      string
+	ContainerPort string
+	Protocol      string
+}
+
+func (p PortBinding) String() string {
+	if p.HostPort == "" {
+		return fmt.Sprintf("%s/%s", p.ContainerPort, p.Protocol)
+	}
+	return fmt.Sprintf("%s:%s->%s/%s", p.HostIP, p.HostPort, p.ContainerPort, p.Protocol)
+}
+
+// ParsePort parses a compose port of the form [[host_ip:]host_port:]container_port[/protocol].
+// HostPort is empty if the port isn't published on a fixed host port.
+func ParsePort(mapping string) (PortBinding, error) {
+	port := PortBinding{Protocol: "tcp"}
+	if i := strings.LastIndex(mapping, "/"); i >= 0 {
+		port.Protocol = mapping[i+1:]
+		mapping = mapping[:i]
+	}
+	parts := strings.Split(mapping, ":")
+	switch len(parts) {
+	case 1:
+	case 2:
+		port.HostPort = parts[0]
+	case 3:
+		port.HostIP, port.HostPort = parts[0], parts[1]
+	default:
+		return PortBinding{}, fmt.Errorf("invalid port mapping %s", mapping)
+	}
+	port.ContainerPort = parts[len(parts)-1]
+	return port, nil
+}
+
+// RunOptions configures a one off container.
+type RunOptions struct {
+	Image string
+	Cmd   []string
+	// Binds are volumes to mount, in the form host_path:container_path.
+	Binds []string
+	// Network to connect the container to, eg a project's network so it can reach the project's services.
+	Network string
+	// Output, if set, receives the container's stdout and stderr as it runs.
+	Output io.Writer
+}
+
+// LogOptions configures which logs are streamed.
+type LogOptions struct {
+	// Follow keeps the stream open for new logs.
+	Follow bool
+	// Since only returns logs after a time. It can be a unix timestamp or a duration relative to now, eg 10m.
+	Since string
+	// Tail limits the number of lines from the end of the logs. Empty means all.
+	Tail string
+	// Timestamps prefixes each line with its time.
+	Timestamps bool
+}
+
+// Error is returned when the container engine fails to carry out an operation.
+type Error struct {
+	// Op is the operation that failed, eg "create container".
+	Op string
+	// Target is the container, image, or network the operation was on.
+	Target string
+	// StatusCode is the engine's http status code, if it responded.
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("could not %s %s: %s (status %d)", e.Op, e.Target, e.Message, e.StatusCode)
+	}
+	return fmt.Sprintf("could not %s %s: %s", e.Op, e.Target, e.Message)
+}
+
+// NotFound reports if the error was caused by a missing container, image, or network.
+func (e *Error) NotFound() bool {
+	return e.StatusCode == 404
+}
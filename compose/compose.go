@@ -0,0 +1,240 @@
+package compose
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Project is a set of services defined in a docker-compose file.
+type Project struct {
+	// Name prefixes the names of the project's containers and networks, the same as docker-compose's project name.
+	Name string
+	// File is the path to the docker-compose file.
+	File string
+	// Config is the parsed docker-compose file.
+	Config File
+}
+
+// Dir is the directory relative paths in the compose file are resolved against.
+func (p Project) Dir() string {
+	return filepath.Dir(p.File)
+}
+
+// NetworkName is the name of the network all the project's services are attached to.
+func (p Project) NetworkName() string {
+	return p.Name + "_default"
+}
+
+// ContainerName is the name of the container running a service.
+func (p Project) ContainerName(service string) string {
+	return fmt.Sprintf("%s_%s_1", p.Name, service)
+}
+
+// ServiceNames returns the names of the services in the project in alphabetical order.
+func (p Project) ServiceNames() []string {
+	var names []string
+	for name := range p.Config.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ResolveVolume converts a relative bind mount to an absolute path and prefixes a named volume with the project name.
+func (p Project) ResolveVolume(volume string) string {
+	parts := strings.SplitN(volume, ":", 2)
+	if len(parts) < 2 {
+		return volume
+	}
+	source := parts[0]
+	switch {
+	case strings.HasPrefix(source, "."):
+		source = filepath.Join(p.Dir(), source)
+	case strings.HasPrefix(source, "~"):
+		if home, err := os.UserHomeDir(); err == nil {
+			source = filepath.Join(home, source[1:])
+		}
+	case !strings.HasPrefix(source, "/"):
+		source = p.Name + "_" + source
+	}
+	return source + ":" + parts[1]
+}
+
+var nonProjectNameChars = regexp.MustCompile("[^a-z0-9]")
+
+// LoadProject reads a docker-compose file. Unless the file sets a name, the project is named after the file's directory, like docker-compose does.
+func LoadProject(filename string) (Project, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return Project{}, err
+	}
+	bz, err := ioutil.ReadFile(abs)
+	if err != nil {
+		return Project{}, err
+	}
+	var config File
+	if err := yaml.Unmarshal(bz, &config); err != nil {
+		return Project{}, fmt.Errorf("could not parse compose file %s: %w", filename, err)
+	}
+	name := config.Name
+	if name == "" {
+		name = nonProjectNameChars.ReplaceAllString(strings.ToLower(filepath.Base(filepath.Dir(abs))), "")
+	}
+	return Project{
+		Name:   name,
+		File:   abs,
+		Config: config,
+	}, nil
+}
+
+// File is the subset of the docker-compose file format used by the config templates.
+type File struct {
+	// Name is the project name kvtool records in an extension field. It's optional, projects are named after their
+	// directory by default.
+	Name     string             `yaml:"x-kvtool-project-name"`
+	Version  string             `yaml:"version"`
+	Services map[string]Service `yaml:"services"`
+}
+
+// Service is a service definition in a docker-compose file.
+type Service struct {
+	Image       string       `yaml:"image"`
+	Build       Build        `yaml:"build"`
+	Ports       []string     `yaml:"ports"`
+	Volumes     []string     `yaml:"volumes"`
+	Command     ShellCommand `yaml:"command"`
+	Entrypoint  ShellCommand `yaml:"entrypoint"`
+	Environment Environment  `yaml:"environment"`
+}
+
+// Build describes how to build a service's image. It can be written as a string or a mapping in compose files.
+type Build struct {
+	Context    string `yaml:"context"`
+	Dockerfile string `yaml:"dockerfile"`
+}
+
+func (b *Build) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		b.Context = value.Value
+		return nil
+	}
+	type plain Build
+	return value.Decode((*plain)(b))
+}
+
+// ShellCommand is a command that can be written as a string or a list in compose files.
+type ShellCommand []string
+
+func (c *ShellCommand) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		words, err := splitWords(value.Value)
+		if err != nil {
+			return err
+		}
+		*c = words
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*c = list
+	return nil
+}
+
+// Environment is a list of KEY=VALUE variables. It can be written as a mapping or a list in compose files.
+// Variables without a value, KEY in a list or KEY: in a mapping, are passed through from the host.
+type Environment []string
+
+func (e *Environment) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.MappingNode {
+		var m map[string]*string
+		if err := value.Decode(&m); err != nil {
+			return err
+		}
+		var env []string
+		for k, v := range m {
+			if v == nil {
+				env = append(env, k)
+				continue
+			}
+			env = append(env, k+"="+*v)
+		}
+		sort.Strings(env)
+		*e = env
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*e = list
+	return nil
+}
+
+// Resolve returns the variables for a container, taking the values of variables listed without one from the host's
+// environment like docker-compose. Variables the host doesn't set are left out.
+func (e Environment) Resolve() []string {
+	var env []string
+	for _, v := range e {
+		if strings.Contains(v, "=") {
+			env = append(env, v)
+			continue
+		}
+		if value, ok := os.LookupEnv(v); ok {
+			env = append(env, v+"="+value)
+		}
+	}
+	return env
+}
+
+// splitWords splits a command string into words, respecting quotes, in the same way as docker-compose.
+func splitWords(s string) ([]string, error) {
+	var words []string
+	var current strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in command: %s", s)
+	}
+	if inWord {
+		words = append(words, current.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,38 @@
+package compose
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestEnvironment(t *testing.T) {
+	testCases := []struct {
+		name string
+		yaml string
+	}{
+		{"mapping", "A: a\nEMPTY: ''\nFROM_HOST:\nUNSET:\n"},
+		{"list", "[A=a, EMPTY=, FROM_HOST, UNSET]"},
+	}
+	os.Setenv("FROM_HOST", "host")
+	os.Unsetenv("UNSET")
+	defer os.Unsetenv("FROM_HOST")
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var env Environment
+			if err := yaml.Unmarshal([]byte(tc.yaml), &env); err != nil {
+				t.Fatal(err)
+			}
+			// variables without a value are listed bare in both forms, an empty value is kept
+			if expected := (Environment{"A=a", "EMPTY=", "FROM_HOST", "UNSET"}); !reflect.DeepEqual(expected, env) {
+				t.Fatalf("expected %v, got %v", expected, env)
+			}
+			// they're passed through from the host, or left out if it doesn't set them
+			if expected, actual := []string{"A=a", "EMPTY=", "FROM_HOST=host"}, env.Resolve(); !reflect.DeepEqual(expected, actual) {
+				t.Fatalf("expected %v, got %v", expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+// Package composetest provides an in memory compose.Backend, for testing commands that run testnets without docker.
+package composetest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/furya-official/mgtool/compose"
+)
+
+// Backend is an in memory compose.Backend. It records the operations performed on it and tracks container state.
+type Backend struct {
+	mu sync.Mutex
+	// Calls records each operation, eg "up magenode" or "run mage/relayer:v1.0.0 tx link transfer".
+	Calls []string
+	// RunFunc, if set, provides the output of Run calls.
+	RunFunc func(opts compose.RunOptions) ([]byte, error)
+	// LogData maps container IDs to the logs returned for them.
+	LogData map[string]string
+	// Archives maps "<container id>:<path>" to the tar archives returned by CopyFrom.
+	// CopyTo stores the archives it's given here under "<container id>:<dir>".
+	Archives map[string][]byte
+
+	containers map[string]*compose.ContainerState
+	images     map[string]bool
+}
+
+var _ compose.Backend = (*Backend)(nil)
+
+// NewBackend creates a Backend with no containers.
+func NewBackend() *Backend {
+	return &Backend{
+		LogData:    map[string]string{},
+		Archives:   map[string][]byte{},
+		containers: map[string]*compose.ContainerState{},
+		images:     map[string]bool{},
+	}
+}
+
+func (b *Backend) record(format string, args ...interface{}) {
+	b.Calls = append(b.Calls, strings.TrimSpace(fmt.Sprintf(format, args...)))
+}
+
+func (b *Backend) Up(_ context.Context, project compose.Project, services ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(services) == 0 {
+		services = project.ServiceNames()
+	}
+	b.record("up %s", strings.Join(services, " "))
+	return b.createContainers(project, services, "running")
+}
+
+func (b *Backend) Create(_ context.Context, project compose.Project, services ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(services) == 0 {
+		services = project.ServiceNames()
+	}
+	b.record("create %s", strings.Join(services, " "))
+	return b.createContainers(project, services, "created")
+}
+
+func (b *Backend) createContainers(project compose.Project, services []string, state string) error {
+	for _, name := range services {
+		service, found := project.Config.Services[name]
+		if !found {
+			return fmt.Errorf("no service %s in %s", name, project.File)
+		}
+		c := &compose.ContainerState{
+			ID:      project.ContainerName(name),
+			Name:    project.ContainerName(name),
+			Service: name,
+			Image:   service.Image,
+			State:   state,
+		}
+		if state == "running" {
+			c.Status = "Up"
+		}
+		for _, p := range service.Ports {
+			port, err := compose.ParsePort(p)
+			if err != nil {
+				return err
+			}
+			c.Ports = append(c.Ports, port)
+		}
+		b.containers[c.ID] = c
+	}
+	return nil
+}
+
+func (b *Backend) Down(_ context.Context, project compose.Project) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.record("down")
+	for id, c := range b.containers {
+		if strings.HasPrefix(c.Name, project.Name+"_") {
+			delete(b.containers, id)
+		}
+	}
+	return nil
+}
+
+func (b *Backend) Stop(_ context.Context, project compose.Project, services ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.record("stop %s", strings.Join(services, " "))
+	return b.setState(project, services, "exited")
+}
+
+func (b *Backend) Start(_ context.Context, project compose.Project, services ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.record("start %s", strings.Join(services, " "))
+	return b.setState(project, services, "running")
+}
+
+func (b *Backend) setState(project compose.Project, services []string, state string) error {
+	if len(services) == 0 {
+		services = project.ServiceNames()
+	}
+	for _, s := range services {
+		c, found := b.containers[project.ContainerName(s)]
+		if !found {
+			continue
+		}
+		c.State = state
+	}
+	return nil
+}
+
+func (b *Backend) Pull(_ context.Context, project compose.Project) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.record("pull")
+	return nil
+}
+
+func (b *Backend) Containers(_ context.Context, project compose.Project) ([]compose.ContainerState, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var containers []compose.ContainerState
+	for _, c := range b.containers {
+		if strings.HasPrefix(c.Name, project.Name+"_") {
+			containers = append(containers, *c)
+		}
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].Name < containers[j].Name })
+	return containers, nil
+}
+
+func (b *Backend) Run(_ context.Context, opts compose.RunOptions) ([]byte, error) {
+	b.mu.Lock()
+	b.record("run %s %s", opts.Image, strings.Join(opts.Cmd, " "))
+	runFunc := b.RunFunc
+	b.mu.Unlock()
+
+	if runFunc == nil {
+		return nil, nil
+	}
+	output, err := runFunc(opts)
+	if opts.Output != nil {
+		opts.Output.Write(output)
+	}
+	return output, err
+}
+
+func (b *Backend) Build(_ context.Context, contextDir, dockerfile, image string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.record("build %s %s %s", contextDir, dockerfile, image)
+	b.images[image] = true
+	return nil
+}
+
+func (b *Backend) Commit(_ context.Context, containerID, image string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.record("commit %s %s", containerID, image)
+	if _, found := b.containers[containerID]; !found {
+		return &compose.Error{Op: "commit container", Target: containerID, StatusCode: 404, Message: "no such container"}
+	}
+	b.images[image] = true
+	return nil
+}
+
+func (b *Backend) RemoveImage(_ context.Context, image string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.record("rmi %s", image)
+	if !b.images[image] {
+		return &compose.Error{Op: "remove image", Target: image, StatusCode: 404, Message: "no such image"}
+	}
+	delete(b.images, image)
+	return nil
+}
+
+func (b *Backend) Logs(_ context.Context, containerID string, _ compose.LogOptions) (io.ReadCloser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.record("logs %s", containerID)
+	return ioutil.NopCloser(bytes.NewBufferString(b.LogData[containerID])), nil
+}
+
+func (b *Backend) CopyFrom(_ context.Context, containerID, path string) (io.ReadCloser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.record("copy from %s:%s", containerID, path)
+	archive, found := b.Archives[containerID+":"+path]
+	if !found {
+		return nil, &compose.Error{Op: "copy from", Target: containerID + ":" + path, StatusCode: 404, Message: "no such file or directory"}
+	}
+	return ioutil.NopCloser(bytes.NewReader(archive)), nil
+}
+
+func (b *Backend) CopyTo(_ context.Context, containerID, dir string, archive io.Reader) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.record("copy to %s:%s", containerID, dir)
+	if _, found := b.containers[containerID]; !found {
+		return &compose.Error{Op: "copy to", Target: containerID + ":" + dir, StatusCode: 404, Message: "no such container"}
+	}
+	bz, err := ioutil.ReadAll(archive)
+	if err != nil {
+		return err
+	}
+	b.Archives[containerID+":"+dir] = bz
+	return nil
+}
@@ -0,0 +1,141 @@
+package compose
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignorePattern is a line of a .dockerignore file.
+type ignorePattern struct {
+	regexp *regexp.Regexp
+	// exception is set for patterns starting with !, which include files an earlier pattern excluded.
+	exception bool
+}
+
+// dockerIgnore matches paths in a build context against the patterns of its .dockerignore file,
+// following the rules of the docker cli: the last matching pattern wins, and excluding a folder excludes its contents.
+type dockerIgnore struct {
+	patterns      []ignorePattern
+	hasExceptions bool
+}
+
+// readDockerIgnore reads the .dockerignore file in a build context. A context without one ignores nothing.
+func readDockerIgnore(contextDir string) (*dockerIgnore, error) {
+	f, err := os.Open(filepath.Join(contextDir, ".dockerignore"))
+	if os.IsNotExist(err) {
+		return &dockerIgnore{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read .dockerignore: %w", err)
+	}
+	return parseDockerIgnore(lines)
+}
+
+// parseDockerIgnore parses the lines of a .dockerignore file, skipping blank lines and comments.
+func parseDockerIgnore(lines []string) (*dockerIgnore, error) {
+	ignore := &dockerIgnore{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := ignorePattern{}
+		if strings.HasPrefix(line, "!") {
+			p.exception = true
+			ignore.hasExceptions = true
+			line = strings.TrimSpace(line[1:])
+		}
+		// patterns are relative to the context, a leading slash makes no difference
+		line = strings.TrimPrefix(path.Clean(filepath.ToSlash(line)), "/")
+		if line == "." || line == "" {
+			continue
+		}
+		re, err := ignoreRegexp(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid .dockerignore pattern '%s': %w", line, err)
+		}
+		p.regexp = re
+		ignore.patterns = append(ignore.patterns, p)
+	}
+	return ignore, nil
+}
+
+// ignoreRegexp converts a .dockerignore pattern to a regular expression. * and ? don't match a /,
+// ** matches any number of folders, and character classes are passed through.
+func ignoreRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '*' && strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case c == '*' && strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// excluded reports if a path relative to the context, using forward slashes, is left out of the build context.
+func (d *dockerIgnore) excluded(rel string) bool {
+	excluded := false
+	for _, p := range d.patterns {
+		if p.matches(rel) {
+			excluded = !p.exception
+		}
+	}
+	return excluded
+}
+
+// matches reports if a pattern matches a path or one of the folders it's in.
+func (p ignorePattern) matches(rel string) bool {
+	for {
+		if p.regexp.MatchString(rel) {
+			return true
+		}
+		parent := path.Dir(rel)
+		if parent == "." || parent == rel {
+			return false
+		}
+		rel = parent
+	}
+}
@@ -0,0 +1,103 @@
+package compose
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDockerIgnore(t *testing.T) {
+	ignore, err := parseDockerIgnore([]string{
+		"# build output and version control",
+		".git",
+		"/build",
+		"**/*.log",
+		"docs/*.md",
+		"!docs/README.md",
+		"tmp?",
+		"out/[a-c].txt",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		path     string
+		excluded bool
+	}{
+		{".git", true},
+		{".git/objects/ab/cdef", true},
+		{".github/workflows/ci.yml", false},
+		{"build", true},
+		{"build/mage", true},
+		{"cmd/build/main.go", false},
+		{"debug.log", true},
+		{"app/logs/debug.log", true},
+		{"docs/setup.md", true},
+		{"docs/README.md", false},
+		{"docs/guides/setup.md", false},
+		{"tmp1/file", true},
+		{"tmp12/file", false},
+		{"out/b.txt", true},
+		{"out/d.txt", false},
+		{"main.go", false},
+	}
+	for _, tc := range testCases {
+		if actual := ignore.excluded(tc.path); actual != tc.excluded {
+			t.Errorf("expected %s excluded to be %t, got %t", tc.path, tc.excluded, actual)
+		}
+	}
+}
+
+func TestWriteTarDockerIgnore(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		".dockerignore":     ".git\n*.log\nDockerfile\n",
+		"Dockerfile":        "FROM scratch\n",
+		"main.go":           "package main\n",
+		"build.log":         "output\n",
+		".git/HEAD":         "ref: refs/heads/master\n",
+		".git/objects/ab/c": "object\n",
+	} {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ignore, err := readDockerIgnore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeTar(&buf, dir, ignore, "Dockerfile", ".dockerignore"); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(&buf)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+	sort.Strings(names)
+
+	// the dockerfile and .dockerignore are sent even when they're ignored
+	expected := []string{".dockerignore", "Dockerfile", "main.go"}
+	if !reflect.DeepEqual(expected, names) {
+		t.Fatalf("expected the context to contain %v, got %v", expected, names)
+	}
+}
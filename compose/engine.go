@@ -0,0 +1,858 @@
+package compose
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	engineAPIVersion = "v1.41"
+
+	projectLabel    = "com.docker.compose.project"
+	serviceLabel    = "com.docker.compose.service"
+	numberLabel     = "com.docker.compose.container-number"
+	oneoffLabel     = "com.docker.compose.oneoff"
+	configHashLabel = "com.docker.compose.config-hash"
+	networkLabel    = "com.docker.compose.network"
+)
+
+// EngineBackend runs compose projects by talking to the Docker Engine API directly.
+type EngineBackend struct {
+	client  *http.Client
+	baseURL string
+	// Output receives progress messages from image pulls and builds.
+	Output io.Writer
+}
+
+var _ Backend = (*EngineBackend)(nil)
+
+// NewEngineBackend connects to the docker daemon at DOCKER_HOST, or the default unix socket if it's not set.
+func NewEngineBackend() (*EngineBackend, error) {
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		host = "unix:///var/run/docker.sock"
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOCKER_HOST %s: %w", host, err)
+	}
+	backend := &EngineBackend{Output: os.Stdout}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		backend.client = &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		}
+		backend.baseURL = "http://docker"
+	case "tcp", "http":
+		backend.client = &http.Client{}
+		backend.baseURL = "http://" + u.Host
+	default:
+		return nil, fmt.Errorf("unsupported DOCKER_HOST scheme %s", u.Scheme)
+	}
+	return backend, nil
+}
+
+func (e *EngineBackend) Up(ctx context.Context, project Project, services ...string) error {
+	return e.upServices(ctx, project, services, true)
+}
+
+func (e *EngineBackend) Create(ctx context.Context, project Project, services ...string) error {
+	return e.upServices(ctx, project, services, false)
+}
+
+// upServices creates the containers of services that don't have an up to date one, and starts them if start is set.
+func (e *EngineBackend) upServices(ctx context.Context, project Project, services []string, start bool) error {
+	if len(services) == 0 {
+		services = project.ServiceNames()
+	}
+	if err := e.ensureNetwork(ctx, project); err != nil {
+		return err
+	}
+	for _, name := range services {
+		service, found := project.Config.Services[name]
+		if !found {
+			return fmt.Errorf("no service %s in %s", name, project.File)
+		}
+		if err := e.upService(ctx, project, name, service, start); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *EngineBackend) Down(ctx context.Context, project Project) error {
+	containers, err := e.Containers(ctx, project)
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		fmt.Fprintf(e.Output, "removing %s\n", c.Name)
+		if err := e.stopContainer(ctx, c.ID); err != nil {
+			return err
+		}
+		if err := e.removeContainer(ctx, c.ID); err != nil {
+			return err
+		}
+	}
+	err = e.call(ctx, "remove network", project.NetworkName(), http.MethodDelete, "/networks/"+project.NetworkName(), nil, nil, nil)
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (e *EngineBackend) Stop(ctx context.Context, project Project, services ...string) error {
+	containers, err := e.serviceContainers(ctx, project, services)
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		fmt.Fprintf(e.Output, "stopping %s\n", c.Name)
+		if err := e.stopContainer(ctx, c.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *EngineBackend) Start(ctx context.Context, project Project, services ...string) error {
+	containers, err := e.serviceContainers(ctx, project, services)
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		fmt.Fprintf(e.Output, "starting %s\n", c.Name)
+		if err := e.startContainer(ctx, c.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *EngineBackend) Pull(ctx context.Context, project Project) error {
+	for _, name := range project.ServiceNames() {
+		service := project.Config.Services[name]
+		if service.Build.Context != "" || service.Image == "" {
+			continue
+		}
+		if err := e.pullImage(ctx, service.Image); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *EngineBackend) Containers(ctx context.Context, project Project) ([]ContainerState, error) {
+	filters, err := json.Marshal(map[string][]string{"label": {projectLabel + "=" + project.Name}})
+	if err != nil {
+		return nil, err
+	}
+	var response []struct {
+		ID     string            `json:"Id"`
+		Names  []string          `json:"Names"`
+		Image  string            `json:"Image"`
+		State  string            `json:"State"`
+		Status string            `json:"Status"`
+		Labels map[string]string `json:"Labels"`
+		Ports  []struct {
+			IP          string `json:"IP"`
+			PrivatePort int    `json:"PrivatePort"`
+			PublicPort  int    `json:"PublicPort"`
+			Type        string `json:"Type"`
+		} `json:"Ports"`
+	}
+	query := url.Values{"all": {"1"}, "filters": {string(filters)}}
+	if err := e.call(ctx, "list containers for", project.Name, http.MethodGet, "/containers/json", query, nil, &response); err != nil {
+		return nil, err
+	}
+
+	var containers []ContainerState
+	for _, r := range response {
+		c := ContainerState{
+			ID:      r.ID,
+			Service: r.Labels[serviceLabel],
+			Image:   r.Image,
+			State:   r.State,
+			Status:  r.Status,
+		}
+		if len(r.Names) > 0 {
+			c.Name = strings.TrimPrefix(r.Names[0], "/")
+		}
+		for _, p := range r.Ports {
+			binding := PortBinding{
+				HostIP:        p.IP,
+				ContainerPort: fmt.Sprint(p.PrivatePort),
+				Protocol:      p.Type,
+			}
+			if p.PublicPort != 0 {
+				binding.HostPort = fmt.Sprint(p.PublicPort)
+			}
+			c.Ports = append(c.Ports, binding)
+		}
+		containers = append(containers, c)
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].Name < containers[j].Name })
+	return containers, nil
+}
+
+func (e *EngineBackend) Run(ctx context.Context, opts RunOptions) ([]byte, error) {
+	if err := e.ensureImage(ctx, opts.Image); err != nil {
+		return nil, err
+	}
+	config := containerConfig{
+		Image: opts.Image,
+		Cmd:   opts.Cmd,
+		HostConfig: hostConfig{
+			Binds:       opts.Binds,
+			NetworkMode: opts.Network,
+		},
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := e.call(ctx, "create container from", opts.Image, http.MethodPost, "/containers/create", nil, config, &created); err != nil {
+		return nil, err
+	}
+	defer func() {
+		// clean up even if the context has been cancelled
+		_ = e.removeContainer(context.Background(), created.ID)
+	}()
+
+	if err := e.startContainer(ctx, created.ID); err != nil {
+		return nil, err
+	}
+
+	query := url.Values{"stdout": {"1"}, "stderr": {"1"}, "follow": {"1"}}
+	resp, err := e.do(ctx, http.MethodGet, "/containers/"+created.ID+"/logs", query, nil, "")
+	if err != nil {
+		return nil, &Error{Op: "get logs for", Target: created.ID, Message: err.Error()}
+	}
+	if err := checkResponse(resp, "get logs for", created.ID); err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var output, errOutput bytes.Buffer
+	var stdout, stderr io.Writer = &output, &errOutput
+	if opts.Output != nil {
+		stdout = io.MultiWriter(&output, opts.Output)
+		stderr = io.MultiWriter(&errOutput, opts.Output)
+	}
+	if err := demultiplex(stdout, stderr, resp.Body); err != nil {
+		return output.Bytes(), err
+	}
+
+	var waited struct {
+		StatusCode int `json:"StatusCode"`
+	}
+	if err := e.call(ctx, "wait for container", created.ID, http.MethodPost, "/containers/"+created.ID+"/wait", nil, nil, &waited); err != nil {
+		return output.Bytes(), err
+	}
+	if waited.StatusCode != 0 {
+		return output.Bytes(), &Error{
+			Op:      "run",
+			Target:  strings.Join(append([]string{opts.Image}, opts.Cmd...), " "),
+			Message: fmt.Sprintf("exited with code %d: %s", waited.StatusCode, lastLine(errOutput.String()+output.String())),
+		}
+	}
+	return output.Bytes(), nil
+}
+
+func (e *EngineBackend) Commit(ctx context.Context, containerID, image string) error {
+	repo, tag := splitImageTag(image)
+	query := url.Values{"container": {containerID}, "repo": {repo}, "tag": {tag}}
+	return e.call(ctx, "commit container", containerID, http.MethodPost, "/commit", query, nil, nil)
+}
+
+func (e *EngineBackend) RemoveImage(ctx context.Context, image string) error {
+	return e.call(ctx, "remove image", image, http.MethodDelete, "/images/"+image, nil, nil, nil)
+}
+
+func (e *EngineBackend) Logs(ctx context.Context, containerID string, opts LogOptions) (io.ReadCloser, error) {
+	query := url.Values{"stdout": {"1"}, "stderr": {"1"}}
+	if opts.Follow {
+		query.Set("follow", "1")
+	}
+	if opts.Since != "" {
+		since, err := parseSince(opts.Since)
+		if err != nil {
+			return nil, err
+		}
+		query.Set("since", since)
+	}
+	if opts.Tail != "" {
+		query.Set("tail", opts.Tail)
+	}
+	if opts.Timestamps {
+		query.Set("timestamps", "1")
+	}
+	resp, err := e.do(ctx, http.MethodGet, "/containers/"+containerID+"/logs", query, nil, "")
+	if err != nil {
+		return nil, &Error{Op: "get logs for", Target: containerID, Message: err.Error()}
+	}
+	if err := checkResponse(resp, "get logs for", containerID); err != nil {
+		return nil, err
+	}
+
+	// logs of containers without a tty are multiplexed with headers marking stdout and stderr
+	r, w := io.Pipe()
+	go func() {
+		err := demultiplex(w, w, resp.Body)
+		resp.Body.Close()
+		w.CloseWithError(err)
+	}()
+	return r, nil
+}
+
+func (e *EngineBackend) CopyFrom(ctx context.Context, containerID, path string) (io.ReadCloser, error) {
+	resp, err := e.do(ctx, http.MethodGet, "/containers/"+containerID+"/archive", url.Values{"path": {path}}, nil, "")
+	if err != nil {
+		return nil, &Error{Op: "copy from", Target: containerID + ":" + path, Message: err.Error()}
+	}
+	if err := checkResponse(resp, "copy from", containerID+":"+path); err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (e *EngineBackend) CopyTo(ctx context.Context, containerID, dir string, archive io.Reader) error {
+	resp, err := e.do(ctx, http.MethodPut, "/containers/"+containerID+"/archive", url.Values{"path": {dir}}, archive, "application/x-tar")
+	if err != nil {
+		return &Error{Op: "copy to", Target: containerID + ":" + dir, Message: err.Error()}
+	}
+	if err := checkResponse(resp, "copy to", containerID+":"+dir); err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (e *EngineBackend) upService(ctx context.Context, project Project, name string, service Service, start bool) error {
+	image := service.Image
+	if service.Build.Context != "" {
+		if image == "" {
+			image = project.Name + "_" + name
+		}
+		if err := e.ensureBuilt(ctx, project, image, service.Build); err != nil {
+			return err
+		}
+	} else if err := e.ensureImage(ctx, image); err != nil {
+		return err
+	}
+
+	config, err := e.serviceContainerConfig(project, name, service, image)
+	if err != nil {
+		return err
+	}
+	containerName := project.ContainerName(name)
+
+	var existing struct {
+		ID     string `json:"Id"`
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"Config"`
+		State struct {
+			Running bool `json:"Running"`
+		} `json:"State"`
+	}
+	err = e.call(ctx, "inspect container", containerName, http.MethodGet, "/containers/"+containerName+"/json", nil, nil, &existing)
+	switch {
+	case err == nil && existing.Config.Labels[configHashLabel] == config.Labels[configHashLabel]:
+		if existing.State.Running || !start {
+			fmt.Fprintf(e.Output, "%s is up-to-date\n", containerName)
+			return nil
+		}
+		fmt.Fprintf(e.Output, "starting %s\n", containerName)
+		return e.startContainer(ctx, existing.ID)
+	case err == nil:
+		fmt.Fprintf(e.Output, "recreating %s\n", containerName)
+		if err := e.stopContainer(ctx, existing.ID); err != nil {
+			return err
+		}
+		if err := e.removeContainer(ctx, existing.ID); err != nil {
+			return err
+		}
+	case !isNotFound(err):
+		return err
+	default:
+		fmt.Fprintf(e.Output, "creating %s\n", containerName)
+	}
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+	query := url.Values{"name": {containerName}}
+	if err := e.call(ctx, "create container", containerName, http.MethodPost, "/containers/create", query, config, &created); err != nil {
+		return err
+	}
+	if !start {
+		return nil
+	}
+	return e.startContainer(ctx, created.ID)
+}
+
+type containerConfig struct {
+	Image            string              `json:"Image"`
+	Cmd              []string            `json:"Cmd,omitempty"`
+	Entrypoint       []string            `json:"Entrypoint,omitempty"`
+	Env              []string            `json:"Env,omitempty"`
+	Labels           map[string]string   `json:"Labels,omitempty"`
+	ExposedPorts     map[string]struct{} `json:"ExposedPorts,omitempty"`
+	HostConfig       hostConfig          `json:"HostConfig"`
+	NetworkingConfig *networkingConfig   `json:"NetworkingConfig,omitempty"`
+}
+
+type hostConfig struct {
+	Binds        []string                 `json:"Binds,omitempty"`
+	PortBindings map[string][]portBinding `json:"PortBindings,omitempty"`
+	NetworkMode  string                   `json:"NetworkMode,omitempty"`
+}
+
+type portBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+type networkingConfig struct {
+	EndpointsConfig map[string]endpointConfig `json:"EndpointsConfig"`
+}
+
+type endpointConfig struct {
+	Aliases []string `json:"Aliases"`
+}
+
+func (e *EngineBackend) serviceContainerConfig(project Project, name string, service Service, image string) (containerConfig, error) {
+	config := containerConfig{
+		Image:        image,
+		Cmd:          service.Command,
+		Entrypoint:   service.Entrypoint,
+		Env:          service.Environment.Resolve(),
+		ExposedPorts: map[string]struct{}{},
+		HostConfig: hostConfig{
+			PortBindings: map[string][]portBinding{},
+			NetworkMode:  project.NetworkName(),
+		},
+		NetworkingConfig: &networkingConfig{
+			EndpointsConfig: map[string]endpointConfig{
+				project.NetworkName(): {Aliases: []string{name}},
+			},
+		},
+	}
+	for _, p := range service.Ports {
+		containerPort, binding, err := parsePortMapping(p)
+		if err != nil {
+			return containerConfig{}, fmt.Errorf("service %s: %w", name, err)
+		}
+		config.ExposedPorts[containerPort] = struct{}{}
+		if binding.HostPort != "" {
+			config.HostConfig.PortBindings[containerPort] = append(config.HostConfig.PortBindings[containerPort], binding)
+		}
+	}
+	for _, v := range service.Volumes {
+		config.HostConfig.Binds = append(config.HostConfig.Binds, project.ResolveVolume(v))
+	}
+
+	// record the config so containers can be recreated when the compose file changes
+	bz, err := json.Marshal(config)
+	if err != nil {
+		return containerConfig{}, err
+	}
+	hash := sha256.Sum256(bz)
+	config.Labels = map[string]string{
+		projectLabel:    project.Name,
+		serviceLabel:    name,
+		numberLabel:     "1",
+		oneoffLabel:     "False",
+		configHashLabel: hex.EncodeToString(hash[:]),
+	}
+	return config, nil
+}
+
+// parsePortMapping parses a compose port into the engine's container port and host binding.
+func parsePortMapping(mapping string) (string, portBinding, error) {
+	port, err := ParsePort(mapping)
+	if err != nil {
+		return "", portBinding{}, err
+	}
+	return port.ContainerPort + "/" + port.Protocol, portBinding{HostIP: port.HostIP, HostPort: port.HostPort}, nil
+}
+
+func (e *EngineBackend) ensureNetwork(ctx context.Context, project Project) error {
+	name := project.NetworkName()
+	err := e.call(ctx, "inspect network", name, http.MethodGet, "/networks/"+name, nil, nil, nil)
+	if !isNotFound(err) {
+		return err
+	}
+	body := map[string]interface{}{
+		"Name":           name,
+		"CheckDuplicate": true,
+		"Labels": map[string]string{
+			projectLabel: project.Name,
+			networkLabel: "default",
+		},
+	}
+	return e.call(ctx, "create network", name, http.MethodPost, "/networks/create", nil, body, nil)
+}
+
+// ensureImage pulls an image if it isn't available locally.
+func (e *EngineBackend) ensureImage(ctx context.Context, image string) error {
+	err := e.call(ctx, "inspect image", image, http.MethodGet, "/images/"+image+"/json", nil, nil, nil)
+	if !isNotFound(err) {
+		return err
+	}
+	return e.pullImage(ctx, image)
+}
+
+func (e *EngineBackend) pullImage(ctx context.Context, image string) error {
+	fmt.Fprintf(e.Output, "pulling %s\n", image)
+	repo, tag := splitImageTag(image)
+	query := url.Values{"fromImage": {repo}, "tag": {tag}}
+	resp, err := e.do(ctx, http.MethodPost, "/images/create", query, nil, "")
+	if err != nil {
+		return &Error{Op: "pull image", Target: image, Message: err.Error()}
+	}
+	if err := checkResponse(resp, "pull image", image); err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return readProgress(resp.Body, "pull image", image, ioutil.Discard)
+}
+
+// ensureBuilt builds a service's image if it doesn't exist yet. Like docker-compose, existing images aren't rebuilt.
+func (e *EngineBackend) ensureBuilt(ctx context.Context, project Project, image string, build Build) error {
+	err := e.call(ctx, "inspect image", image, http.MethodGet, "/images/"+image+"/json", nil, nil, nil)
+	if !isNotFound(err) {
+		return err
+	}
+	contextDir := build.Context
+	if !filepath.IsAbs(contextDir) {
+		contextDir = filepath.Join(project.Dir(), contextDir)
+	}
+	return e.Build(ctx, contextDir, build.Dockerfile, image)
+}
+
+func (e *EngineBackend) Build(ctx context.Context, contextDir, dockerfile, image string) error {
+	fmt.Fprintf(e.Output, "building %s from %s\n", image, contextDir)
+	ignore, err := readDockerIgnore(contextDir)
+	if err != nil {
+		return &Error{Op: "build image", Target: image, Message: err.Error()}
+	}
+	// like docker build, the dockerfile and .dockerignore are sent even if they're ignored
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	keep := []string{filepath.ToSlash(filepath.Clean(dockerfile)), ".dockerignore"}
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(writeTar(w, contextDir, ignore, keep...))
+	}()
+	defer r.Close()
+
+	query := url.Values{"t": {image}}
+	if dockerfile != "" {
+		query.Set("dockerfile", dockerfile)
+	}
+	resp, err := e.do(ctx, http.MethodPost, "/build", query, r, "application/x-tar")
+	if err != nil {
+		return &Error{Op: "build image", Target: image, Message: err.Error()}
+	}
+	if err := checkResponse(resp, "build image", image); err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return readProgress(resp.Body, "build image", image, e.Output)
+}
+
+func (e *EngineBackend) serviceContainers(ctx context.Context, project Project, services []string) ([]ContainerState, error) {
+	containers, err := e.Containers(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	if len(services) == 0 {
+		return containers, nil
+	}
+	var selected []ContainerState
+	for _, service := range services {
+		found := false
+		for _, c := range containers {
+			if c.Service == service {
+				selected = append(selected, c)
+				found = true
+			}
+		}
+		if !found {
+			return nil, &Error{Op: "find container for", Target: service, StatusCode: 404, Message: "no container for service"}
+		}
+	}
+	return selected, nil
+}
+
+func (e *EngineBackend) startContainer(ctx context.Context, id string) error {
+	err := e.call(ctx, "start container", id, http.MethodPost, "/containers/"+id+"/start", nil, nil, nil)
+	if isNotModified(err) {
+		return nil
+	}
+	return err
+}
+
+func (e *EngineBackend) stopContainer(ctx context.Context, id string) error {
+	err := e.call(ctx, "stop container", id, http.MethodPost, "/containers/"+id+"/stop", url.Values{"t": {"10"}}, nil, nil)
+	if isNotModified(err) {
+		return nil
+	}
+	return err
+}
+
+func (e *EngineBackend) removeContainer(ctx context.Context, id string) error {
+	return e.call(ctx, "remove container", id, http.MethodDelete, "/containers/"+id, url.Values{"force": {"1"}}, nil, nil)
+}
+
+// call sends a request to the engine, encoding in as the JSON body and decoding the JSON response into out, if they're not nil.
+func (e *EngineBackend) call(ctx context.Context, op, target, method, path string, query url.Values, in, out interface{}) error {
+	var body io.Reader
+	contentType := ""
+	if in != nil {
+		bz, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(bz)
+		contentType = "application/json"
+	}
+	resp, err := e.do(ctx, method, path, query, body, contentType)
+	if err != nil {
+		return &Error{Op: op, Target: target, Message: err.Error()}
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, op, target); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return &Error{Op: op, Target: target, Message: fmt.Sprintf("could not decode response: %s", err)}
+	}
+	return nil
+}
+
+func (e *EngineBackend) do(ctx context.Context, method, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	u := e.baseURL + "/" + engineAPIVersion + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return e.client.Do(req)
+}
+
+// checkResponse converts error responses from the engine into an *Error, closing the body.
+func checkResponse(resp *http.Response, op, target string) error {
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return &Error{Op: op, Target: target, StatusCode: resp.StatusCode, Message: "not modified"}
+	}
+	var message struct {
+		Message string `json:"message"`
+	}
+	bz, _ := ioutil.ReadAll(resp.Body)
+	if err := json.Unmarshal(bz, &message); err != nil || message.Message == "" {
+		message.Message = strings.TrimSpace(string(bz))
+	}
+	return &Error{Op: op, Target: target, StatusCode: resp.StatusCode, Message: message.Message}
+}
+
+func isNotFound(err error) bool {
+	engineErr, ok := err.(*Error)
+	return ok && engineErr.NotFound()
+}
+
+func isNotModified(err error) bool {
+	engineErr, ok := err.(*Error)
+	return ok && engineErr.StatusCode == http.StatusNotModified
+}
+
+// readProgress reads the stream of JSON messages returned by pulls and builds, returning any error they contain.
+func readProgress(r io.Reader, op, target string, output io.Writer) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var message struct {
+			Stream      string `json:"stream"`
+			Error       string `json:"error"`
+			ErrorDetail struct {
+				Message string `json:"message"`
+			} `json:"errorDetail"`
+		}
+		err := decoder.Decode(&message)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &Error{Op: op, Target: target, Message: err.Error()}
+		}
+		if message.ErrorDetail.Message != "" {
+			return &Error{Op: op, Target: target, Message: message.ErrorDetail.Message}
+		}
+		if message.Error != "" {
+			return &Error{Op: op, Target: target, Message: message.Error}
+		}
+		if message.Stream != "" {
+			fmt.Fprint(output, message.Stream)
+		}
+	}
+}
+
+// demultiplex splits a multiplexed log stream into stdout and stderr, stripping the 8 byte frame headers.
+func demultiplex(stdout, stderr io.Writer, r io.Reader) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		w := stdout
+		if header[0] == 2 {
+			w = stderr
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(w, r, size); err != nil {
+			return err
+		}
+	}
+}
+
+// writeTar writes the contents of a directory to w as a tar archive, leaving out the files matched by ignore
+// unless they're listed in keep.
+func writeTar(w io.Writer, dir string, ignore *dockerIgnore, keep ...string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if slashRel := filepath.ToSlash(rel); ignore.excluded(slashRel) && !keepsPath(keep, slashRel) {
+			if info.IsDir() && !ignore.hasExceptions && !keepsFileIn(keep, slashRel) {
+				// nothing in an excluded folder can be included again
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// keepsPath reports if a path is one of the paths always added to a build context.
+func keepsPath(keep []string, rel string) bool {
+	for _, k := range keep {
+		if k == rel {
+			return true
+		}
+	}
+	return false
+}
+
+// keepsFileIn reports if a folder contains one of the paths always added to a build context.
+func keepsFileIn(keep []string, dir string) bool {
+	for _, k := range keep {
+		if strings.HasPrefix(k, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// splitImageTag splits an image reference into its repository and tag, defaulting to the latest tag.
+func splitImageTag(image string) (string, string) {
+	if strings.Contains(image, "@") {
+		return image, ""
+	}
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return image, "latest"
+	}
+	return image[:i], image[i+1:]
+}
+
+// parseSince converts a duration such as 10m into a unix timestamp, leaving timestamps as they are.
+func parseSince(since string) (string, error) {
+	if d, err := time.ParseDuration(since); err == nil {
+		return fmt.Sprint(time.Now().Add(-d).Unix()), nil
+	}
+	if t, err := time.Parse(time.RFC3339, since); err == nil {
+		return fmt.Sprint(t.Unix()), nil
+	}
+	for _, r := range since {
+		if (r < '0' || r > '9') && r != '.' {
+			return "", fmt.Errorf("invalid since value %s, must be a duration, RFC3339 time, or unix timestamp", since)
+		}
+	}
+	return since, nil
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return lines[len(lines)-1]
+}
@@ -0,0 +1,66 @@
+// Package config contains the templates testnet configs are generated from, and the common files they share.
+// They're embedded so kvtool works wherever it's installed, without a checkout of the repo.
+package config
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Files holds the templates and common folders.
+// Directory patterns skip hidden files, so the node home folders in initstate, eg .mage, are listed explicitly.
+//
+//go:embed common templates templates/*/initstate/.* templates/*/*/initstate/.*
+var Files embed.FS
+
+// Hash identifies the embedded files, so extracted copies from different kvtool versions can be told apart.
+func Hash() (string, error) {
+	hash := sha256.New()
+	err := fs.WalkDir(Files, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		bz, err := Files.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		// include the path so renames change the hash
+		io.WriteString(hash, path+"\x00")
+		hash.Write(bz)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Extract writes the embedded files into a directory, creating templates and common folders inside it.
+// Scripts are made executable as they're run by the containers that mount them.
+func Extract(dir string) error {
+	return fs.WalkDir(Files, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(path))
+		if d.IsDir() {
+			return os.MkdirAll(target, os.ModePerm)
+		}
+		bz, err := Files.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var mode os.FileMode = 0644
+		if strings.HasPrefix(string(bz), "#!") {
+			mode = 0755
+		}
+		return ioutil.WriteFile(target, bz, mode)
+	})
+}
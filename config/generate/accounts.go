@@ -0,0 +1,176 @@
+package generate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"github.com/Jeffail/gabs/v2"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+// FundedAccount is an account to add to a generated genesis with a balance.
+type FundedAccount struct {
+	Address string
+	Coins   sdk.Coins
+}
+
+// FundAccounts adds accounts and their balances to the genesis.json of a generated config's mage node.
+// Accounts that already exist have the coins added to their balance. The total supply is increased to match, unless
+// it's empty in which case the chain calculates it from the balances.
+// If validate is not nil the updated genesis is only written if it passes, or returns ErrGenesisNotValidated.
+func FundAccounts(accounts []FundedAccount, generatedConfigDir string, validate func(genesisJSON []byte) error) error {
+	genesisFile, err := findGenesisFile(filepath.Join(generatedConfigDir, "mage", "initstate"))
+	if err != nil {
+		return err
+	}
+	genesis, err := readJSON(genesisFile)
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		// sdk v0.40 and later store balances in the bank module, earlier versions store them in the accounts
+		if genesis.Exists("app_state", "bank", "balances") {
+			err = fundAccount(genesis, account)
+		} else {
+			err = fundLegacyAccount(genesis, account)
+		}
+		if err != nil {
+			return fmt.Errorf("could not fund %s: %w", account.Address, err)
+		}
+		fmt.Printf("funded %s with %s\n", account.Address, account.Coins)
+	}
+	bz := genesis.BytesIndent("", "  ")
+	if err := validateEdit(bz, validate); err != nil {
+		return fmt.Errorf("funded genesis is invalid: %w", err)
+	}
+	return ioutil.WriteFile(genesisFile, bz, 0644)
+}
+
+// fundAccount adds an account to the auth and bank state of an sdk v0.40+ genesis.
+func fundAccount(genesis *gabs.Container, account FundedAccount) error {
+	found := false
+	for _, acc := range genesis.Path("app_state.auth.accounts").Children() {
+		for _, path := range []string{"address", "base_account.address", "base_vesting_account.base_account.address"} {
+			if acc.Path(path).Data() == account.Address {
+				found = true
+			}
+		}
+	}
+	if !found {
+		// the chain sets the account number when the genesis is loaded
+		newAccount := map[string]interface{}{
+			"@type":          "/cosmos.auth.v1beta1.BaseAccount",
+			"account_number": "0",
+			"address":        account.Address,
+			"pub_key":        nil,
+			"sequence":       "0",
+		}
+		if err := genesis.ArrayAppend(newAccount, "app_state", "auth", "accounts"); err != nil {
+			return err
+		}
+	}
+
+	found = false
+	for _, balance := range genesis.Path("app_state.bank.balances").Children() {
+		if balance.Path("address").Data() != account.Address {
+			continue
+		}
+		if err := addCoins(balance, account.Coins, "coins"); err != nil {
+			return err
+		}
+		found = true
+	}
+	if !found {
+		newBalance := map[string]interface{}{
+			"address": account.Address,
+			"coins":   coinsJSON(account.Coins),
+		}
+		if err := genesis.ArrayAppend(newBalance, "app_state", "bank", "balances"); err != nil {
+			return err
+		}
+	}
+	return addSupply(genesis, account.Coins, "app_state", "bank", "supply")
+}
+
+// fundLegacyAccount adds an account with coins to the auth state of a pre sdk v0.40 genesis.
+func fundLegacyAccount(genesis *gabs.Container, account FundedAccount) error {
+	accounts := genesis.Path("app_state.auth.accounts").Children()
+	for _, acc := range accounts {
+		if acc.Path("value.address").Data() == account.Address {
+			if err := addCoins(acc, account.Coins, "value", "coins"); err != nil {
+				return err
+			}
+			return addSupply(genesis, account.Coins, "app_state", "supply", "supply")
+		}
+	}
+
+	// match the number encoding of the existing accounts, some versions use strings
+	var zero interface{} = "0"
+	if len(accounts) > 0 {
+		if _, isString := accounts[0].Path("value.account_number").Data().(string); !isString {
+			zero = 0
+		}
+	}
+	newAccount := map[string]interface{}{
+		"type": "cosmos-sdk/Account",
+		"value": map[string]interface{}{
+			"address":        account.Address,
+			"coins":          coinsJSON(account.Coins),
+			"public_key":     nil,
+			"account_number": zero,
+			"sequence":       zero,
+		},
+	}
+	if err := genesis.ArrayAppend(newAccount, "app_state", "auth", "accounts"); err != nil {
+		return err
+	}
+	return addSupply(genesis, account.Coins, "app_state", "supply", "supply")
+}
+
+// addSupply increases the total supply. An empty supply is left as is as the chain calculates it from the balances.
+func addSupply(genesis *gabs.Container, coins sdk.Coins, path ...string) error {
+	if len(genesis.Search(path...).Children()) == 0 {
+		return nil
+	}
+	return addCoins(genesis, coins, path...)
+}
+
+// addCoins adds coins to a json list of coins.
+func addCoins(container *gabs.Container, coins sdk.Coins, path ...string) error {
+	amounts := map[string]sdk.Int{}
+	for _, c := range container.Search(path...).Children() {
+		denom, _ := c.Path("denom").Data().(string)
+		amount, ok := sdk.NewIntFromString(fmt.Sprint(c.Path("amount").Data()))
+		if !ok {
+			return fmt.Errorf("could not parse %s amount %v", denom, c.Path("amount").Data())
+		}
+		amounts[denom] = amount
+	}
+	for _, c := range coins {
+		if existing, found := amounts[c.Denom]; found {
+			amounts[c.Denom] = existing.Add(c.Amount)
+		} else {
+			amounts[c.Denom] = c.Amount
+		}
+	}
+	var total sdk.Coins
+	for denom, amount := range amounts {
+		// build the coins directly, existing denoms may not pass this sdk version's validation
+		total = append(total, sdk.Coin{Denom: denom, Amount: amount})
+	}
+	_, err := container.Set(coinsJSON(total), path...)
+	return err
+}
+
+// coinsJSON converts coins to their genesis representation, sorted by denom.
+func coinsJSON(coins sdk.Coins) []interface{} {
+	sorted := append(sdk.Coins{}, coins...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Denom < sorted[j].Denom })
+	list := []interface{}{}
+	for _, c := range sorted {
+		list = append(list, map[string]interface{}{"denom": c.Denom, "amount": c.Amount.String()})
+	}
+	return list
+}
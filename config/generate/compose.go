@@ -0,0 +1,309 @@
+package generate
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Jeffail/gabs/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// setHostPorts changes the host side of published ports. The overrides are keyed by container port.
+// It errors if a container port isn't published by any of the compose services.
+func setHostPorts(compose *gabs.Container, overrides map[string]string) error {
+	found := map[string]bool{}
+	for _, service := range compose.Search("services").ChildrenMap() {
+		ports, ok := service.Search("ports").Data().([]interface{})
+		if !ok {
+			continue
+		}
+		for i, p := range ports {
+			mapping, ok := p.(string)
+			if !ok {
+				continue
+			}
+			host, container := splitPortMapping(mapping)
+			hostPort, ok := overrides[container]
+			if !ok {
+				continue
+			}
+			found[container] = true
+			ports[i] = strings.TrimSuffix(mapping, host+":"+container) + hostPort + ":" + container
+		}
+	}
+	for container := range overrides {
+		if !found[container] {
+			return fmt.Errorf("container port %s is not published by any service", container)
+		}
+	}
+	return nil
+}
+
+// splitPortMapping splits a compose port mapping such as "127.0.0.1:1317:1317" into its host and container ports.
+func splitPortMapping(mapping string) (host, container string) {
+	parts := strings.Split(mapping, ":")
+	if len(parts) < 2 {
+		return "", mapping
+	}
+	return parts[len(parts)-2], parts[len(parts)-1]
+}
+
+// setEnvironment adds environment variables to every service in a docker-compose file, overwriting existing values.
+func setEnvironment(compose *gabs.Container, env map[string]string) error {
+	if len(env) == 0 {
+		return nil
+	}
+	var keys []string
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for name, service := range compose.Search("services").ChildrenMap() {
+		switch existing := service.Search("environment").Data().(type) {
+		case nil:
+			for _, k := range keys {
+				if _, err := service.Set(env[k], "environment", k); err != nil {
+					return err
+				}
+			}
+		case map[string]interface{}:
+			for _, k := range keys {
+				existing[k] = env[k]
+			}
+		case []interface{}:
+			// environment can also be specified as a list of KEY=VALUE strings
+			for _, k := range keys {
+				existing = removeEnvEntry(existing, k)
+				existing = append(existing, k+"="+env[k])
+			}
+			if _, err := service.Set(existing, "environment"); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported environment format for service %s", name)
+		}
+	}
+	return nil
+}
+
+func removeEnvEntry(entries []interface{}, key string) []interface{} {
+	var kept []interface{}
+	for _, e := range entries {
+		if s, ok := e.(string); ok && (s == key || strings.HasPrefix(s, key+"=")) {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	return kept
+}
+
+// setImage changes the image run by every service in a docker-compose file.
+func setImage(compose *gabs.Container, image string) error {
+	for _, service := range compose.Search("services").ChildrenMap() {
+		if _, err := service.Set(image, "image"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renameComposeService renames a service in a compose file's data and yaml node, so the node's comments stay with the service.
+func renameComposeService(compose *gabs.Container, node *yaml.Node, from, to string) error {
+	service := compose.Search("services", from)
+	if service.Data() == nil {
+		return fmt.Errorf("no %s service to rename", from)
+	}
+	if err := compose.Delete("services", from); err != nil {
+		return err
+	}
+	if _, err := compose.Set(service.Data(), "services", to); err != nil {
+		return err
+	}
+	if services := mappingValue(node, "services"); services != nil {
+		for i := 0; i+1 < len((*services).Content); i += 2 {
+			if key := (*services).Content[i]; key.Value == from {
+				key.Value = to
+			}
+		}
+	}
+	return nil
+}
+
+// imageOverridesKey is a top level field in generated compose files that records the images of services run outside of
+// docker-compose, such as the relayer which only runs in hooks. It's an extension field, which docker-compose ignores
+// from compose file version 3.4.
+const imageOverridesKey = "x-kvtool-images"
+
+// recordImageOverride saves the image a service should run for services that aren't in the compose file.
+// The compose file's version is raised to allow the extension field the image is recorded in.
+func recordImageOverride(generatedConfigDir, serviceName, image string) error {
+	composeFile := filepath.Join(generatedConfigDir, "docker-compose.yaml")
+	override := gabs.New()
+	if _, err := override.Set(image, imageOverridesKey, serviceName); err != nil {
+		return err
+	}
+	if err := mergeCompose(syncNode(nil, override.Data()), "the image override for "+serviceName, composeFile); err != nil {
+		return err
+	}
+	compose, err := importYAML(composeFile)
+	if err != nil {
+		return err
+	}
+	if err := requireExtensionFields(compose); err != nil {
+		return err
+	}
+	return exportYAML(composeFile, compose)
+}
+
+// ImageOverride returns the image a service was configured to run with when the config was generated.
+// It's empty if the service uses its default image.
+func ImageOverride(generatedConfigDir, serviceName string) (string, error) {
+	compose, err := importYAML(filepath.Join(generatedConfigDir, "docker-compose.yaml"))
+	if err != nil {
+		return "", err
+	}
+	image, _ := compose.Search(imageOverridesKey, serviceName).Data().(string)
+	return image, nil
+}
+
+var projectNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ValidateProjectName checks a name can be used as a docker-compose project name.
+func ValidateProjectName(name string) error {
+	if !projectNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid project name '%s', must be lowercase letters, numbers, dashes and underscores", name)
+	}
+	return nil
+}
+
+// projectNameField is where kvtool records the project name in a generated docker-compose.yaml. It's an extension
+// field because docker-compose v1 rejects the top level name of the newer compose spec.
+const projectNameField = "x-kvtool-project-name"
+
+// requireExtensionFields raises the version of a version 3 compose file to 3.4, the first to allow extension fields.
+func requireExtensionFields(compose *gabs.Container) error {
+	version, _ := compose.Search("version").Data().(string)
+	parts := strings.SplitN(version, ".", 2)
+	if parts[0] != "3" {
+		return nil
+	}
+	if len(parts) == 2 {
+		minor, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return fmt.Errorf("invalid compose file version '%s'", version)
+		}
+		if minor >= 4 {
+			return nil
+		}
+	}
+	_, err := compose.Set("3.4", "version")
+	return err
+}
+
+// ConfigureProject sets the compose project name of a generated config and shifts its published host ports.
+// This allows several testnets to run at once without their containers or ports clashing.
+// An empty name leaves the project named after the generated config folder.
+func ConfigureProject(generatedConfigDir, projectName string, portOffset int) error {
+	if projectName == "" && portOffset == 0 {
+		return nil
+	}
+	composeFile := filepath.Join(generatedConfigDir, "docker-compose.yaml")
+	compose, err := importYAML(composeFile)
+	if err != nil {
+		return err
+	}
+	if projectName != "" {
+		if err := ValidateProjectName(projectName); err != nil {
+			return err
+		}
+		if _, err := compose.Set(projectName, projectNameField); err != nil {
+			return err
+		}
+		if err := requireExtensionFields(compose); err != nil {
+			return err
+		}
+	}
+	if err := offsetHostPorts(compose, portOffset); err != nil {
+		return err
+	}
+	return exportYAML(composeFile, compose)
+}
+
+// offsetHostPorts adds an offset to the host side of every published port in a docker-compose file.
+func offsetHostPorts(compose *gabs.Container, offset int) error {
+	if offset == 0 {
+		return nil
+	}
+	for name, service := range compose.Search("services").ChildrenMap() {
+		ports, ok := service.Search("ports").Data().([]interface{})
+		if !ok {
+			continue
+		}
+		for i, p := range ports {
+			mapping := fmt.Sprint(p)
+			host, container := splitPortMapping(mapping)
+			if host == "" {
+				// the port isn't published on a fixed host port
+				continue
+			}
+			hostPort, err := strconv.Atoi(host)
+			if err != nil {
+				return fmt.Errorf("can't offset host port '%s' of service %s: %w", host, name, err)
+			}
+			hostPort += offset
+			if hostPort < 1 || hostPort > 65535 {
+				return fmt.Errorf("offset host port %d of service %s is out of range", hostPort, name)
+			}
+			ports[i] = strings.TrimSuffix(mapping, host+":"+container) + strconv.Itoa(hostPort) + ":" + container
+		}
+	}
+	return nil
+}
+
+// MageImageRepository is the docker repository of the images the mage templates run.
+const MageImageRepository = "mage/mage"
+
+// UseLocalMageBuild points the mage services in a generated config at a locally built image, returning the services changed.
+// The build context is added too so the image can be rebuilt with `docker-compose build`.
+// Only services from the mage template are changed, other chains that run a mage image, such as the ibc chain, keep their version.
+func UseLocalMageBuild(generatedConfigDir, image, contextDir string) ([]string, error) {
+	return updateMageServices(generatedConfigDir, map[string]interface{}{"image": image, "build": contextDir})
+}
+
+// SetMageImage changes the image of the mage services in a generated config, returning the services changed.
+// Like UseLocalMageBuild, other chains that run a mage image keep their version.
+func SetMageImage(generatedConfigDir, image string) ([]string, error) {
+	return updateMageServices(generatedConfigDir, map[string]interface{}{"image": image})
+}
+
+// updateMageServices sets fields of the services from the mage template that run a mage image.
+func updateMageServices(generatedConfigDir string, fields map[string]interface{}) ([]string, error) {
+	composeFile := filepath.Join(generatedConfigDir, "docker-compose.yaml")
+	compose, err := importYAML(composeFile)
+	if err != nil {
+		return nil, err
+	}
+	var changed []string
+	for name, service := range compose.Search("services").ChildrenMap() {
+		current, _ := service.Search("image").Data().(string)
+		if !strings.HasPrefix(name, MageServiceName) || !strings.HasPrefix(current, MageImageRepository+":") {
+			continue
+		}
+		for field, value := range fields {
+			if _, err := service.Set(value, field); err != nil {
+				return nil, err
+			}
+		}
+		changed = append(changed, name)
+	}
+	if len(changed) == 0 {
+		return nil, fmt.Errorf("no services running a %s image found in the generated config", MageImageRepository)
+	}
+	sort.Strings(changed)
+	return changed, exportYAML(composeFile, compose)
+}
@@ -0,0 +1,114 @@
+package generate
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Jeffail/gabs/v2"
+)
+
+// kinds of endpoint exposed by testnet services
+const (
+	RPCEndpoint  = "rpc"
+	RESTEndpoint = "rest"
+	GRPCEndpoint = "grpc"
+	EVMEndpoint  = "evm"
+)
+
+// knownEndpoints lists the container ports of the endpoints the service templates expose, by compose service.
+var knownEndpoints = []struct {
+	composeService string
+	containerPort  string
+	kind           string
+}{
+	{"magenode", "26657", RPCEndpoint},
+	{"magenode", "1317", RESTEndpoint},
+	{"magerest", "1317", RESTEndpoint},
+	{"magenode", "9090", GRPCEndpoint},
+	{"magenode", "8545", EVMEndpoint},
+	{"ibcnode", "26658", RPCEndpoint},
+	{"ibcnode", "1318", RESTEndpoint},
+	{"ibcnode", "9092", GRPCEndpoint},
+	{"gethnode", "8545", EVMEndpoint},
+}
+
+// Endpoint is a service endpoint published on the host.
+type Endpoint struct {
+	ComposeService string
+	Kind           string
+	// Address is the host and port the endpoint is published on. eg localhost:26657
+	Address string
+}
+
+// URL returns the http address of the endpoint.
+func (e Endpoint) URL() string {
+	return "http://" + e.Address
+}
+
+// Endpoints lists the known endpoints published by the services in a generated config.
+func Endpoints(generatedConfigDir string) ([]Endpoint, error) {
+	compose, err := importYAML(filepath.Join(generatedConfigDir, "docker-compose.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("could not read generated config: %w", err)
+	}
+	var serviceNames []string
+	for name := range compose.Search("services").ChildrenMap() {
+		serviceNames = append(serviceNames, name)
+	}
+	sort.Strings(serviceNames)
+	var endpoints []Endpoint
+	for _, known := range knownEndpoints {
+		for _, service := range serviceNames {
+			if !isServiceCopy(service, known.composeService) {
+				continue
+			}
+			endpoints = append(endpoints, publishedEndpoints(compose, service, known.containerPort, known.kind)...)
+		}
+	}
+	return endpoints, nil
+}
+
+// isServiceCopy reports if a compose service is the named service or a numbered copy of it, eg ibcnode-1.
+func isServiceCopy(service, name string) bool {
+	if service == name {
+		return true
+	}
+	n := strings.TrimPrefix(service, name+"-")
+	if n == service || n == "" {
+		return false
+	}
+	_, err := strconv.Atoi(n)
+	return err == nil
+}
+
+// publishedEndpoints returns the host addresses a container port of a service is published on.
+func publishedEndpoints(compose *gabs.Container, service, containerPort, kind string) []Endpoint {
+	ports, ok := compose.Search("services", service, "ports").Data().([]interface{})
+	if !ok {
+		return nil
+	}
+	var endpoints []Endpoint
+	for _, p := range ports {
+		mapping, ok := p.(string)
+		if !ok {
+			continue
+		}
+		host, container := splitPortMapping(mapping)
+		if container != containerPort || host == "" {
+			continue
+		}
+		hostIP := "localhost"
+		if parts := strings.Split(mapping, ":"); len(parts) == 3 && parts[0] != "0.0.0.0" {
+			hostIP = parts[0]
+		}
+		endpoints = append(endpoints, Endpoint{
+			ComposeService: service,
+			Kind:           kind,
+			Address:        hostIP + ":" + host,
+		})
+	}
+	return endpoints
+}
@@ -1,118 +1,274 @@
 package generate
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 
 	"github.com/otiai10/copy"
+
+	"github.com/furya-official/mgtool/config"
 )
 
 var (
 	// ConfigTemplatesDir is the absolute path to the config templates directory.
-	// It's set at build time using an -X flag. eg -ldflags "-X github.com/furya-official/mgtool/config/generate.ConfigTemplatesDir=/home/user1/kvtool/config/templates"
+	// It can be set at build time using an -X flag. eg -ldflags "-X github.com/furya-official/mgtool/config/generate.ConfigTemplatesDir=/home/user1/kvtool/config/templates"
+	// The TemplatesDirEnv environment variable takes precedence. If neither is set, ExtractTemplates sets it to the templates
+	// embedded in the binary, written out to the user's cache folder.
 	ConfigTemplatesDir string
+
+	// usingEmbeddedTemplates is set when ConfigTemplatesDir points to the extracted embedded templates.
+	usingEmbeddedTemplates bool
+)
+
+// TemplatesDirEnv is the environment variable for using an external templates directory, eg a modified copy of config/templates.
+const TemplatesDirEnv = "KVTOOL_TEMPLATES_DIR"
+
+// names of the built in services
+const (
+	MageServiceName    = "mage"
+	BinanceServiceName = "binance"
+	DeputyServiceName  = "deputy"
+	IbcServiceName     = "ibc"
+	GethServiceName    = "geth"
+	OracleServiceName  = "oracle"
+	HermesServiceName  = "hermes"
+	RelayerServiceName = "relayer"
 )
 
+func init() {
+	if dir := os.Getenv(TemplatesDirEnv); dir != "" {
+		ConfigTemplatesDir = dir
+	}
+
+	RegisterService(TemplateService{
+		ServiceName: MageServiceName,
+		Dir:         "mage",
+		Default:     "master",
+	})
+	RegisterService(TemplateService{
+		ServiceName: BinanceServiceName,
+		Dir:         "binance",
+		Default:     "v0.8",
+	})
+	RegisterService(TemplateService{
+		ServiceName: DeputyServiceName,
+		Dir:         "deputy",
+		Requires:    []string{MageServiceName, BinanceServiceName},
+	})
+	RegisterService(TemplateService{
+		ServiceName: IbcServiceName,
+		Dir:         "ibcchain",
+		Default:     "master",
+		Requires:    []string{MageServiceName},
+	})
+	RegisterService(TemplateService{
+		ServiceName: GethServiceName,
+		Dir:         "geth",
+	})
+	RegisterService(TemplateService{
+		ServiceName: OracleServiceName,
+		Dir:         "oracle",
+		Requires:    []string{MageServiceName},
+	})
+	// the relayers only join the network once the chains are running and the channels have been opened,
+	// only one of them should be included as they'd both open the channels
+	RegisterService(TemplateService{
+		ServiceName: RelayerServiceName,
+		Dir:         "relayer",
+		Requires:    []string{IbcServiceName},
+		SkipCompose: true,
+		Hooks:       []PostStartHook{AddGoRelayerToNetwork},
+	})
+	RegisterService(TemplateService{
+		ServiceName: HermesServiceName,
+		Dir:         "hermes",
+		Requires:    []string{IbcServiceName},
+		SkipCompose: true,
+		Hooks:       []PostStartHook{AddHermesRelayerToNetwork},
+	})
+}
+
 func GenerateDefaultConfig(generatedConfigDir string) error {
-	if err := GenerateMageConfig("v0.10", generatedConfigDir); err != nil {
+	return GenerateServices(
+		[]ServiceConfig{
+			{Name: MageServiceName, Version: "v0.10"},
+			{Name: BinanceServiceName},
+			{Name: DeputyServiceName},
+		},
+		generatedConfigDir,
+	)
+}
+
+// AddGoRelayerToNetwork adds a go relayer service to the generated compose file for each of the relayer's paths.
+// The services are named relayer-<path>, eg relayer-magenode-ibcnode-transfer.
+func AddGoRelayerToNetwork(generatedConfigDir string) error {
+	paths, err := IbcPaths(generatedConfigDir)
+	if err != nil {
 		return err
 	}
-	if err := GenerateBnbConfig(generatedConfigDir); err != nil {
+	image, err := ImageOverride(generatedConfigDir, RelayerServiceName)
+	if err != nil {
 		return err
 	}
-	if err := GenerateDeputyConfig(generatedConfigDir); err != nil {
-		return err
+	templateFile := filepath.Join(ConfigTemplatesDir, "relayer", "docker-compose.yaml")
+	for _, path := range paths {
+		compose, err := importYAML(templateFile)
+		if err != nil {
+			return err
+		}
+		templateNode, err := importYAMLNode(templateFile)
+		if err != nil {
+			return err
+		}
+		serviceName := RelayerServiceName + "-" + path.Name
+		if err := renameComposeService(compose, templateNode, RelayerServiceName, serviceName); err != nil {
+			return err
+		}
+		if _, err := compose.Set([]interface{}{"start", path.Name}, "services", serviceName, "command"); err != nil {
+			return err
+		}
+		if image != "" {
+			if err := setImage(compose, image); err != nil {
+				return err
+			}
+		}
+		if err := mergeCompose(syncNode(templateNode, compose.Data()), "relayer for "+path.Name, filepath.Join(generatedConfigDir, "docker-compose.yaml")); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func GenerateMageConfig(mageConfigTemplate, generatedConfigDir string) error {
-	// copy templates into generated config folder
-	err := copy.Copy(filepath.Join(ConfigTemplatesDir, "mage", mageConfigTemplate), filepath.Join(generatedConfigDir, "mage"))
+// AddHermesRelayerToNetwork adds the hermes service to the generated compose file.
+func AddHermesRelayerToNetwork(generatedConfigDir string) error {
+	templateFile := filepath.Join(ConfigTemplatesDir, "hermes", "docker-compose.yaml")
+	compose, err := importYAML(templateFile)
 	if err != nil {
 		return err
 	}
-
-	// put together final compose file
-	err = overwriteMergeYAML(
-		filepath.Join(ConfigTemplatesDir, "mage", mageConfigTemplate, "docker-compose.yaml"),
-		filepath.Join(generatedConfigDir, "docker-compose.yaml"),
-	)
-	return err
-}
-
-func GenerateBnbConfig(generatedConfigDir string) error {
-	// copy templates into generated config folder
-	err := copy.Copy(filepath.Join(ConfigTemplatesDir, "binance/v0.8"), filepath.Join(generatedConfigDir, "binance"))
+	templateNode, err := importYAMLNode(templateFile)
 	if err != nil {
 		return err
 	}
-
-	// put together final compose file
-	err = overwriteMergeYAML(
-		filepath.Join(ConfigTemplatesDir, "binance/v0.8/docker-compose.yaml"),
-		filepath.Join(generatedConfigDir, "docker-compose.yaml"),
-	)
-	return err
-}
-
-func GenerateDeputyConfig(generatedConfigDir string) error {
-	// copy templates into generated config folder
-	err := copy.Copy(filepath.Join(ConfigTemplatesDir, "deputy"), filepath.Join(generatedConfigDir, "deputy"))
+	image, err := ImageOverride(generatedConfigDir, HermesServiceName)
 	if err != nil {
 		return err
 	}
-
-	// put together final compose file
-	err = overwriteMergeYAML(
-		filepath.Join(ConfigTemplatesDir, "deputy/docker-compose.yaml"),
-		filepath.Join(generatedConfigDir, "docker-compose.yaml"),
-	)
-	return err
+	if image != "" {
+		if err := setImage(compose, image); err != nil {
+			return err
+		}
+	}
+	return mergeCompose(syncNode(templateNode, compose.Data()), "hermes", filepath.Join(generatedConfigDir, "docker-compose.yaml"))
 }
 
-func GenerateIbcChainConfig(generatedConfigDir string) error {
+// generateFromTemplate copies a template directory into the generated config folder, under outputName,
+// then merges the template's compose file (with any overrides applied) into the final compose file.
+func generateFromTemplate(templatePath, outputName, generatedConfigDir string, overrides ServiceConfig) error {
 	// copy templates into generated config folder
-	err := copy.Copy(filepath.Join(ConfigTemplatesDir, "ibcchain", "master"), filepath.Join(generatedConfigDir, "ibcchain"))
+	err := copy.Copy(filepath.Join(ConfigTemplatesDir, templatePath), filepath.Join(generatedConfigDir, outputName))
 	if err != nil {
 		return err
 	}
 
-	// put together final compose file
-	err = overwriteMergeYAML(
-		filepath.Join(ConfigTemplatesDir, "ibcchain", "master", "docker-compose.yaml"),
-		filepath.Join(generatedConfigDir, "docker-compose.yaml"),
-	)
-	return err
-}
-
-func GenerateGethConfig(generatedConfigDir string) error {
-	// copy templates into generated config folder
-	err := copy.Copy(filepath.Join(ConfigTemplatesDir, "geth"), filepath.Join(generatedConfigDir, "geth"))
+	templateFile := filepath.Join(ConfigTemplatesDir, templatePath, "docker-compose.yaml")
+	compose, err := importYAML(templateFile)
+	if err != nil {
+		return err
+	}
+	templateNode, err := importYAMLNode(templateFile)
 	if err != nil {
 		return err
 	}
+	if err := setHostPorts(compose, overrides.Ports); err != nil {
+		return err
+	}
+	if err := setEnvironment(compose, overrides.Env); err != nil {
+		return err
+	}
+	if overrides.Image != "" {
+		if err := setImage(compose, overrides.Image); err != nil {
+			return err
+		}
+	}
 
-	// put together final compose file
-	err = overwriteMergeYAML(
-		filepath.Join(ConfigTemplatesDir, "geth", "docker-compose.yaml"),
-		filepath.Join(generatedConfigDir, "docker-compose.yaml"),
-	)
-	return err
+	// put together final compose file, keeping the template's comments on the parts the overrides didn't change
+	return mergeCompose(syncNode(templateNode, compose.Data()), filepath.ToSlash(templatePath), filepath.Join(generatedConfigDir, "docker-compose.yaml"))
 }
 
-func GenerateHermesRelayerConfig(generatedConfigDir string) error {
-	err := copy.Copy(filepath.Join(ConfigTemplatesDir, "hermes"), filepath.Join(generatedConfigDir, "hermes"))
-	return err
+// DefaultGeneratedConfigDir is where config is generated unless another folder is given, two levels above the templates.
+// For the embedded templates that's the kvtool folder in the user's cache, which doesn't depend on the templates' hash.
+func DefaultGeneratedConfigDir() string {
+	if ConfigTemplatesDir != "" {
+		return filepath.Join(ConfigTemplatesDir, "../..", "full_configs", "generated")
+	}
+	return filepath.Join(userCacheDir(), "kvtool", "full_configs", "generated")
 }
 
-func AddHermesRelayerToNetwork(generatedConfigDir string) error {
-	return overwriteMergeYAML(
-		filepath.Join(ConfigTemplatesDir, "hermes", "docker-compose.yaml"),
-		filepath.Join(generatedConfigDir, "docker-compose.yaml"),
-	)
+// userCacheDir returns the folder the embedded templates are extracted into, falling back to the temp folder.
+func userCacheDir() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return os.TempDir()
+	}
+	return cacheDir
+}
+
+// embeddedTemplatesDir returns where the embedded templates are extracted to.
+// The folder is named after the templates' hash so different kvtool versions don't overwrite each other's templates.
+func embeddedTemplatesDir() (string, error) {
+	hash, err := config.Hash()
+	if err != nil {
+		return "", fmt.Errorf("could not hash embedded templates: %w", err)
+	}
+	return filepath.Join(userCacheDir(), "kvtool", "templates-"+hash[:12], "templates"), nil
 }
 
-func GenerateGoRelayerConfig(generatedConfigDir string) error {
-	err := copy.Copy(filepath.Join(ConfigTemplatesDir, "relayer"), filepath.Join(generatedConfigDir, "relayer"))
-	return err
+// ExtractTemplates points ConfigTemplatesDir at the embedded templates if no other templates folder was set,
+// writing them out if they haven't been already. The common folder the templates refer to is written next to it.
+// The embedded files are only hashed here, so commands that don't use the templates don't pay for it.
+func ExtractTemplates() error {
+	if ConfigTemplatesDir == "" {
+		dir, err := embeddedTemplatesDir()
+		if err != nil {
+			return err
+		}
+		ConfigTemplatesDir = dir
+		usingEmbeddedTemplates = true
+	}
+	if !usingEmbeddedTemplates {
+		return nil
+	}
+	root := filepath.Dir(ConfigTemplatesDir)
+	if _, err := os.Stat(root); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(root), os.ModePerm); err != nil {
+		return err
+	}
+	// extract to a temporary folder so an interrupted extraction isn't mistaken for a complete one
+	tempDir, err := ioutil.TempDir(filepath.Dir(root), ".extract-")
+	if err != nil {
+		return err
+	}
+	// TempDir creates the folder only readable by the user, let containers running as other users read the templates
+	if err := os.Chmod(tempDir, 0755); err != nil {
+		os.RemoveAll(tempDir)
+		return err
+	}
+	if err := config.Extract(tempDir); err != nil {
+		os.RemoveAll(tempDir)
+		return fmt.Errorf("could not extract templates: %w", err)
+	}
+	if err := os.Rename(tempDir, root); err != nil {
+		os.RemoveAll(tempDir)
+		// another kvtool process may have extracted them first
+		if _, statErr := os.Stat(root); statErr == nil {
+			return nil
+		}
+		return fmt.Errorf("could not extract templates: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,57 @@
+package generate
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestExtractTemplates(t *testing.T) {
+	if ConfigTemplatesDir != "" {
+		t.Skipf("%s is set, the embedded templates aren't used", TemplatesDirEnv)
+	}
+	if runtime.GOOS != "linux" {
+		t.Skip("the cache folder is only moved with XDG_CACHE_HOME on linux")
+	}
+	cacheDir := t.TempDir()
+	oldCacheHome, hadCacheHome := os.LookupEnv("XDG_CACHE_HOME")
+	os.Setenv("XDG_CACHE_HOME", cacheDir)
+	t.Cleanup(func() {
+		if hadCacheHome {
+			os.Setenv("XDG_CACHE_HOME", oldCacheHome)
+		} else {
+			os.Unsetenv("XDG_CACHE_HOME")
+		}
+		ConfigTemplatesDir = ""
+		usingEmbeddedTemplates = false
+	})
+
+	// the generated config folder doesn't need the templates to be hashed
+	if expected := filepath.Join(cacheDir, "kvtool", "full_configs", "generated"); DefaultGeneratedConfigDir() != expected {
+		t.Fatalf("expected the default generated dir %s, got %s", expected, DefaultGeneratedConfigDir())
+	}
+	if err := ExtractTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(ConfigTemplatesDir, filepath.Join(cacheDir, "kvtool", "templates-")) {
+		t.Fatalf("expected the templates in the cache dir, got %s", ConfigTemplatesDir)
+	}
+	for _, file := range []string{
+		filepath.Join(ConfigTemplatesDir, "mage", "master", "docker-compose.yaml"),
+		filepath.Join(ConfigTemplatesDir, "..", "common", "addresses.yaml"),
+	} {
+		if _, err := os.Stat(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// extracting again reuses the templates
+	dir := ConfigTemplatesDir
+	if err := ExtractTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	if ConfigTemplatesDir != dir {
+		t.Fatalf("expected the templates dir to stay %s, got %s", dir, ConfigTemplatesDir)
+	}
+}
@@ -0,0 +1,342 @@
+package generate
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jeffail/gabs/v2"
+	"github.com/tendermint/tendermint/libs/bech32"
+)
+
+// ValidatorKey is the public part of a tendermint priv_validator_key.json file.
+type ValidatorKey struct {
+	// Address is the hex encoded consensus address.
+	Address string `json:"address"`
+	PubKey  struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	} `json:"pub_key"`
+}
+
+// LoadValidatorKey reads a priv_validator_key.json file.
+func LoadValidatorKey(filename string) (ValidatorKey, error) {
+	var key ValidatorKey
+	bz, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return key, err
+	}
+	if err := json.Unmarshal(bz, &key); err != nil {
+		return key, fmt.Errorf("could not parse validator key %s: %w", filename, err)
+	}
+	return key, nil
+}
+
+// GenesisImport configures restoring a testnet's chain from an exported genesis.
+type GenesisImport struct {
+	// ExportFile is the exported genesis, eg from `testnet export`.
+	ExportFile string
+	// ChainID for the new chain. Defaults to the chain id of the template's genesis.
+	ChainID string
+	// MinPowerPercent is the minimum share of voting power given to the template's validators, between 0 and 1.
+	// It must be more than 2/3 for the new chain to produce blocks without the exported chain's other validators.
+	MinPowerPercent float64
+}
+
+// ImportGenesis replaces the genesis.json of a generated config's mage node with an exported one.
+// The exported chain's highest power validators are replaced with the validators in the template, so the testnet can produce blocks.
+func ImportGenesis(config GenesisImport, generatedConfigDir string) error {
+	if config.MinPowerPercent >= 1 || config.MinPowerPercent < 0 {
+		return fmt.Errorf("minimum power is a percent. out of range: 0 <= power < 1")
+	}
+	genesisFile, err := findGenesisFile(filepath.Join(generatedConfigDir, "mage", "initstate"))
+	if err != nil {
+		return err
+	}
+	template, err := readJSON(genesisFile)
+	if err != nil {
+		return err
+	}
+	exported, err := readJSON(config.ExportFile)
+	if err != nil {
+		return err
+	}
+	if height := jsonInt(exported.Path("initial_height").Data()); height <= 1 {
+		return fmt.Errorf("expected an exported genesis file for height > 1, found initial height %d", height)
+	}
+	key, err := LoadValidatorKey(filepath.Join(filepath.Dir(genesisFile), "priv_validator_key.json"))
+	if err != nil {
+		return err
+	}
+
+	chainID := config.ChainID
+	if chainID == "" {
+		chainID, _ = template.Path("chain_id").Data().(string)
+	}
+	if chainID == exported.Path("chain_id").Data() {
+		fmt.Println("WARNING: the imported genesis has the same chain id as the exported one, this can put the exported chain at risk of replay attacks.")
+	}
+	if _, err := exported.Set(chainID, "chain_id"); err != nil {
+		return err
+	}
+	// the genesis time must be updated to avoid a consensus error, see https://github.com/tendermint/tendermint/issues/8773
+	if _, err := exported.Set(time.Now().UTC().Format(time.RFC3339Nano), "genesis_time"); err != nil {
+		return err
+	}
+	if err := replaceGenesisValidators(exported, []ValidatorKey{key}, config.MinPowerPercent); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(genesisFile, exported.BytesIndent("", "  "), 0644)
+}
+
+// MageGenesisFile returns the path of the mage node's genesis.json in a generated config.
+func MageGenesisFile(generatedConfigDir string) (string, error) {
+	return findGenesisFile(filepath.Join(generatedConfigDir, "mage", "initstate"))
+}
+
+// findGenesisFile finds the genesis.json in a node's initstate folder, eg initstate/.mage/config/genesis.json.
+func findGenesisFile(initStateDir string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(initStateDir, ".*", "config", "genesis.json"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) != 1 {
+		return "", fmt.Errorf("expected one genesis.json in %s, found %d", initStateDir, len(matches))
+	}
+	return matches[0], nil
+}
+
+// replaceGenesisValidators swaps the consensus keys of the highest power validators in a genesis for the provided keys.
+// It updates the same state as the update-genesis-validators tool in contrib, but works on the json directly so it's not tied to an sdk version.
+func replaceGenesisValidators(genesis *gabs.Container, keys []ValidatorKey, minPowerPercent float64) error {
+	validators := genesis.Path("validators").Children()
+	if len(validators) < len(keys) {
+		fmt.Printf("warning: more validator keys provided than original validators, only replacing %d\n", len(validators))
+	}
+	numReplace := len(validators)
+	if len(keys) < numReplace {
+		numReplace = len(keys)
+	}
+	if numReplace == 0 {
+		return fmt.Errorf("no validators found in genesis")
+	}
+	sort.SliceStable(validators, func(i, j int) bool {
+		return jsonInt(validators[i].Path("power").Data()) > jsonInt(validators[j].Path("power").Data())
+	})
+
+	prefix, err := consAddressPrefix(genesis)
+	if err != nil {
+		return err
+	}
+
+	// map of original valcons address -> new validator key
+	replacements := make(map[string]ValidatorKey, numReplace)
+	initialValPower := big.NewInt(0)
+	for i := 0; i < numReplace; i++ {
+		origAddress, ok := validators[i].Path("address").Data().(string)
+		if !ok {
+			return fmt.Errorf("validator %d has no address", i)
+		}
+		orig, err := consAddress(prefix, origAddress)
+		if err != nil {
+			return err
+		}
+		replacement, err := consAddress(prefix, keys[i].Address)
+		if err != nil {
+			return err
+		}
+		replacements[orig] = keys[i]
+		fmt.Printf("replacing \"%v\"\n  %s -> %s\n", validators[i].Path("name").Data(), orig, replacement)
+
+		if _, err := validators[i].Set(keys[i].Address, "address"); err != nil {
+			return err
+		}
+		if _, err := validators[i].Set(map[string]interface{}{"type": keys[i].PubKey.Type, "value": keys[i].PubKey.Value}, "pub_key"); err != nil {
+			return err
+		}
+		initialValPower.Add(initialValPower, big.NewInt(jsonInt(validators[i].Path("power").Data())))
+	}
+
+	// STAKING STATE
+	staking := genesis.Path("app_state.staking")
+	lastTotalPower, ok := new(big.Int).SetString(fmt.Sprint(staking.Path("last_total_power").Data()), 10)
+	if !ok {
+		return fmt.Errorf("could not parse app_state.staking.last_total_power")
+	}
+	totalPowerDelta := calcPowerDelta(lastTotalPower, initialValPower, minPowerPercent)
+	powerDelta := new(big.Int).Quo(totalPowerDelta, big.NewInt(int64(numReplace)))
+	fmt.Printf("total power delta: %s; per validator: %s\n", totalPowerDelta, powerDelta)
+
+	// replace the consensus keys of the staking validators, and flag the operators to update the power of
+	replacedOperators := map[string]bool{}
+	for _, val := range staking.Path("validators").Children() {
+		pubKey, ok := val.Path("consensus_pubkey.key").Data().(string)
+		if !ok {
+			return fmt.Errorf("unsupported consensus_pubkey format, only genesis files from cosmos-sdk v0.40 or later can be imported")
+		}
+		bz, err := base64.StdEncoding.DecodeString(pubKey)
+		if err != nil {
+			return fmt.Errorf("could not decode consensus pubkey: %w", err)
+		}
+		hash := sha256.Sum256(bz)
+		orig, err := bech32.ConvertAndEncode(prefix, hash[:20])
+		if err != nil {
+			return err
+		}
+		replacement, found := replacements[orig]
+		if !found {
+			continue
+		}
+		if _, err := val.Set(replacement.PubKey.Value, "consensus_pubkey", "key"); err != nil {
+			return err
+		}
+		replacedOperators[fmt.Sprint(val.Path("operator_address").Data())] = true
+	}
+	for _, valPower := range staking.Path("last_validator_powers").Children() {
+		if !replacedOperators[fmt.Sprint(valPower.Path("address").Data())] {
+			continue
+		}
+		power := jsonInt(valPower.Path("power").Data()) + powerDelta.Int64()
+		if _, err := valPower.Set(strconv.FormatInt(power, 10), "power"); err != nil {
+			return err
+		}
+	}
+	newTotalPower := new(big.Int).Add(lastTotalPower, new(big.Int).Mul(powerDelta, big.NewInt(int64(numReplace))))
+	fmt.Printf("increasing total power: %s -> %s\n", lastTotalPower, newTotalPower)
+	if _, err := staking.Set(newTotalPower.String(), "last_total_power"); err != nil {
+		return err
+	}
+
+	// SLASHING STATE
+	replaceAddress := func(c *gabs.Container, path ...string) error {
+		replacement, found := replacements[fmt.Sprint(c.Search(path...).Data())]
+		if !found {
+			return nil
+		}
+		newAddress, err := consAddress(prefix, replacement.Address)
+		if err != nil {
+			return err
+		}
+		_, err = c.Set(newAddress, path...)
+		return err
+	}
+	for _, mb := range genesis.Path("app_state.slashing.missed_blocks").Children() {
+		if err := replaceAddress(mb, "address"); err != nil {
+			return err
+		}
+	}
+	for _, si := range genesis.Path("app_state.slashing.signing_infos").Children() {
+		if err := replaceAddress(si, "validator_signing_info", "address"); err != nil {
+			return err
+		}
+		if err := replaceAddress(si, "address"); err != nil {
+			return err
+		}
+	}
+
+	// DISTRIBUTION STATE
+	if err := replaceAddress(genesis.Path("app_state.distribution"), "previous_proposer"); err != nil {
+		return err
+	}
+
+	// update the power of the replaced validators, they're still sorted so it's safe to index
+	for i := 0; i < numReplace; i++ {
+		power := jsonInt(validators[i].Path("power").Data()) + powerDelta.Int64()
+		if _, err := validators[i].Set(strconv.FormatInt(power, 10), "power"); err != nil {
+			return err
+		}
+	}
+	sorted := make([]interface{}, len(validators))
+	for i, v := range validators {
+		sorted[i] = v.Data()
+	}
+	_, err = genesis.Set(sorted, "validators")
+	return err
+}
+
+// calcPowerDelta calculates the total power increase that, when given to the replaced validators,
+// adjusts the total power such that the replaced validators control at least the desired percentage.
+func calcPowerDelta(initialTotalPower, initialValPower *big.Int, desiredPercent float64) *big.Int {
+	iTotalPower := new(big.Float).SetInt(initialTotalPower)
+	iValPower := new(big.Float).SetInt(initialValPower)
+	initialPercent := new(big.Float).Quo(iValPower, iTotalPower)
+	fmt.Printf("initial power = %s / %s = %s\n", initialValPower, initialTotalPower, initialPercent.String())
+
+	percentAfter := big.NewFloat(desiredPercent)
+	// if we already have enough power, no change is necessary
+	if initialPercent.Cmp(percentAfter) >= 0 {
+		return big.NewInt(0)
+	}
+
+	// a = (P + Δ) / (T + Δ) => Δ = (a*T - P) / (1 - a)
+	num := new(big.Float).Sub(new(big.Float).Mul(percentAfter, iTotalPower), iValPower)
+	den := new(big.Float).Sub(big.NewFloat(1), percentAfter)
+	delta, _ := new(big.Float).Quo(num, den).Int(nil)
+	// add 1 to ensure any rounding is in our validators' favor
+	return delta.Add(delta, big.NewInt(1))
+}
+
+// consAddressPrefix finds the bech32 prefix of consensus addresses, eg magevalcons, from the operator addresses in a genesis.
+func consAddressPrefix(genesis *gabs.Container) (string, error) {
+	for _, val := range genesis.Path("app_state.staking.validators").Children() {
+		operator, ok := val.Path("operator_address").Data().(string)
+		if !ok {
+			continue
+		}
+		hrp, _, err := bech32.DecodeAndConvert(operator)
+		if err != nil {
+			return "", fmt.Errorf("could not decode operator address %s: %w", operator, err)
+		}
+		return strings.TrimSuffix(hrp, "valoper") + "valcons", nil
+	}
+	return "", fmt.Errorf("no staking validators found in genesis")
+}
+
+// consAddress converts a hex consensus address to bech32.
+func consAddress(prefix, hexAddress string) (string, error) {
+	bz, err := hex.DecodeString(hexAddress)
+	if err != nil {
+		return "", fmt.Errorf("could not decode validator address %s: %w", hexAddress, err)
+	}
+	return bech32.ConvertAndEncode(prefix, bz)
+}
+
+// jsonInt reads an integer that may be encoded as a json number or a string.
+func jsonInt(v interface{}) int64 {
+	switch n := v.(type) {
+	case json.Number:
+		i, _ := n.Int64()
+		return i
+	case float64:
+		return int64(n)
+	case string:
+		i, _ := strconv.ParseInt(n, 10, 64)
+		return i
+	}
+	return 0
+}
+
+func readJSON(filename string) (*gabs.Container, error) {
+	bz, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	// decode numbers as json.Number so large integers keep their precision when written back out
+	decoder := json.NewDecoder(bytes.NewReader(bz))
+	decoder.UseNumber()
+	container, err := gabs.ParseJSONDecoder(decoder)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", filename, err)
+	}
+	return container, nil
+}
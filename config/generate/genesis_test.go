@@ -0,0 +1,159 @@
+package generate
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Jeffail/gabs/v2"
+	"github.com/tendermint/tendermint/libs/bech32"
+)
+
+// testValidator is a validator in a test genesis, derived from its ed25519 consensus public key like tendermint does.
+type testValidator struct {
+	pubKey   []byte
+	address  []byte
+	operator string
+	valcons  string
+}
+
+func newTestValidator(t *testing.T, seed byte) testValidator {
+	pubKey := bytes.Repeat([]byte{seed}, 32)
+	hash := sha256.Sum256(pubKey)
+	v := testValidator{pubKey: pubKey, address: hash[:20]}
+	var err error
+	// the operator address is unrelated to the consensus key
+	if v.operator, err = bech32.ConvertAndEncode("magevaloper", bytes.Repeat([]byte{seed + 1}, 20)); err != nil {
+		t.Fatal(err)
+	}
+	if v.valcons, err = bech32.ConvertAndEncode("magevalcons", v.address); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// testExportedGenesis is a genesis exported from a two validator cosmos-sdk v0.40 chain.
+// The top level powers are strings as in an export, the second validator's is a number to check both are read.
+func testExportedGenesis(t *testing.T, first, second testValidator) *gabs.Container {
+	genesis := fmt.Sprintf(`{
+  "chain_id": "mage-testnet",
+  "validators": [
+    {"address": "%[1]X", "name": "second", "power": 50, "pub_key": {"type": "tendermint/PubKeyEd25519", "value": "%[2]s"}},
+    {"address": "%[3]X", "name": "first", "power": "100", "pub_key": {"type": "tendermint/PubKeyEd25519", "value": "%[4]s"}}
+  ],
+  "app_state": {
+    "staking": {
+      "last_total_power": "150",
+      "last_validator_powers": [
+        {"address": "%[5]s", "power": "100"},
+        {"address": "%[6]s", "power": "50"}
+      ],
+      "validators": [
+        {"operator_address": "%[5]s", "consensus_pubkey": {"@type": "/cosmos.crypto.ed25519.PubKey", "key": "%[4]s"}},
+        {"operator_address": "%[6]s", "consensus_pubkey": {"@type": "/cosmos.crypto.ed25519.PubKey", "key": "%[2]s"}}
+      ]
+    },
+    "slashing": {
+      "signing_infos": [
+        {"address": "%[7]s", "validator_signing_info": {"address": "%[7]s", "missed_blocks_counter": "0"}},
+        {"address": "%[8]s", "validator_signing_info": {"address": "%[8]s", "missed_blocks_counter": "0"}}
+      ],
+      "missed_blocks": [
+        {"address": "%[7]s", "missed_blocks": []},
+        {"address": "%[8]s", "missed_blocks": []}
+      ]
+    },
+    "distribution": {
+      "previous_proposer": "%[7]s"
+    }
+  }
+}`,
+		second.address, base64.StdEncoding.EncodeToString(second.pubKey),
+		first.address, base64.StdEncoding.EncodeToString(first.pubKey),
+		first.operator, second.operator,
+		first.valcons, second.valcons,
+	)
+	decoder := json.NewDecoder(strings.NewReader(genesis))
+	decoder.UseNumber()
+	container, err := gabs.ParseJSONDecoder(decoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return container
+}
+
+func TestReplaceGenesisValidators(t *testing.T) {
+	first, second, replacement := newTestValidator(t, 1), newTestValidator(t, 3), newTestValidator(t, 5)
+	genesis := testExportedGenesis(t, first, second)
+	key := ValidatorKey{Address: strings.ToUpper(hex.EncodeToString(replacement.address))}
+	key.PubKey.Type = "tendermint/PubKeyEd25519"
+	key.PubKey.Value = base64.StdEncoding.EncodeToString(replacement.pubKey)
+
+	// the first validator has 100/150 of the power, so it needs a boost to reach 67%
+	if err := replaceGenesisValidators(genesis, []ValidatorKey{key}, 0.67); err != nil {
+		t.Fatal(err)
+	}
+
+	// Δ = (0.67*150 - 100) / (1 - 0.67) ≈ 1.5, rounded down and increased by 1
+	expectString(t, genesis, "152", "app_state", "staking", "last_total_power")
+	powers := genesis.Path("app_state.staking.last_validator_powers").Children()
+	expectString(t, powers[0], first.operator, "address")
+	expectString(t, powers[0], "102", "power")
+	expectString(t, powers[1], "50", "power")
+
+	// the top level validators are sorted by power, with the replaced validator's key and power updated
+	validators := genesis.Path("validators").Children()
+	expectString(t, validators[0], "first", "name")
+	expectString(t, validators[0], key.Address, "address")
+	expectString(t, validators[0], key.PubKey.Value, "pub_key", "value")
+	expectString(t, validators[0], "102", "power")
+	expectString(t, validators[1], "second", "name")
+	expectString(t, validators[1], strings.ToUpper(hex.EncodeToString(second.address)), "address")
+	if power := jsonInt(validators[1].Path("power").Data()); power != 50 {
+		t.Fatalf("expected the second validator's power to stay 50, got %d", power)
+	}
+
+	stakingValidators := genesis.Path("app_state.staking.validators").Children()
+	expectString(t, stakingValidators[0], key.PubKey.Value, "consensus_pubkey", "key")
+	expectString(t, stakingValidators[1], base64.StdEncoding.EncodeToString(second.pubKey), "consensus_pubkey", "key")
+
+	// the first validator's valcons address is replaced everywhere it's used
+	signingInfos := genesis.Path("app_state.slashing.signing_infos").Children()
+	expectString(t, signingInfos[0], replacement.valcons, "address")
+	expectString(t, signingInfos[0], replacement.valcons, "validator_signing_info", "address")
+	expectString(t, signingInfos[1], second.valcons, "address")
+	expectString(t, signingInfos[1], second.valcons, "validator_signing_info", "address")
+	missedBlocks := genesis.Path("app_state.slashing.missed_blocks").Children()
+	expectString(t, missedBlocks[0], replacement.valcons, "address")
+	expectString(t, missedBlocks[1], second.valcons, "address")
+	expectString(t, genesis, replacement.valcons, "app_state", "distribution", "previous_proposer")
+}
+
+func TestReplaceGenesisValidatorsEnoughPower(t *testing.T) {
+	first, second, replacement := newTestValidator(t, 1), newTestValidator(t, 3), newTestValidator(t, 5)
+	genesis := testExportedGenesis(t, first, second)
+	key := ValidatorKey{Address: strings.ToUpper(hex.EncodeToString(replacement.address))}
+	key.PubKey.Type = "tendermint/PubKeyEd25519"
+	key.PubKey.Value = base64.StdEncoding.EncodeToString(replacement.pubKey)
+
+	// the first validator already has more than half the power, so the powers don't change
+	if err := replaceGenesisValidators(genesis, []ValidatorKey{key}, 0.5); err != nil {
+		t.Fatal(err)
+	}
+	expectString(t, genesis, "150", "app_state", "staking", "last_total_power")
+	expectString(t, genesis.Path("app_state.staking.last_validator_powers").Children()[0], "100", "power")
+	expectString(t, genesis.Path("validators").Children()[0], "100", "power")
+	expectString(t, genesis, replacement.valcons, "app_state", "distribution", "previous_proposer")
+}
+
+func expectString(t *testing.T, c *gabs.Container, expected string, path ...string) {
+	t.Helper()
+	if actual := fmt.Sprint(c.Search(path...).Data()); actual != expected {
+		t.Fatalf("expected %s to be %s, got %s", strings.Join(path, "."), expected, actual)
+	}
+}
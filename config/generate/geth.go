@@ -0,0 +1,245 @@
+package generate
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Jeffail/gabs/v2"
+)
+
+// GethNodeService is the compose service of the geth node in the geth template.
+const GethNodeService = "gethnode"
+
+// gethExportedState is where the geth template's exported blocks are mounted in the container. They're imported on top of the genesis.
+const gethExportedState = "/root/exported_state"
+
+// ErrGethExportedState is returned when the geth genesis is changed without allowing the template's exported blocks to be dropped.
+var ErrGethExportedState = errors.New("the geth template's exported blocks can only be imported on its original genesis, changing it drops them and the contracts they deploy")
+
+// GethGenesis are changes to make to the genesis of a generated config's geth node.
+type GethGenesis struct {
+	// ChainID replaces the template's chain id, unless it's 0.
+	ChainID int64
+	// BlockTime replaces the template's clique block period, unless it's 0. It's rounded to whole seconds.
+	BlockTime time.Duration
+	Accounts  []GethAccount
+	Contracts []GethContract
+	// DropExportedState allows the changes to remove the template's exported blocks, and the contracts deployed in them.
+	// Without it, changing the genesis of a template with exported blocks fails with ErrGethExportedState.
+	DropExportedState bool
+}
+
+// Empty reports if there are no changes to make.
+func (g GethGenesis) Empty() bool {
+	return g.ChainID == 0 && g.BlockTime == 0 && len(g.Accounts) == 0 && len(g.Contracts) == 0
+}
+
+// GethAccount is an account funded in the geth genesis.
+type GethAccount struct {
+	Address string
+	// Balance is in wei.
+	Balance *big.Int
+}
+
+// GethContract is a contract deployed in the geth genesis.
+// Its constructor isn't run, so any state it sets up has to be given as storage.
+type GethContract struct {
+	Address string
+	// Code is the hex encoded deployed bytecode.
+	Code string
+	// Storage maps 32 byte hex slots to 32 byte hex values.
+	Storage map[string]string
+}
+
+// ParseGethAddress checks an ethereum address is valid and returns it in the form used in the genesis alloc, lowercase without a 0x prefix.
+func ParseGethAddress(address string) (string, error) {
+	trimmed := strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(address, "0x"), "0X"))
+	if bz, err := hex.DecodeString(trimmed); err != nil || len(bz) != 20 {
+		return "", fmt.Errorf("invalid ethereum address '%s'", address)
+	}
+	return trimmed, nil
+}
+
+// ParseGethStorageWord checks a storage slot or value is hex of at most 32 bytes, and returns it zero padded to 32 bytes with a 0x prefix.
+func ParseGethStorageWord(word string) (string, error) {
+	trimmed := strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(word, "0x"), "0X"))
+	if len(trimmed) == 0 || len(trimmed) > 64 {
+		return "", fmt.Errorf("invalid storage word '%s', must be hex of at most 32 bytes", word)
+	}
+	padded := strings.Repeat("0", 64-len(trimmed)) + trimmed
+	if _, err := hex.DecodeString(padded); err != nil {
+		return "", fmt.Errorf("invalid storage word '%s', must be hex of at most 32 bytes", word)
+	}
+	return "0x" + padded, nil
+}
+
+// LoadContractArtifact reads the deployed bytecode from a compiled contract, such as a hardhat or truffle artifact,
+// foundry output, or a contract from solc's standard json output.
+func LoadContractArtifact(filename string) (string, error) {
+	artifact, err := readJSON(filename)
+	if err != nil {
+		return "", err
+	}
+	var code string
+	for _, path := range []string{"deployedBytecode", "deployedBytecode.object", "evm.deployedBytecode.object"} {
+		if s, ok := artifact.Path(path).Data().(string); ok {
+			code = s
+			break
+		}
+	}
+	code = strings.TrimPrefix(code, "0x")
+	if code == "" {
+		return "", fmt.Errorf("%s has no deployed bytecode, it may be an interface or abstract contract", filename)
+	}
+	if strings.Contains(code, "__") {
+		return "", fmt.Errorf("%s has unlinked libraries, link them before deploying it in the genesis", filename)
+	}
+	if _, err := hex.DecodeString(code); err != nil {
+		return "", fmt.Errorf("%s has invalid deployed bytecode: %w", filename, err)
+	}
+	return "0x" + code, nil
+}
+
+// ConfigureGethGenesis applies changes to the genesis of a generated config's geth node.
+// The template's exported blocks were made on top of the template's genesis, so they can't be imported on a changed one.
+// If changes.DropExportedState is set they're removed from the generated config, along with the contracts the template
+// deployed in them, otherwise ErrGethExportedState is returned.
+func ConfigureGethGenesis(generatedConfigDir string, changes GethGenesis) error {
+	genesisFile := filepath.Join(generatedConfigDir, "geth", "initstate", "genesis.json")
+	if _, err := os.Stat(genesisFile); os.IsNotExist(err) {
+		return fmt.Errorf("geth genesis options need the %s service", GethServiceName)
+	}
+	composeFile := filepath.Join(generatedConfigDir, "docker-compose.yaml")
+	compose, err := importYAML(composeFile)
+	if err != nil {
+		return err
+	}
+	if gethExportedStateFile(compose) != "" && !changes.DropExportedState {
+		return ErrGethExportedState
+	}
+	genesis, err := readJSON(genesisFile)
+	if err != nil {
+		return err
+	}
+
+	if changes.ChainID != 0 {
+		if _, err := genesis.Set(changes.ChainID, "config", "chainId"); err != nil {
+			return err
+		}
+	}
+	if changes.BlockTime != 0 {
+		if !genesis.Exists("config", "clique") {
+			return fmt.Errorf("the geth genesis doesn't use clique, so its block time can't be set")
+		}
+		period := int64(changes.BlockTime.Round(time.Second).Seconds())
+		if period < 1 {
+			return fmt.Errorf("geth block time must be at least 1s")
+		}
+		if _, err := genesis.Set(period, "config", "clique", "period"); err != nil {
+			return err
+		}
+	}
+	for _, account := range changes.Accounts {
+		if err := fundGethAccount(genesis, account); err != nil {
+			return fmt.Errorf("could not fund %s: %w", account.Address, err)
+		}
+		fmt.Printf("funded 0x%s with %s wei\n", account.Address, account.Balance)
+	}
+	for _, contract := range changes.Contracts {
+		if err := deployGethContract(genesis, contract); err != nil {
+			return fmt.Errorf("could not deploy contract at %s: %w", contract.Address, err)
+		}
+		fmt.Printf("deployed contract at 0x%s\n", contract.Address)
+	}
+	if err := ioutil.WriteFile(genesisFile, genesis.BytesIndent("", "  "), 0644); err != nil {
+		return err
+	}
+	return removeGethExportedState(generatedConfigDir, compose)
+}
+
+// fundGethAccount adds wei to an account's balance in the genesis alloc, creating the account if needed.
+func fundGethAccount(genesis *gabs.Container, account GethAccount) error {
+	balance := new(big.Int).Set(account.Balance)
+	if existing, ok := genesis.Search("alloc", account.Address, "balance").Data().(string); ok {
+		current, ok := parseGethQuantity(existing)
+		if !ok {
+			return fmt.Errorf("could not parse existing balance '%s'", existing)
+		}
+		balance.Add(balance, current)
+	}
+	_, err := genesis.Set(balance.String(), "alloc", account.Address, "balance")
+	return err
+}
+
+// deployGethContract sets the code and storage of an account in the genesis alloc, keeping its balance.
+func deployGethContract(genesis *gabs.Container, contract GethContract) error {
+	if _, err := genesis.Set(contract.Code, "alloc", contract.Address, "code"); err != nil {
+		return err
+	}
+	if !genesis.Exists("alloc", contract.Address, "balance") {
+		if _, err := genesis.Set("0", "alloc", contract.Address, "balance"); err != nil {
+			return err
+		}
+	}
+	if len(contract.Storage) == 0 {
+		return nil
+	}
+	storage := map[string]interface{}{}
+	for slot, value := range contract.Storage {
+		storage[slot] = value
+	}
+	_, err := genesis.Set(storage, "alloc", contract.Address, "storage")
+	return err
+}
+
+// parseGethQuantity parses a genesis number, which can be decimal or 0x prefixed hex.
+func parseGethQuantity(s string) (*big.Int, bool) {
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		return new(big.Int).SetString(s[2:], 16)
+	}
+	return new(big.Int).SetString(s, 10)
+}
+
+// gethExportedStateFile returns the host path of the exported blocks mounted into the geth node, or "" if there are none.
+func gethExportedStateFile(compose *gabs.Container) string {
+	volumes, _ := compose.Search("services", GethNodeService, "volumes").Data().([]interface{})
+	for _, v := range volumes {
+		parts := strings.Split(fmt.Sprint(v), ":")
+		if len(parts) >= 2 && parts[1] == gethExportedState {
+			return parts[0]
+		}
+	}
+	return ""
+}
+
+// removeGethExportedState stops the geth node importing the template's exported blocks.
+func removeGethExportedState(generatedConfigDir string, compose *gabs.Container) error {
+	hostFile := gethExportedStateFile(compose)
+	if hostFile == "" {
+		return nil
+	}
+	service := compose.Search("services", GethNodeService)
+	volumes, _ := service.Search("volumes").Data().([]interface{})
+	var kept []interface{}
+	for _, v := range volumes {
+		if parts := strings.Split(fmt.Sprint(v), ":"); len(parts) >= 2 && parts[1] == gethExportedState {
+			continue
+		}
+		kept = append(kept, v)
+	}
+	if _, err := service.Set(kept, "volumes"); err != nil {
+		return err
+	}
+	if err := exportYAML(filepath.Join(generatedConfigDir, "docker-compose.yaml"), compose); err != nil {
+		return err
+	}
+	fmt.Println("dropped the geth template's exported blocks, its deployed contracts won't be included")
+	return os.Remove(filepath.Join(generatedConfigDir, filepath.FromSlash(hostFile)))
+}
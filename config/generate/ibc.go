@@ -0,0 +1,526 @@
+package generate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Jeffail/gabs/v2"
+	"github.com/otiai10/copy"
+)
+
+// IbcNodeService is the compose service of the first ibc chain. Extra chains are named ibcnode-1, ibcnode-2, etc.
+const IbcNodeService = "ibcnode"
+
+// ibcChainPortStep is how far the host ports of each extra ibc chain are moved from the previous chain's.
+const ibcChainPortStep = 10
+
+// files of the relayers in the generated config
+var (
+	relayerConfigFile  = filepath.Join("relayer", "config", "config.yaml")
+	relayerKeysDir     = filepath.Join("relayer", "keys")
+	hermesConfigFile   = filepath.Join("hermes", "config.toml")
+	hermesChannelsFile = filepath.Join("hermes", "channels.yaml")
+)
+
+// IbcChain is a chain in a generated config that the relayers connect.
+type IbcChain struct {
+	// ComposeService is the name of the chain's node in the generated docker-compose.yaml.
+	ComposeService string
+	ChainID        string
+	// Home is the node's home directory in the generated config folder, eg ibcchain-1/initstate/.mage.
+	Home string
+}
+
+// ConfigDir is the node's config directory, containing its keys and genesis.
+func (c IbcChain) ConfigDir() string {
+	return filepath.Join(c.Home, "config")
+}
+
+// AddIbcChains adds count-1 ibc chains alongside the ibc chain in a generated config, so it has count of them.
+// Each chain is a copy of the first with its own chain id, compose service, and host ports, and is added to the relayers' configs.
+// The new chains' genesis files have no gentxs, a validator needs creating for each before they can start.
+// The first chain returned is the original one. If the config has no ibc chain it returns nothing.
+func AddIbcChains(generatedConfigDir string, count int) ([]IbcChain, error) {
+	composeFile := filepath.Join(generatedConfigDir, "docker-compose.yaml")
+	compose, err := importYAML(composeFile)
+	if err != nil {
+		return nil, err
+	}
+	ibcNode := compose.Search("services", IbcNodeService)
+	if ibcNode.Data() == nil {
+		if count > 1 {
+			return nil, fmt.Errorf("no %s service in %s, more ibc chains can only be added to a config with the ibc service", IbcNodeService, composeFile)
+		}
+		return nil, nil
+	}
+	chainDir := services[IbcServiceName].TemplateDir()
+	genesisFile, err := findGenesisFile(filepath.Join(generatedConfigDir, chainDir, "initstate"))
+	if err != nil {
+		return nil, err
+	}
+	genesis, err := readJSON(genesisFile)
+	if err != nil {
+		return nil, err
+	}
+	chainID, _ := genesis.Path("chain_id").Data().(string)
+	chains := []IbcChain{{ComposeService: IbcNodeService, ChainID: chainID, Home: filepath.Dir(filepath.Dir(genesisFile))}}
+	relHome, err := filepath.Rel(filepath.Join(generatedConfigDir, chainDir), chains[0].Home)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 1; i < count; i++ {
+		outputDir := fmt.Sprintf("%s-%d", chainDir, i)
+		chain := IbcChain{
+			ComposeService: fmt.Sprintf("%s-%d", IbcNodeService, i),
+			ChainID:        nthChainID(chainID, i),
+			Home:           filepath.Join(generatedConfigDir, outputDir, relHome),
+		}
+		if err := copy.Copy(filepath.Join(generatedConfigDir, chainDir), filepath.Join(generatedConfigDir, outputDir)); err != nil {
+			return nil, err
+		}
+		if err := setIbcChainID(chain); err != nil {
+			return nil, err
+		}
+
+		service := map[string]interface{}{}
+		for field, value := range ibcNode.ChildrenMap() {
+			service[field] = value.Data()
+		}
+		if volumes, ok := service["volumes"].([]interface{}); ok {
+			var moved []interface{}
+			for _, v := range volumes {
+				moved = append(moved, strings.Replace(fmt.Sprint(v), "./"+chainDir+"/", "./"+outputDir+"/", 1))
+			}
+			service["volumes"] = moved
+		}
+		if ports, ok := service["ports"].([]interface{}); ok {
+			service["ports"] = append([]interface{}{}, ports...)
+		}
+		// each chain publishes the same container ports, so they're moved on the host
+		single := gabs.Wrap(map[string]interface{}{"services": map[string]interface{}{chain.ComposeService: service}})
+		if err := offsetHostPorts(single, i*ibcChainPortStep); err != nil {
+			return nil, err
+		}
+		if _, err := compose.Set(service, "services", chain.ComposeService); err != nil {
+			return nil, err
+		}
+		chains = append(chains, chain)
+	}
+	if count <= 1 {
+		return chains, nil
+	}
+	if err := addRelayerChains(generatedConfigDir, chains); err != nil {
+		return nil, err
+	}
+	if err := addHermesChains(generatedConfigDir, chains); err != nil {
+		return nil, err
+	}
+	return chains, exportYAML(composeFile, compose)
+}
+
+var chainIDNumber = regexp.MustCompile(`^(.*-)(\d+)$`)
+
+// nthChainID returns a chain id for the ith copy of a chain, eg mage-localnet-2 becomes mage-localnet-3 for the first copy.
+func nthChainID(chainID string, i int) string {
+	if match := chainIDNumber.FindStringSubmatch(chainID); match != nil {
+		n, err := strconv.Atoi(match[2])
+		if err == nil {
+			return match[1] + strconv.Itoa(n+i)
+		}
+	}
+	return fmt.Sprintf("%s-%d", chainID, i+1)
+}
+
+// setIbcChainID changes the chain id of a copied chain. Its gentxs are removed as they're signed for the original chain id.
+func setIbcChainID(chain IbcChain) error {
+	genesisFile := filepath.Join(chain.ConfigDir(), "genesis.json")
+	genesis, err := readJSON(genesisFile)
+	if err != nil {
+		return err
+	}
+	if _, err := genesis.Set(chain.ChainID, "chain_id"); err != nil {
+		return err
+	}
+	if genesis.Exists("app_state", "genutil", "gen_txs") {
+		if _, err := genesis.Set([]interface{}{}, "app_state", "genutil", "gen_txs"); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(genesisFile, genesis.BytesIndent("", "  "), 0644); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(chain.ConfigDir(), "gentx")); err != nil {
+		return err
+	}
+	clientFile := filepath.Join(chain.ConfigDir(), "client.toml")
+	if _, err := os.Stat(clientFile); err == nil {
+		return setTOMLValues(clientFile, map[string]string{"chain-id": fmt.Sprintf("%q", chain.ChainID)})
+	}
+	return nil
+}
+
+// addRelayerChains adds the copies of the first chain to the go relayer's config, with the same keys as the first chain.
+func addRelayerChains(generatedConfigDir string, chains []IbcChain) error {
+	configFile := filepath.Join(generatedConfigDir, relayerConfigFile)
+	if !fileExists(configFile) {
+		return nil
+	}
+	config, err := importYAML(configFile)
+	if err != nil {
+		return err
+	}
+	var template map[string]interface{}
+	for _, c := range config.Search("chains").Children() {
+		if c.Search("chain-id").Data() == chains[0].ChainID {
+			template, _ = c.Data().(map[string]interface{})
+		}
+	}
+	if template == nil {
+		return fmt.Errorf("no chain %s in %s", chains[0].ChainID, configFile)
+	}
+	for _, chain := range chains[1:] {
+		entry := map[string]interface{}{}
+		for k, v := range template {
+			entry[k] = v
+		}
+		entry["chain-id"] = chain.ChainID
+		entry["rpc-addr"] = strings.Replace(fmt.Sprint(template["rpc-addr"]), "//"+IbcNodeService+":", "//"+chain.ComposeService+":", 1)
+		if err := config.ArrayAppend(entry, "chains"); err != nil {
+			return err
+		}
+		keysDir := filepath.Join(generatedConfigDir, relayerKeysDir)
+		if err := copy.Copy(filepath.Join(keysDir, chains[0].ChainID), filepath.Join(keysDir, chain.ChainID)); err != nil {
+			return err
+		}
+	}
+	return exportYAML(configFile, config)
+}
+
+// addHermesChains adds the copies of the first chain to hermes' config.
+func addHermesChains(generatedConfigDir string, chains []IbcChain) error {
+	configFile := filepath.Join(generatedConfigDir, hermesConfigFile)
+	bz, err := ioutil.ReadFile(configFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	config := string(bz)
+	start := strings.Index(config, fmt.Sprintf("[[chains]]\nid = '%s'", chains[0].ChainID))
+	if start < 0 {
+		return fmt.Errorf("no chain %s in %s", chains[0].ChainID, configFile)
+	}
+	block := config[start:]
+	if end := strings.Index(block[1:], "[[chains]]"); end >= 0 {
+		block = block[:end+1]
+	}
+	block = strings.TrimRight(block, "\n")
+	config = strings.TrimRight(config, "\n")
+	for _, chain := range chains[1:] {
+		entry := strings.Replace(block, "'"+chains[0].ChainID+"'", "'"+chain.ChainID+"'", 1)
+		entry = strings.ReplaceAll(entry, "//"+IbcNodeService+":", "//"+chain.ComposeService+":")
+		config += "\n\n" + entry
+	}
+	return ioutil.WriteFile(configFile, []byte(config+"\n"), 0644)
+}
+
+// IbcChannelEnd is one side of an ibc channel.
+type IbcChannelEnd struct {
+	// Chain is the compose service of the chain's node, eg magenode. It's empty for channels between mage and every ibc chain.
+	Chain string
+	Port  string
+}
+
+// IbcChannel is a channel for the relayer to open between two chains.
+type IbcChannel struct {
+	A, B    IbcChannelEnd
+	Ordered bool
+	Version string
+}
+
+// DefaultIbcChannel is a token transfer channel between mage and every ibc chain.
+var DefaultIbcChannel = IbcChannel{
+	A:       IbcChannelEnd{Port: "transfer"},
+	B:       IbcChannelEnd{Port: "transfer"},
+	Version: "ics20-1",
+}
+
+// ParseIbcChannel reads a channel in the form [<service>:]<port>=[<service>:]<port>[,ordered|unordered][,<version>],
+// eg transfer=transfer or ibcnode:transfer=ibcnode-1:transfer,unordered,ics20-1.
+// Without services the channel is between mage and every ibc chain. The version defaults to ics20-1.
+func ParseIbcChannel(spec string) (IbcChannel, error) {
+	parts := strings.Split(spec, ",")
+	ends := strings.Split(parts[0], "=")
+	if len(ends) != 2 {
+		return IbcChannel{}, fmt.Errorf("invalid channel '%s', must be in the form [<service>:]<port>=[<service>:]<port>[,ordered|unordered][,<version>]", spec)
+	}
+	channel := IbcChannel{Version: DefaultIbcChannel.Version}
+	for i, end := range ends {
+		var e IbcChannelEnd
+		if j := strings.Index(end, ":"); j >= 0 {
+			e = IbcChannelEnd{Chain: end[:j], Port: end[j+1:]}
+		} else {
+			e = IbcChannelEnd{Port: end}
+		}
+		if e.Port == "" {
+			return IbcChannel{}, fmt.Errorf("invalid channel '%s', missing port", spec)
+		}
+		if i == 0 {
+			channel.A = e
+		} else {
+			channel.B = e
+		}
+	}
+	if (channel.A.Chain == "") != (channel.B.Chain == "") {
+		return IbcChannel{}, fmt.Errorf("invalid channel '%s', set the service of both ends or neither", spec)
+	}
+	for _, option := range parts[1:] {
+		switch option {
+		case "ordered":
+			channel.Ordered = true
+		case "unordered":
+			channel.Ordered = false
+		case "":
+			return IbcChannel{}, fmt.Errorf("invalid channel '%s', empty option", spec)
+		default:
+			channel.Version = option
+		}
+	}
+	return channel, nil
+}
+
+// IbcPath is a channel for a relayer to open, with the chain ids of its ends.
+type IbcPath struct {
+	// Name is the go relayer's name for the path, eg magenode-ibcnode-transfer.
+	Name     string
+	Src, Dst IbcPathEnd
+	Ordered  bool
+	Version  string
+}
+
+// IbcPathEnd is one side of an IbcPath.
+type IbcPathEnd struct {
+	ChainID string
+	Port    string
+}
+
+// ConfigureIbcChannels writes the channels for the relayer in a generated config to open once the chains start.
+// They're written as paths in the go relayer's config, or to a channels file next to hermes' config.
+// The chains are the ibc chains returned by AddIbcChains, the mage chain is added to them.
+// If no channels are given DefaultIbcChannel is used. It errors if channels are given but the config has no relayer.
+func ConfigureIbcChannels(generatedConfigDir string, chains []IbcChain, channels []IbcChannel) error {
+	relayerConfig := filepath.Join(generatedConfigDir, relayerConfigFile)
+	hermesConfig := filepath.Join(generatedConfigDir, hermesConfigFile)
+	hasRelayer, hasHermes := fileExists(relayerConfig), fileExists(hermesConfig)
+	if hasRelayer && hasHermes {
+		return fmt.Errorf("the go relayer and hermes would both open the ibc channels, generate config for only one of them")
+	}
+	if !hasRelayer && !hasHermes {
+		if len(channels) > 0 {
+			return fmt.Errorf("channels are opened by a relayer, but neither the go relayer or hermes is part of the generated config")
+		}
+		return nil
+	}
+	if len(channels) == 0 {
+		channels = []IbcChannel{DefaultIbcChannel}
+	}
+	mageGenesisFile, err := MageGenesisFile(generatedConfigDir)
+	if err != nil {
+		return err
+	}
+	mageGenesis, err := readJSON(mageGenesisFile)
+	if err != nil {
+		return err
+	}
+	mageChainID, _ := mageGenesis.Path("chain_id").Data().(string)
+	chainIDs := map[string]string{MageNodeService: mageChainID}
+	var serviceNames []string
+	for _, c := range chains {
+		chainIDs[c.ComposeService] = c.ChainID
+		serviceNames = append(serviceNames, c.ComposeService)
+	}
+
+	var expanded []IbcChannel
+	for _, channel := range channels {
+		if channel.A.Chain != "" {
+			expanded = append(expanded, channel)
+			continue
+		}
+		for _, c := range chains {
+			channel.A.Chain, channel.B.Chain = MageNodeService, c.ComposeService
+			expanded = append(expanded, channel)
+		}
+	}
+
+	var paths []IbcPath
+	names := map[string]bool{}
+	for _, channel := range expanded {
+		for _, end := range []IbcChannelEnd{channel.A, channel.B} {
+			if _, found := chainIDs[end.Chain]; !found {
+				return fmt.Errorf("unknown chain %s in ibc channel, must be %s or one of %s", end.Chain, MageNodeService, strings.Join(serviceNames, ", "))
+			}
+		}
+		if channel.A.Chain == channel.B.Chain {
+			return fmt.Errorf("ibc channel from %s to itself, the ends must be different chains", channel.A.Chain)
+		}
+		name := relayerPathName(channel)
+		if names[name] {
+			return fmt.Errorf("ibc channel %s is set more than once", name)
+		}
+		names[name] = true
+		paths = append(paths, IbcPath{
+			Name:    name,
+			Src:     IbcPathEnd{ChainID: chainIDs[channel.A.Chain], Port: channel.A.Port},
+			Dst:     IbcPathEnd{ChainID: chainIDs[channel.B.Chain], Port: channel.B.Port},
+			Ordered: channel.Ordered,
+			Version: channel.Version,
+		})
+	}
+	if hasRelayer {
+		return writeRelayerPaths(relayerConfig, paths)
+	}
+	return writeHermesChannels(filepath.Join(generatedConfigDir, hermesChannelsFile), paths)
+}
+
+// relayerPathName names the relayer path for a channel after its ends, eg magenode-ibcnode-transfer.
+func relayerPathName(channel IbcChannel) string {
+	name := channel.A.Chain + "-" + channel.B.Chain + "-" + channel.A.Port
+	if channel.B.Port != channel.A.Port {
+		name += "-" + channel.B.Port
+	}
+	return name
+}
+
+// writeRelayerPaths sets the paths in the go relayer's config.
+func writeRelayerPaths(configFile string, paths []IbcPath) error {
+	relayerPaths := map[string]interface{}{}
+	for _, p := range paths {
+		order := "UNORDERED"
+		if p.Ordered {
+			order = "ORDERED"
+		}
+		pathEnd := func(end IbcPathEnd) map[string]interface{} {
+			return map[string]interface{}{
+				"chain-id": end.ChainID,
+				"port-id":  end.Port,
+				"order":    order,
+				"version":  p.Version,
+			}
+		}
+		relayerPaths[p.Name] = map[string]interface{}{
+			"src":      pathEnd(p.Src),
+			"dst":      pathEnd(p.Dst),
+			"strategy": map[string]interface{}{"type": "naive"},
+		}
+	}
+	config, err := importYAML(configFile)
+	if err != nil {
+		return err
+	}
+	if _, err := config.Set(relayerPaths, "paths"); err != nil {
+		return err
+	}
+	return exportYAML(configFile, config)
+}
+
+// writeHermesChannels writes the channels file hermes' channels are created from. Hermes' config has no place for them.
+func writeHermesChannels(channelsFile string, paths []IbcPath) error {
+	var channels []interface{}
+	for _, p := range paths {
+		order := "unordered"
+		if p.Ordered {
+			order = "ordered"
+		}
+		channels = append(channels, map[string]interface{}{
+			"name":    p.Name,
+			"a-chain": p.Src.ChainID,
+			"a-port":  p.Src.Port,
+			"b-chain": p.Dst.ChainID,
+			"b-port":  p.Dst.Port,
+			"order":   order,
+			"version": p.Version,
+		})
+	}
+	return exportYAML(channelsFile, gabs.Wrap(map[string]interface{}{"channels": channels}))
+}
+
+// IbcPaths lists the channels the relayer in a generated config opens, from the go relayer's config or hermes' channels file.
+func IbcPaths(generatedConfigDir string) ([]IbcPath, error) {
+	relayerConfig := filepath.Join(generatedConfigDir, relayerConfigFile)
+	if fileExists(relayerConfig) {
+		config, err := importYAML(relayerConfig)
+		if err != nil {
+			return nil, err
+		}
+		var paths []IbcPath
+		for name, p := range config.Search("paths").ChildrenMap() {
+			pathEnd := func(end string) IbcPathEnd {
+				chainID, _ := p.Search(end, "chain-id").Data().(string)
+				port, _ := p.Search(end, "port-id").Data().(string)
+				return IbcPathEnd{ChainID: chainID, Port: port}
+			}
+			order, _ := p.Search("src", "order").Data().(string)
+			version, _ := p.Search("src", "version").Data().(string)
+			paths = append(paths, IbcPath{
+				Name:    name,
+				Src:     pathEnd("src"),
+				Dst:     pathEnd("dst"),
+				Ordered: strings.EqualFold(order, "ordered"),
+				Version: version,
+			})
+		}
+		sort.Slice(paths, func(i, j int) bool { return paths[i].Name < paths[j].Name })
+		return paths, nil
+	}
+	channelsFile := filepath.Join(generatedConfigDir, hermesChannelsFile)
+	if !fileExists(channelsFile) {
+		return nil, nil
+	}
+	channels, err := importYAML(channelsFile)
+	if err != nil {
+		return nil, err
+	}
+	var paths []IbcPath
+	for _, c := range channels.Search("channels").Children() {
+		field := func(name string) string {
+			value, _ := c.Search(name).Data().(string)
+			return value
+		}
+		paths = append(paths, IbcPath{
+			Name:    field("name"),
+			Src:     IbcPathEnd{ChainID: field("a-chain"), Port: field("a-port")},
+			Dst:     IbcPathEnd{ChainID: field("b-chain"), Port: field("b-port")},
+			Ordered: field("order") == "ordered",
+			Version: field("version"),
+		})
+	}
+	return paths, nil
+}
+
+var hermesChainID = regexp.MustCompile(`(?m)^id = '([^']*)'`)
+
+// HermesChainIDs lists the ids of the chains in hermes' config of a generated config.
+func HermesChainIDs(generatedConfigDir string) ([]string, error) {
+	bz, err := ioutil.ReadFile(filepath.Join(generatedConfigDir, hermesConfigFile))
+	if err != nil {
+		return nil, err
+	}
+	var chainIDs []string
+	for _, match := range hermesChainID.FindAllSubmatch(bz, -1) {
+		chainIDs = append(chainIDs, string(match[1]))
+	}
+	return chainIDs, nil
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
@@ -0,0 +1,213 @@
+package generate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Jeffail/gabs/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// mergeStrategy combines a node from a template with the node at the same path in the generated compose file.
+type mergeStrategy func(path []string, destination, source *yaml.Node) (*yaml.Node, error)
+
+// composeMergeStrategies choose how parts of a compose file are merged, the first pattern matching a path is used.
+// A * in a pattern matches any key. Other paths are merged with mergeNodes.
+var composeMergeStrategies = []struct {
+	pattern  []string
+	strategy mergeStrategy
+}{
+	// two templates defining the same service would silently replace parts of each other's config
+	{pattern: []string{"services", "*"}, strategy: rejectConflict},
+	// image overrides are recorded as the config is generated, the latest is kept
+	{pattern: []string{imageOverridesKey, "*"}, strategy: overwrite},
+}
+
+// ComposeChange is how merging a template changed the generated compose file.
+type ComposeChange struct {
+	// Source names what was merged, eg the template mage/v0.16.
+	Source string
+	Before []byte
+	After  []byte
+}
+
+// composeChanges, when set, collects the changes made to generated compose files.
+var composeChanges *[]ComposeChange
+
+// RecordComposeChanges runs fn and returns the changes it made to generated compose files, in order.
+func RecordComposeChanges(fn func() error) ([]ComposeChange, error) {
+	var changes []ComposeChange
+	composeChanges = &changes
+	defer func() { composeChanges = nil }()
+	err := fn()
+	return changes, err
+}
+
+// mergeCompose merges a compose file into the generated compose file, creating it if it doesn't exist.
+// Maps are merged key by key and lists keep the unique items of both. It errors if the files define the same service,
+// set a different value for the same field, or publish the same host port.
+// The merge is done on yaml nodes so the comments and key order of the templates are kept.
+func mergeCompose(source *yaml.Node, sourceName, destinationFileName string) error {
+	before, err := ioutil.ReadFile(destinationFileName)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	destination, err := importYAMLNode(destinationFileName)
+	if err != nil {
+		return err
+	}
+	merged, err := mergeNodes(nil, destination, source)
+	if err != nil {
+		return fmt.Errorf("could not merge %s into the generated compose file: %w", sourceName, err)
+	}
+	compose := map[string]interface{}{}
+	if err := merged.Decode(&compose); err != nil {
+		return err
+	}
+	if err := checkHostPorts(gabs.Wrap(compose)); err != nil {
+		return fmt.Errorf("could not merge %s into the generated compose file: %w", sourceName, err)
+	}
+	if err := exportYAMLNode(destinationFileName, merged); err != nil {
+		return err
+	}
+	if composeChanges != nil {
+		after, err := ioutil.ReadFile(destinationFileName)
+		if err != nil {
+			return err
+		}
+		*composeChanges = append(*composeChanges, ComposeChange{Source: sourceName, Before: before, After: after})
+	}
+	return nil
+}
+
+// mergeNodes merges the node at a path using its strategy, or by default merges maps key by key,
+// appends the items of lists that aren't already present, and requires other values to be equal.
+func mergeNodes(path []string, destination, source *yaml.Node) (*yaml.Node, error) {
+	for _, s := range composeMergeStrategies {
+		if matchPath(s.pattern, path) {
+			return s.strategy(path, destination, source)
+		}
+	}
+	switch source.Kind {
+	case yaml.MappingNode:
+		if destination.Kind != yaml.MappingNode {
+			return nil, conflictError(path, destination, source)
+		}
+		for i := 0; i+1 < len(source.Content); i += 2 {
+			key, value := source.Content[i], source.Content[i+1]
+			existing := mappingValue(destination, key.Value)
+			if existing == nil {
+				// the key node carries the comments written above it in the template
+				destination.Content = append(destination.Content, key, value)
+				continue
+			}
+			merged, err := mergeNodes(append(append([]string{}, path...), key.Value), *existing, value)
+			if err != nil {
+				return nil, err
+			}
+			*existing = merged
+		}
+		return destination, nil
+	case yaml.SequenceNode:
+		if destination.Kind != yaml.SequenceNode {
+			return nil, conflictError(path, destination, source)
+		}
+		for _, item := range source.Content {
+			if !containsNode(destination.Content, item) {
+				destination.Content = append(destination.Content, item)
+			}
+		}
+		return destination, nil
+	default:
+		if !nodesEqual(destination, source) {
+			return nil, conflictError(path, destination, source)
+		}
+		return destination, nil
+	}
+}
+
+// rejectConflict is a mergeStrategy for values that must only be set once.
+func rejectConflict(path []string, _, _ *yaml.Node) (*yaml.Node, error) {
+	if len(path) == 2 && path[0] == "services" {
+		return nil, fmt.Errorf("service %s is already defined by another template", path[1])
+	}
+	return nil, fmt.Errorf("%s is already set", strings.Join(path, "."))
+}
+
+// overwrite is a mergeStrategy that replaces the existing value.
+func overwrite(_ []string, _, source *yaml.Node) (*yaml.Node, error) {
+	return source, nil
+}
+
+func conflictError(path []string, destination, source *yaml.Node) error {
+	return fmt.Errorf("conflicting values for %s: %v and %v", strings.Join(path, "."), decodeNode(destination), decodeNode(source))
+}
+
+// mappingValue returns a pointer to the slot holding the value of a key in a mapping node, or nil if it's not set.
+func mappingValue(mapping *yaml.Node, key string) **yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return &mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func matchPath(pattern, path []string) bool {
+	if len(pattern) != len(path) {
+		return false
+	}
+	for i := range pattern {
+		if pattern[i] != "*" && pattern[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func containsNode(nodes []*yaml.Node, node *yaml.Node) bool {
+	for _, n := range nodes {
+		if nodesEqual(n, node) {
+			return true
+		}
+	}
+	return false
+}
+
+// nodesEqual compares the values of nodes, ignoring their comments and styles.
+func nodesEqual(a, b *yaml.Node) bool {
+	return nodeEquals(a, decodeNode(b))
+}
+
+// checkHostPorts errors if more than one service in a compose file publishes the same host port.
+func checkHostPorts(compose *gabs.Container) error {
+	services := compose.Search("services").ChildrenMap()
+	var names []string
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	published := map[string]string{}
+	for _, name := range names {
+		for _, p := range services[name].Search("ports").Children() {
+			host, container := splitPortMapping(fmt.Sprint(p.Data()))
+			if host == "" {
+				// the engine picks a free host port
+				continue
+			}
+			protocol := "tcp"
+			if i := strings.Index(container, "/"); i >= 0 {
+				protocol = container[i+1:]
+			}
+			key := host + "/" + protocol
+			if other, found := published[key]; found && other != name {
+				return fmt.Errorf("host port %s is published by both %s and %s, change one with a port override", host, other, name)
+			}
+			published[key] = name
+		}
+	}
+	return nil
+}
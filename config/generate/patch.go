@@ -0,0 +1,144 @@
+package generate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/Jeffail/gabs/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// GenesisPatch is a list of edits made to a generated genesis.json, in order.
+//
+// Paths are dot separated, with array elements selected by index, eg app_state.auth.accounts.0.
+// Keys containing a dot can be escaped with ~1. Patch files are a yaml list of operations.
+type GenesisPatch []GenesisPatchOperation
+
+// GenesisPatchOperation is a single edit to a genesis file. Exactly one of Set, Merge, or Delete must be used.
+type GenesisPatchOperation struct {
+	// Set replaces the value at a path, creating any missing objects along the way.
+	Set string `yaml:"set"`
+	// Merge merges an object into the object at a path. Values in the patch overwrite existing ones.
+	Merge string `yaml:"merge"`
+	// Delete removes the value at a path.
+	Delete string `yaml:"delete"`
+	// Value is the value to set or merge.
+	Value interface{} `yaml:"value"`
+}
+
+// LoadGenesisPatch reads a yaml genesis patch file.
+func LoadGenesisPatch(filename string) (GenesisPatch, error) {
+	bz, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(bz))
+	decoder.KnownFields(true)
+	var patch GenesisPatch
+	if err := decoder.Decode(&patch); err != nil {
+		return nil, fmt.Errorf("could not parse genesis patch %s: %w", filename, err)
+	}
+	for i, op := range patch {
+		if err := op.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid operation %d in %s: %w", i, filename, err)
+		}
+	}
+	return patch, nil
+}
+
+// Validate checks the operation has one action and a value if it needs one.
+func (op GenesisPatchOperation) Validate() error {
+	actions := 0
+	for _, path := range []string{op.Set, op.Merge, op.Delete} {
+		if path != "" {
+			actions++
+		}
+	}
+	if actions != 1 {
+		return fmt.Errorf("operation must have exactly one of set, merge, or delete")
+	}
+	if op.Delete != "" && op.Value != nil {
+		return fmt.Errorf("delete operations don't take a value")
+	}
+	if op.Merge != "" {
+		if _, ok := op.Value.(map[string]interface{}); !ok {
+			return fmt.Errorf("merge value must be an object")
+		}
+	}
+	return nil
+}
+
+// Apply makes the patch's edits to a genesis document.
+func (patch GenesisPatch) Apply(genesis *gabs.Container) error {
+	for _, op := range patch {
+		switch {
+		case op.Set != "":
+			if _, err := genesis.SetP(op.Value, op.Set); err != nil {
+				return fmt.Errorf("could not set %s: %w", op.Set, err)
+			}
+		case op.Merge != "":
+			target := genesis.Path(op.Merge)
+			if target.Data() == nil {
+				if _, err := genesis.SetP(op.Value, op.Merge); err != nil {
+					return fmt.Errorf("could not merge %s: %w", op.Merge, err)
+				}
+				continue
+			}
+			if _, ok := target.Data().(map[string]interface{}); !ok {
+				return fmt.Errorf("could not merge %s: existing value is not an object", op.Merge)
+			}
+			err := target.MergeFn(gabs.Wrap(op.Value), func(destination, source interface{}) interface{} {
+				// overwrite any non-object values with the patch's version
+				return source
+			})
+			if err != nil {
+				return fmt.Errorf("could not merge %s: %w", op.Merge, err)
+			}
+		case op.Delete != "":
+			if err := genesis.DeleteP(op.Delete); err != nil {
+				return fmt.Errorf("could not delete %s: %w", op.Delete, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ErrGenesisNotValidated is returned by genesis validators for genesis files in a format they can't check.
+var ErrGenesisNotValidated = errors.New("genesis not validated")
+
+// validateEdit runs a validator on an edited genesis. Genesis files the validator can't check are written with a warning.
+func validateEdit(genesisJSON []byte, validate func(genesisJSON []byte) error) error {
+	if validate == nil {
+		return nil
+	}
+	err := validate(genesisJSON)
+	if errors.Is(err, ErrGenesisNotValidated) {
+		fmt.Printf("WARNING: %s\n", err)
+		return nil
+	}
+	return err
+}
+
+// PatchGenesis applies a patch to the genesis.json of a generated config's mage node.
+// If validate is not nil it's called with the patched genesis, which is only written if it succeeds or returns ErrGenesisNotValidated.
+func PatchGenesis(patch GenesisPatch, generatedConfigDir string, validate func(genesisJSON []byte) error) error {
+	genesisFile, err := findGenesisFile(filepath.Join(generatedConfigDir, "mage", "initstate"))
+	if err != nil {
+		return err
+	}
+	genesis, err := readJSON(genesisFile)
+	if err != nil {
+		return err
+	}
+	if err := patch.Apply(genesis); err != nil {
+		return err
+	}
+	bz := genesis.BytesIndent("", "  ")
+	if err := validateEdit(bz, validate); err != nil {
+		return fmt.Errorf("patched genesis is invalid: %w", err)
+	}
+	return ioutil.WriteFile(genesisFile, bz, 0644)
+}
@@ -0,0 +1,213 @@
+package generate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"github.com/otiai10/copy"
+)
+
+// PostStartHook is run once a testnet containing the service has been started.
+type PostStartHook func(generatedConfigDir string) error
+
+// Service is a part of a testnet that config can be generated for, such as a chain node or a relayer.
+type Service interface {
+	// Name selects the service on the command line and in topology files.
+	Name() string
+	// TemplateDir is the directory in ConfigTemplatesDir that holds the service's templates.
+	TemplateDir() string
+	// Versions lists the available template versions. It's empty for services with a single unversioned template.
+	Versions() ([]string, error)
+	// DefaultVersion is the template version used when none is specified.
+	DefaultVersion() string
+	// Dependencies lists the names of the services this one needs to run.
+	Dependencies() []string
+	// Generate writes the service's config into the generated config folder.
+	Generate(config ServiceConfig, generatedConfigDir string) error
+	// PostStartHooks are run in order after the testnet has started.
+	PostStartHooks() []PostStartHook
+}
+
+// TemplateService is a Service that copies a template directory and merges its docker-compose.yaml into the generated one.
+type TemplateService struct {
+	ServiceName string
+	// Dir is the template directory. Versioned templates are in subdirectories named after the version.
+	Dir string
+	// Default is the default template version. Leave it empty for unversioned templates.
+	Default string
+	// OutputDir is the directory name used in the generated config folder. Defaults to Dir.
+	OutputDir string
+	Requires  []string
+	// SkipCompose copies the templates without merging their docker-compose.yaml, eg when a hook adds the service later.
+	SkipCompose bool
+	Hooks       []PostStartHook
+}
+
+var _ Service = TemplateService{}
+
+func (s TemplateService) Name() string                    { return s.ServiceName }
+func (s TemplateService) TemplateDir() string             { return s.Dir }
+func (s TemplateService) DefaultVersion() string          { return s.Default }
+func (s TemplateService) Dependencies() []string          { return s.Requires }
+func (s TemplateService) PostStartHooks() []PostStartHook { return s.Hooks }
+
+// Versions lists the subdirectories of the template directory.
+func (s TemplateService) Versions() ([]string, error) {
+	if s.Default == "" {
+		return nil, nil
+	}
+	entries, err := ioutil.ReadDir(filepath.Join(ConfigTemplatesDir, s.Dir))
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, e := range entries {
+		if e.IsDir() {
+			versions = append(versions, e.Name())
+		}
+	}
+	return versions, nil
+}
+
+func (s TemplateService) Generate(config ServiceConfig, generatedConfigDir string) error {
+	templatePath := s.Dir
+	if s.Default != "" {
+		version := config.Version
+		if version == "" {
+			version = s.Default
+		}
+		templatePath = filepath.Join(s.Dir, version)
+	}
+	outputDir := s.OutputDir
+	if outputDir == "" {
+		outputDir = s.Dir
+	}
+	if s.SkipCompose {
+		if err := copy.Copy(filepath.Join(ConfigTemplatesDir, templatePath), filepath.Join(generatedConfigDir, outputDir)); err != nil {
+			return err
+		}
+		if config.Image != "" {
+			// the image is used once the service is started, after the config is generated
+			return recordImageOverride(generatedConfigDir, s.ServiceName, config.Image)
+		}
+		return nil
+	}
+	return generateFromTemplate(templatePath, outputDir, generatedConfigDir, config)
+}
+
+var services = map[string]Service{}
+
+// RegisterService makes a service available for config generation. It panics if the name is already taken.
+func RegisterService(s Service) {
+	if _, found := services[s.Name()]; found {
+		panic(fmt.Sprintf("service %s already registered", s.Name()))
+	}
+	services[s.Name()] = s
+}
+
+// GetService returns the registered service with the given name.
+func GetService(name string) (Service, bool) {
+	s, found := services[name]
+	return s, found
+}
+
+// ServiceNames returns the names of all registered services in alphabetical order.
+func ServiceNames() []string {
+	var names []string
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ResolveServices returns the named services plus any services they depend on.
+// Dependencies are ordered before the services that need them.
+func ResolveServices(names []string) ([]Service, error) {
+	var resolved []Service
+	visited := map[string]bool{}
+	visiting := map[string]bool{}
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		if visited[name] {
+			return nil
+		}
+		if visiting[name] {
+			return fmt.Errorf("circular dependency on service '%s'", name)
+		}
+		s, found := services[name]
+		if !found {
+			return fmt.Errorf("unknown service '%s'", name)
+		}
+		visiting[name] = true
+		for _, dep := range s.Dependencies() {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		visiting[name] = false
+		visited[name] = true
+		resolved = append(resolved, s)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return resolved, nil
+}
+
+// GenerateServices writes config for the listed services, and any services they depend on, into the generated config folder.
+// Dependencies not listed are generated with their default config.
+func GenerateServices(configs []ServiceConfig, generatedConfigDir string) error {
+	var names []string
+	byName := map[string]ServiceConfig{}
+	for _, c := range configs {
+		names = append(names, c.Name)
+		byName[c.Name] = c
+	}
+	resolved, err := ResolveServices(names)
+	if err != nil {
+		return err
+	}
+	for _, s := range resolved {
+		config, found := byName[s.Name()]
+		if !found {
+			fmt.Printf("including service %s as a dependency\n", s.Name())
+			config = ServiceConfig{Name: s.Name()}
+		}
+		if err := s.Generate(config, generatedConfigDir); err != nil {
+			return fmt.Errorf("could not generate config for %s: %w", s.Name(), err)
+		}
+	}
+	return nil
+}
+
+// RunPostStartHooks runs the hooks of the listed services, and any services they depend on, with dependencies first.
+// extraHooks are run after a service's own hooks, keyed by service name. They allow callers to add steps that need more
+// than the generated config, such as running containers.
+func RunPostStartHooks(names []string, generatedConfigDir string, extraHooks map[string][]PostStartHook) error {
+	for name := range extraHooks {
+		if _, found := services[name]; !found {
+			return fmt.Errorf("post start hook for unknown service '%s'", name)
+		}
+	}
+	resolved, err := ResolveServices(names)
+	if err != nil {
+		return err
+	}
+	for _, s := range resolved {
+		hooks := append(append([]PostStartHook{}, s.PostStartHooks()...), extraHooks[s.Name()]...)
+		for _, hook := range hooks {
+			if err := hook(generatedConfigDir); err != nil {
+				return fmt.Errorf("post start hook for %s failed: %w", s.Name(), err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package generate
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Jeffail/gabs/v2"
+)
+
+// TemplateInfo describes a version of a service's templates.
+type TemplateInfo struct {
+	Service string `json:"service" yaml:"service"`
+	// Version is empty for services with a single unversioned template.
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+	// Image is run by the template's chain node, or its first service if it has no node.
+	Image string `json:"image,omitempty" yaml:"image,omitempty"`
+	// ChainID, Denom, and HomeDir are read from the genesis in the template's initstate folder, if it has one.
+	ChainID string `json:"chain_id,omitempty" yaml:"chain_id,omitempty"`
+	Denom   string `json:"denom,omitempty" yaml:"denom,omitempty"`
+	// HomeDir is the folder in initstate holding the node's config, eg .mage or .kvd.
+	HomeDir string `json:"home_dir,omitempty" yaml:"home_dir,omitempty"`
+}
+
+// TemplateProblem is an inconsistency found in a template, such as a volume mounting a file the template doesn't contain.
+type TemplateProblem struct {
+	Service string
+	Version string
+	Message string
+	// Warning marks a check that couldn't be made, such as a genesis in a format the validator can't decode.
+	// Warnings don't fail verification.
+	Warning bool
+}
+
+func (p TemplateProblem) String() string {
+	if p.Version == "" {
+		return fmt.Sprintf("%s: %s", p.Service, p.Message)
+	}
+	return fmt.Sprintf("%s %s: %s", p.Service, p.Version, p.Message)
+}
+
+// CheckVersion returns an error listing the available versions if a service has no templates for a version.
+func CheckVersion(serviceName, version string) error {
+	service, found := GetService(serviceName)
+	if !found {
+		return fmt.Errorf("unknown service '%s'", serviceName)
+	}
+	if service.DefaultVersion() == "" {
+		if version != "" {
+			return fmt.Errorf("service '%s' does not have versioned templates", serviceName)
+		}
+		return nil
+	}
+	versions, err := service.Versions()
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		if v == version {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown %s template version '%s', must be one of %s", serviceName, version, strings.Join(versions, ", "))
+}
+
+// ListTemplates describes every template of the registered services, in order of service name then version.
+func ListTemplates() ([]TemplateInfo, error) {
+	var templates []TemplateInfo
+	err := forEachTemplate(func(service Service, version, dir string) error {
+		info, err := templateInfo(service, version, dir)
+		if err != nil {
+			return fmt.Errorf("could not read %s template %s: %w", service.Name(), version, err)
+		}
+		templates = append(templates, info)
+		return nil
+	})
+	return templates, err
+}
+
+// VerifyTemplates checks that each template's genesis is valid json and that the volumes in its docker-compose.yaml exist.
+// validateGenesis, if not nil, is called with the contents of each genesis for further checks. Genesis files it returns
+// ErrGenesisNotValidated for are reported as warnings.
+func VerifyTemplates(validateGenesis func(serviceName string, genesisJSON []byte) error) ([]TemplateProblem, error) {
+	var problems []TemplateProblem
+	err := forEachTemplate(func(service Service, version, dir string) error {
+		messages, warnings := verifyTemplate(service, dir, validateGenesis)
+		for _, message := range messages {
+			problems = append(problems, TemplateProblem{Service: service.Name(), Version: version, Message: message})
+		}
+		for _, message := range warnings {
+			problems = append(problems, TemplateProblem{Service: service.Name(), Version: version, Message: message, Warning: true})
+		}
+		return nil
+	})
+	return problems, err
+}
+
+// forEachTemplate calls fn with the directory of every version of each registered service's templates.
+func forEachTemplate(fn func(service Service, version, dir string) error) error {
+	for _, name := range ServiceNames() {
+		service := services[name]
+		versions := []string{""}
+		if service.DefaultVersion() != "" {
+			var err error
+			if versions, err = service.Versions(); err != nil {
+				return err
+			}
+			sort.Strings(versions)
+		}
+		for _, version := range versions {
+			if err := fn(service, version, filepath.Join(ConfigTemplatesDir, service.TemplateDir(), version)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func templateInfo(service Service, version, dir string) (TemplateInfo, error) {
+	info := TemplateInfo{Service: service.Name(), Version: version}
+	composeFile := filepath.Join(dir, "docker-compose.yaml")
+	if _, err := os.Stat(composeFile); err == nil {
+		compose, err := importYAML(composeFile)
+		if err != nil {
+			return TemplateInfo{}, err
+		}
+		info.Image = templateNodeImage(compose)
+	}
+
+	genesisFile, err := findGenesisFile(filepath.Join(dir, "initstate"))
+	if err != nil {
+		// templates without a chain node have no genesis
+		return info, nil
+	}
+	genesis, err := readJSON(genesisFile)
+	if err != nil {
+		return TemplateInfo{}, err
+	}
+	info.HomeDir = filepath.Base(filepath.Dir(filepath.Dir(genesisFile)))
+	info.ChainID, _ = genesis.Path("chain_id").Data().(string)
+	// binance chain names the staking module stake
+	for _, module := range []string{"staking", "stake"} {
+		if denom, ok := genesis.Search("app_state", module, "params", "bond_denom").Data().(string); ok {
+			info.Denom = denom
+			break
+		}
+	}
+	return info, nil
+}
+
+// templateNodeImage returns the image of the compose service that mounts a node config folder,
+// falling back to the first service with an image.
+func templateNodeImage(compose *gabs.Container) string {
+	services := compose.Search("services").ChildrenMap()
+	var names []string
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fallback := ""
+	for _, name := range names {
+		image, ok := services[name].Search("image").Data().(string)
+		if !ok {
+			continue
+		}
+		if fallback == "" {
+			fallback = image
+		}
+		for _, v := range composeVolumes(services[name]) {
+			if parts := strings.Split(v, ":"); len(parts) >= 2 && path.Base(parts[1]) == "config" {
+				return image
+			}
+		}
+	}
+	return fallback
+}
+
+// verifyTemplate lists the problems found in a template directory.
+func verifyTemplate(service Service, dir string, validateGenesis func(serviceName string, genesisJSON []byte) error) (problems, warnings []string) {
+	if _, err := os.Stat(dir); err != nil {
+		return []string{err.Error()}, nil
+	}
+
+	composeFile := filepath.Join(dir, "docker-compose.yaml")
+	if _, err := os.Stat(composeFile); err == nil {
+		compose, err := importYAML(composeFile)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("could not parse docker-compose.yaml: %s", err))
+		} else {
+			problems = append(problems, verifyComposeVolumes(service, dir, compose)...)
+		}
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "initstate", ".*", "config", "genesis.json"))
+	if err != nil {
+		return append(problems, err.Error()), warnings
+	}
+	for _, genesisFile := range matches {
+		relative, _ := filepath.Rel(dir, genesisFile)
+		bz, err := ioutil.ReadFile(genesisFile)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if _, err := gabs.ParseJSON(bz); err != nil {
+			problems = append(problems, fmt.Sprintf("%s is not valid json: %s", relative, err))
+			continue
+		}
+		if validateGenesis == nil {
+			continue
+		}
+		if err := validateGenesis(service.Name(), bz); errors.Is(err, ErrGenesisNotValidated) {
+			warnings = append(warnings, fmt.Sprintf("%s was only checked to be valid json: %s", relative, err))
+		} else if err != nil {
+			problems = append(problems, fmt.Sprintf("%s could not be decoded: %s", relative, err))
+		}
+	}
+	return problems, warnings
+}
+
+// verifyComposeVolumes checks the host paths of a template's bind mounts exist once it's copied into the generated config.
+// Templates are copied to a folder named after their template directory, so a volume "./mage/initstate" refers to "initstate" in the template.
+func verifyComposeVolumes(service Service, dir string, compose *gabs.Container) []string {
+	var problems []string
+	services := compose.Search("services").ChildrenMap()
+	var names []string
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	prefix := "./" + service.TemplateDir()
+	for _, name := range names {
+		for _, v := range composeVolumes(services[name]) {
+			host := strings.Split(v, ":")[0]
+			if !strings.HasPrefix(host, ".") {
+				// named volumes and absolute paths aren't part of the template
+				continue
+			}
+			cleaned := path.Clean(host)
+			if cleaned != path.Clean(prefix) && !strings.HasPrefix(cleaned, path.Clean(prefix)+"/") {
+				problems = append(problems, fmt.Sprintf("service %s mounts %s, which is outside the template", name, host))
+				continue
+			}
+			relative := strings.TrimPrefix(strings.TrimPrefix(cleaned, path.Clean(prefix)), "/")
+			if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(relative))); err != nil {
+				problems = append(problems, fmt.Sprintf("service %s mounts %s, but the template has no %s", name, host, relative))
+			}
+		}
+	}
+	return problems
+}
+
+// composeVolumes lists the short syntax volumes of a compose service, eg "./mage/initstate/.mage/config:/root/.mage/config".
+func composeVolumes(service *gabs.Container) []string {
+	var volumes []string
+	for _, v := range service.Search("volumes").Children() {
+		if s, ok := v.Data().(string); ok {
+			volumes = append(volumes, s)
+		}
+	}
+	return volumes
+}
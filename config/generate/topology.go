@@ -0,0 +1,78 @@
+package generate
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Topology describes the services that make up a testnet.
+// It's read from a kvtool.yaml file so testnet recipes can be checked into a repo.
+type Topology struct {
+	Services []ServiceConfig `yaml:"services"`
+}
+
+// ServiceConfig selects a service to include in a testnet and the changes to make to its template.
+type ServiceConfig struct {
+	// Name of the service, eg "mage" or "binance".
+	Name string `yaml:"name"`
+	// Version is the template version to use. It defaults to the latest template for the service.
+	Version string `yaml:"version"`
+	// Ports overrides the published host ports, keyed by container port. eg "26657": "36657"
+	Ports map[string]string `yaml:"ports"`
+	// Env adds environment variables to each of the service's containers.
+	Env map[string]string `yaml:"env"`
+	// Image overrides the image run by each of the service's containers, eg mage/deputy:v0.5.0
+	Image string `yaml:"image"`
+}
+
+// LoadTopology reads and validates a topology file.
+func LoadTopology(filename string) (Topology, error) {
+	bz, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return Topology{}, err
+	}
+	var topology Topology
+	decoder := yaml.NewDecoder(bytes.NewReader(bz))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&topology); err != nil {
+		return Topology{}, fmt.Errorf("could not parse topology file %s: %w", filename, err)
+	}
+	if err := topology.Validate(); err != nil {
+		return Topology{}, fmt.Errorf("invalid topology file %s: %w", filename, err)
+	}
+	return topology, nil
+}
+
+// Validate checks the topology only references known services and template versions, and each service at most once.
+func (t Topology) Validate() error {
+	if len(t.Services) == 0 {
+		return fmt.Errorf("no services listed")
+	}
+	seen := map[string]bool{}
+	for _, s := range t.Services {
+		if _, ok := GetService(s.Name); !ok {
+			return fmt.Errorf("unknown service '%s'", s.Name)
+		}
+		if seen[s.Name] {
+			return fmt.Errorf("service '%s' listed more than once", s.Name)
+		}
+		seen[s.Name] = true
+		if s.Version != "" {
+			if err := CheckVersion(s.Name, s.Version); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GenerateFromTopology writes config for all the services in a topology, and any services they depend on, into the generated config folder.
+func GenerateFromTopology(topology Topology, generatedConfigDir string) error {
+	if err := topology.Validate(); err != nil {
+		return err
+	}
+	return GenerateServices(topology.Services, generatedConfigDir)
+}
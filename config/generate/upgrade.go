@@ -0,0 +1,59 @@
+package generate
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// TemplateImage returns the image a compose service runs in a version of a service's templates.
+func TemplateImage(serviceName, version, composeService string) (string, error) {
+	if err := CheckVersion(serviceName, version); err != nil {
+		return "", err
+	}
+	service, _ := GetService(serviceName)
+	templateDir := filepath.Join(ConfigTemplatesDir, service.TemplateDir(), version)
+	compose, err := importYAML(filepath.Join(templateDir, "docker-compose.yaml"))
+	if err != nil {
+		return "", err
+	}
+	image, ok := compose.Search("services", composeService, "image").Data().(string)
+	if !ok {
+		return "", fmt.Errorf("the %s %s template has no image for %s", serviceName, version, composeService)
+	}
+	return image, nil
+}
+
+// PersistNodeData mounts a chain node's data directory from the generated config folder, next to its config.
+// By default the data is stored in the container, so it's lost when the container is recreated, eg to change its image.
+func PersistNodeData(generatedConfigDir, composeService string) error {
+	composeFile := filepath.Join(generatedConfigDir, "docker-compose.yaml")
+	compose, err := importYAML(composeFile)
+	if err != nil {
+		return err
+	}
+	service := compose.Search("services", composeService)
+	volumes, _ := service.Search("volumes").Data().([]interface{})
+	for _, v := range volumes {
+		parts := strings.Split(fmt.Sprint(v), ":")
+		if len(parts) < 2 || path.Base(parts[1]) != "config" {
+			continue
+		}
+		hostDir := path.Join(path.Dir(parts[0]), "data")
+		if strings.HasPrefix(parts[0], "./") {
+			// path.Join drops the leading ./ that compose needs to treat the path as a bind mount
+			hostDir = "./" + hostDir
+		}
+		if err := os.MkdirAll(filepath.Join(generatedConfigDir, filepath.FromSlash(hostDir)), os.ModePerm); err != nil {
+			return err
+		}
+		volumes = append(volumes, hostDir+":"+path.Join(path.Dir(parts[1]), "data"))
+		if _, err := service.Set(volumes, "volumes"); err != nil {
+			return err
+		}
+		return exportYAML(composeFile, compose)
+	}
+	return fmt.Errorf("could not find the config volume of the %s service", composeService)
+}
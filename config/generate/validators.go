@@ -0,0 +1,194 @@
+package generate
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/otiai10/copy"
+)
+
+// MageNodeService is the compose service of the mage node in the mage templates.
+const MageNodeService = "magenode"
+
+// ValidatorNode is a mage validator in a generated config.
+type ValidatorNode struct {
+	// ComposeService is the name of the node's service in the generated docker-compose.yaml.
+	ComposeService string
+	// Home is the node's home directory in the generated config folder, eg mage/validators/node1.
+	Home string
+}
+
+// ConfigDir is the node's config directory, containing its keys and genesis.
+func (n ValidatorNode) ConfigDir() string {
+	return filepath.Join(n.Home, "config")
+}
+
+// AddValidatorNodes adds count-1 validator nodes alongside the mage node in a generated config, so it has count validators.
+// Each node gets a compose service, magenode-1, magenode-2, etc, that runs the same image and command as the mage node.
+// The new nodes' homes are empty apart from a config folder, they need initializing and their genesis setting up before they can start.
+// The first node returned is the original mage node.
+func AddValidatorNodes(generatedConfigDir string, count int) ([]ValidatorNode, error) {
+	genesisFile, err := findGenesisFile(filepath.Join(generatedConfigDir, "mage", "initstate"))
+	if err != nil {
+		return nil, err
+	}
+	nodes := []ValidatorNode{{ComposeService: MageNodeService, Home: filepath.Dir(filepath.Dir(genesisFile))}}
+
+	composeFile := filepath.Join(generatedConfigDir, "docker-compose.yaml")
+	compose, err := importYAML(composeFile)
+	if err != nil {
+		return nil, err
+	}
+	mageNode := compose.Search("services", MageNodeService)
+	if mageNode.Data() == nil {
+		return nil, fmt.Errorf("no %s service in %s", MageNodeService, composeFile)
+	}
+	containerConfigDir := ""
+	if volumes, ok := mageNode.Search("volumes").Data().([]interface{}); ok {
+		for _, v := range volumes {
+			parts := strings.Split(fmt.Sprint(v), ":")
+			if len(parts) >= 2 && filepath.Base(parts[1]) == "config" {
+				containerConfigDir = parts[1]
+			}
+		}
+	}
+	if containerConfigDir == "" {
+		return nil, fmt.Errorf("could not find the config volume of the %s service", MageNodeService)
+	}
+
+	for i := 1; i < count; i++ {
+		node := ValidatorNode{
+			ComposeService: fmt.Sprintf("%s-%d", MageNodeService, i),
+			Home:           filepath.Join(generatedConfigDir, "mage", "validators", fmt.Sprintf("node%d", i)),
+		}
+		if err := os.MkdirAll(node.ConfigDir(), os.ModePerm); err != nil {
+			return nil, err
+		}
+		relConfigDir, err := filepath.Rel(generatedConfigDir, node.ConfigDir())
+		if err != nil {
+			return nil, err
+		}
+		// only the first node's ports are published, the others are reached through it
+		service := map[string]interface{}{
+			"image":   mageNode.Search("image").Data(),
+			"command": mageNode.Search("command").Data(),
+			"volumes": []interface{}{
+				"./" + filepath.ToSlash(relConfigDir) + ":" + containerConfigDir,
+				"./" + filepath.ToSlash(filepath.Join(filepath.Dir(relConfigDir), "keyring-test")) + ":" + path.Join(path.Dir(containerConfigDir), "keyring-test"),
+			},
+		}
+		if _, err := compose.Set(service, "services", node.ComposeService); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, exportYAML(composeFile, compose)
+}
+
+// CopyNodeConfig copies the first node's config files into the other nodes' config folders.
+// Keys, the genesis, and gentxs are node specific so they're not copied.
+func CopyNodeConfig(nodes []ValidatorNode) error {
+	entries, err := ioutil.ReadDir(nodes[0].ConfigDir())
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes[1:] {
+		for _, e := range entries {
+			switch e.Name() {
+			case "priv_validator_key.json", "node_key.json", "genesis.json", "gentx", "addrbook.json":
+				continue
+			}
+			if err := copy.Copy(filepath.Join(nodes[0].ConfigDir(), e.Name()), filepath.Join(node.ConfigDir(), e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ConnectValidatorNodes gives every node the first node's genesis and configures them as persistent peers of each other.
+func ConnectValidatorNodes(nodes []ValidatorNode) error {
+	genesis, err := ioutil.ReadFile(filepath.Join(nodes[0].ConfigDir(), "genesis.json"))
+	if err != nil {
+		return err
+	}
+	var peers []string
+	for _, node := range nodes {
+		id, err := nodeID(filepath.Join(node.ConfigDir(), "node_key.json"))
+		if err != nil {
+			return err
+		}
+		peers = append(peers, fmt.Sprintf("%s@%s:26656", id, node.ComposeService))
+	}
+	for i, node := range nodes {
+		if i > 0 {
+			if err := ioutil.WriteFile(filepath.Join(node.ConfigDir(), "genesis.json"), genesis, 0644); err != nil {
+				return err
+			}
+		}
+		var otherPeers []string
+		for j, p := range peers {
+			if j != i {
+				otherPeers = append(otherPeers, p)
+			}
+		}
+		err := setTOMLValues(filepath.Join(node.ConfigDir(), "config.toml"), map[string]string{
+			"moniker":          fmt.Sprintf("%q", node.ComposeService),
+			"persistent_peers": fmt.Sprintf("%q", strings.Join(otherPeers, ",")),
+			// peers are on a docker network with private ip addresses
+			"addr_book_strict": "false",
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nodeID reads the p2p id of a node from its node_key.json.
+func nodeID(nodeKeyFile string) (string, error) {
+	bz, err := ioutil.ReadFile(nodeKeyFile)
+	if err != nil {
+		return "", err
+	}
+	var nodeKey struct {
+		PrivKey struct {
+			Value string `json:"value"`
+		} `json:"priv_key"`
+	}
+	if err := json.Unmarshal(bz, &nodeKey); err != nil {
+		return "", fmt.Errorf("could not parse %s: %w", nodeKeyFile, err)
+	}
+	privKey, err := base64.StdEncoding.DecodeString(nodeKey.PrivKey.Value)
+	if err != nil || len(privKey) != 64 {
+		return "", fmt.Errorf("invalid ed25519 key in %s", nodeKeyFile)
+	}
+	// ed25519 private keys are followed by their public key, the id is the public key's address
+	hash := sha256.Sum256(privKey[32:])
+	return hex.EncodeToString(hash[:20]), nil
+}
+
+// setTOMLValues replaces the values of top level or section keys in a toml file. Values must already be toml encoded.
+func setTOMLValues(filename string, values map[string]string) error {
+	bz, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	for key, value := range values {
+		line := regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(key) + ` = .*$`)
+		if !line.Match(bz) {
+			return fmt.Errorf("no %s setting in %s", key, filename)
+		}
+		bz = line.ReplaceAll(bz, []byte(key+" = "+strings.ReplaceAll(value, "$", "$$")))
+	}
+	return ioutil.WriteFile(filename, bz, 0644)
+}
@@ -1,38 +1,18 @@
 package generate
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"sort"
 
 	"github.com/Jeffail/gabs/v2"
 	"gopkg.in/yaml.v3"
 )
 
-func overwriteMergeYAML(sourceFileName, destinationFileName string) error {
-	source, err := importYAML(sourceFileName)
-	if err != nil {
-		return err
-	}
-	destination, err := importYAML(destinationFileName)
-	if err != nil {
-		if os.IsNotExist(err) {
-			destination = gabs.New()
-		} else {
-			return err
-		}
-	}
-	err = destination.MergeFn(source, func(destination, source interface{}) interface{} {
-		// overwrite any non-object values with the source's version
-		return source
-	})
-	if err != nil {
-		return err
-	}
-	if err := exportYAML(destinationFileName, destination); err != nil {
-		return err
-	}
-	return nil
-}
+// yaml files are edited as plain data with gabs, then synced back into the file's node tree before they're written,
+// so the comments, key order, quoting, and anchors of the templates survive in the generated config.
 
 func importYAML(filename string) (*gabs.Container, error) {
 	bz, err := ioutil.ReadFile(filename)
@@ -47,13 +27,193 @@ func importYAML(filename string) (*gabs.Container, error) {
 	return gabs.Wrap(unmarshalStructure), nil
 }
 
+// importYAMLNode reads the top level node of a yaml file. It returns an empty mapping if the file doesn't exist.
+func importYAMLNode(filename string) (*yaml.Node, error) {
+	bz, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal(bz, &document); err != nil {
+		return nil, err
+	}
+	if len(document.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, nil
+	}
+	return document.Content[0], nil
+}
+
+// exportYAML writes data to a yaml file, keeping the formatting of the parts of the existing file that are unchanged.
 func exportYAML(filename string, data *gabs.Container) error {
-	bz, err := yaml.Marshal(data.Data())
+	existing, err := importYAMLNode(filename)
 	if err != nil {
 		return err
 	}
-	if err := ioutil.WriteFile(filename, bz, 0644); err != nil {
+	return exportYAMLNode(filename, syncNode(existing, data.Data()))
+}
+
+func exportYAMLNode(filename string, node *yaml.Node) error {
+	untagMergeKeys(node)
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(4)
+	if err := encoder.Encode(node); err != nil {
 		return err
 	}
-	return nil
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+// untagMergeKeys clears the tag of merge keys (<<) so they're written as plain keys, otherwise they come out as !!merge <<.
+func untagMergeKeys(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Tag == "!!merge" {
+				node.Content[i].Tag = ""
+			}
+		}
+	}
+	for _, child := range node.Content {
+		untagMergeKeys(child)
+	}
+}
+
+// syncNode updates a yaml node tree to hold value, reusing the existing nodes where possible so their comments and styles are kept.
+func syncNode(node *yaml.Node, value interface{}) *yaml.Node {
+	if node != nil && nodeEquals(node, value) {
+		return node
+	}
+	if node == nil || node.Kind == yaml.AliasNode {
+		// an anchor's value is shared, so changes to an alias replace it with a copy
+		return newNode(value)
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if node.Kind != yaml.MappingNode {
+			return newNode(value)
+		}
+		updated := *node
+		updated.Content = nil
+		unflowEmpty(&updated, node)
+		kept := map[string]bool{}
+		inherited := map[string]interface{}{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, val := node.Content[i], node.Content[i+1]
+			if key.Tag == "!!merge" {
+				// keep merge keys, the values they provide are only added if they change
+				updated.Content = append(updated.Content, key, val)
+				var merged map[string]interface{}
+				if val.Decode(&merged) == nil {
+					for k, mv := range merged {
+						inherited[k] = mv
+					}
+				}
+				continue
+			}
+			newValue, found := v[key.Value]
+			if !found {
+				continue
+			}
+			kept[key.Value] = true
+			updated.Content = append(updated.Content, key, syncNode(val, newValue))
+		}
+		var added []string
+		for k := range v {
+			if !kept[k] {
+				added = append(added, k)
+			}
+		}
+		sort.Strings(added)
+		for _, k := range added {
+			if mv, found := inherited[k]; found && reflect.DeepEqual(mv, v[k]) {
+				continue
+			}
+			updated.Content = append(updated.Content, newNode(k), newNode(v[k]))
+		}
+		return &updated
+	case []interface{}:
+		if node.Kind != yaml.SequenceNode {
+			return newNode(value)
+		}
+		updated := *node
+		updated.Content = nil
+		unflowEmpty(&updated, node)
+		used := make([]bool, len(node.Content))
+		for i, item := range v {
+			var match *yaml.Node
+			// prefer an unchanged item, so removing or reordering items keeps their comments with them
+			for j, existing := range node.Content {
+				if !used[j] && nodeEquals(existing, item) {
+					match, used[j] = existing, true
+					break
+				}
+			}
+			// otherwise update the item in the same position, eg a port that has been moved
+			if match == nil && i < len(node.Content) && !used[i] {
+				match, used[i] = syncNode(node.Content[i], item), true
+			}
+			if match == nil {
+				match = newNode(item)
+				if len(node.Content) > 0 && node.Content[0].Kind == yaml.ScalarNode && match.Kind == yaml.ScalarNode && match.Tag == "!!str" {
+					// quote new strings like the existing items
+					match.Style = node.Content[0].Style
+				}
+			}
+			updated.Content = append(updated.Content, match)
+		}
+		return &updated
+	default:
+		if node.Kind != yaml.ScalarNode {
+			return newNode(value)
+		}
+		replacement := newNode(value)
+		updated := *node
+		updated.Value = replacement.Value
+		updated.Tag = replacement.Tag
+		if replacement.Tag != "!!str" || replacement.Style != 0 {
+			// the original quoting may not be valid for the new value
+			updated.Style = replacement.Style
+		}
+		return &updated
+	}
+}
+
+// unflowEmpty writes an empty flow style map or list, eg paths: {}, in block style once it's filled in.
+func unflowEmpty(updated, node *yaml.Node) {
+	if len(node.Content) == 0 {
+		updated.Style &^= yaml.FlowStyle
+	}
+}
+
+// newNode encodes a value as a yaml node.
+func newNode(value interface{}) *yaml.Node {
+	var node yaml.Node
+	if err := node.Encode(value); err != nil {
+		// values come from decoded yaml or json so they can always be encoded
+		panic(err)
+	}
+	return &node
+}
+
+// nodeEquals reports if a node decodes to a value.
+func nodeEquals(node *yaml.Node, value interface{}) bool {
+	var decoded interface{}
+	if err := node.Decode(&decoded); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(decoded, value)
+}
+
+// decodeNode returns the value of a node, or nil if it can't be decoded.
+func decodeNode(node *yaml.Node) interface{} {
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return nil
+	}
+	return value
 }
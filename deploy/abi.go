@@ -0,0 +1,270 @@
+package deploy
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// abiType is a solidity type that can be passed to a constructor. Tuples aren't supported.
+type abiType struct {
+	// Name is the type as written in the abi, eg uint256 or address[2].
+	Name string
+	// Kind is the base type, eg uint, address, bytes, or array.
+	Kind string
+	// Size is the bits of an int or uint, the bytes of a fixed size bytesN, or the length of a fixed size array.
+	Size int
+	// Elem is an array's element type.
+	Elem *abiType
+}
+
+// parseABIType parses a type from an abi, eg uint256, bytes32, string[], or address[2].
+func parseABIType(name string) (abiType, error) {
+	t := abiType{Name: name}
+	if strings.HasSuffix(name, "]") {
+		open := strings.LastIndex(name, "[")
+		if open < 0 {
+			return abiType{}, fmt.Errorf("invalid type %s", name)
+		}
+		elem, err := parseABIType(name[:open])
+		if err != nil {
+			return abiType{}, err
+		}
+		t.Kind, t.Elem, t.Size = "array", &elem, -1
+		if length := name[open+1 : len(name)-1]; length != "" {
+			if t.Size, err = strconv.Atoi(length); err != nil || t.Size < 1 {
+				return abiType{}, fmt.Errorf("invalid array length in %s", name)
+			}
+		}
+		return t, nil
+	}
+	switch {
+	case name == "address", name == "bool", name == "string", name == "bytes":
+		t.Kind = name
+	case name == "uint", name == "int":
+		t.Kind, t.Size = name, 256
+	case strings.HasPrefix(name, "uint"), strings.HasPrefix(name, "int"):
+		t.Kind = strings.TrimRight(name, "0123456789")
+		bits, err := strconv.Atoi(strings.TrimPrefix(name, t.Kind))
+		if err != nil || bits < 8 || bits > 256 || bits%8 != 0 {
+			return abiType{}, fmt.Errorf("invalid type %s", name)
+		}
+		t.Size = bits
+	case strings.HasPrefix(name, "bytes"):
+		size, err := strconv.Atoi(strings.TrimPrefix(name, "bytes"))
+		if err != nil || size < 1 || size > 32 {
+			return abiType{}, fmt.Errorf("invalid type %s", name)
+		}
+		t.Kind, t.Size = "fixedbytes", size
+	default:
+		return abiType{}, fmt.Errorf("type %s isn't supported", name)
+	}
+	return t, nil
+}
+
+// dynamic reports if a type's encoding is stored after the static values it's part of.
+func (t abiType) dynamic() bool {
+	switch t.Kind {
+	case "string", "bytes":
+		return true
+	case "array":
+		return t.Size < 0 || t.Elem.dynamic()
+	}
+	return false
+}
+
+// abiEncoder encodes values decoded from yaml, resolving references to deployed contracts.
+type abiEncoder struct {
+	// addresses of the contracts that can be referenced as $<name> in address arguments
+	addresses map[string]string
+}
+
+// encodeTuple encodes a list of values, such as a constructor's arguments.
+func (e abiEncoder) encodeTuple(types []abiType, values []interface{}) ([]byte, error) {
+	if len(types) != len(values) {
+		return nil, fmt.Errorf("expected %d values, got %d", len(types), len(values))
+	}
+	var heads, tails [][]byte
+	headLength := 0
+	for i, t := range types {
+		encoded, err := e.encode(t, values[i])
+		if err != nil {
+			return nil, fmt.Errorf("value %d: %w", i, err)
+		}
+		if t.dynamic() {
+			heads = append(heads, nil)
+			tails = append(tails, encoded)
+			headLength += 32
+		} else {
+			heads = append(heads, encoded)
+			tails = append(tails, nil)
+			headLength += len(encoded)
+		}
+	}
+	var out, tail []byte
+	for i, head := range heads {
+		if head == nil {
+			// dynamic values are replaced by their offset from the start of the tuple
+			head = word(big.NewInt(int64(headLength + len(tail))))
+			tail = append(tail, tails[i]...)
+		}
+		out = append(out, head...)
+	}
+	return append(out, tail...), nil
+}
+
+// encode encodes a single value of a type.
+func (e abiEncoder) encode(t abiType, value interface{}) ([]byte, error) {
+	switch t.Kind {
+	case "uint", "int":
+		i, err := toBigInt(value)
+		if err != nil {
+			return nil, err
+		}
+		limit := new(big.Int).Lsh(big.NewInt(1), uint(t.Size))
+		if t.Kind == "int" {
+			limit.Rsh(limit, 1)
+			if i.Cmp(limit) >= 0 || i.Cmp(new(big.Int).Neg(limit)) < 0 {
+				return nil, fmt.Errorf("%s is out of range for %s", i, t.Name)
+			}
+			if i.Sign() < 0 {
+				// two's complement
+				i = new(big.Int).Add(i, new(big.Int).Lsh(big.NewInt(1), 256))
+			}
+		} else if i.Sign() < 0 || i.Cmp(limit) >= 0 {
+			return nil, fmt.Errorf("%s is out of range for %s", i, t.Name)
+		}
+		return word(i), nil
+	case "address":
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected an address, got %v", value)
+		}
+		if strings.HasPrefix(s, "$") {
+			address, found := e.addresses[s[1:]]
+			if !found {
+				return nil, fmt.Errorf("%s doesn't refer to a contract deployed before this one", s)
+			}
+			s = address
+		}
+		bz, err := decodeHex(s)
+		if err != nil || len(bz) != 20 {
+			return nil, fmt.Errorf("invalid address %s", s)
+		}
+		return leftPad(bz), nil
+	case "bool":
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expected true or false, got %v", value)
+		}
+		if b {
+			return word(big.NewInt(1)), nil
+		}
+		return word(big.NewInt(0)), nil
+	case "fixedbytes":
+		bz, err := hexValue(value)
+		if err != nil {
+			return nil, err
+		}
+		if len(bz) > t.Size {
+			return nil, fmt.Errorf("%d bytes is too long for %s", len(bz), t.Name)
+		}
+		return rightPad(bz), nil
+	case "bytes", "string":
+		var bz []byte
+		if t.Kind == "string" {
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a string, got %v", value)
+			}
+			bz = []byte(s)
+		} else {
+			var err error
+			if bz, err = hexValue(value); err != nil {
+				return nil, err
+			}
+		}
+		return append(word(big.NewInt(int64(len(bz)))), rightPad(bz)...), nil
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected a list for %s, got %v", t.Name, value)
+		}
+		if t.Size >= 0 && len(items) != t.Size {
+			return nil, fmt.Errorf("expected %d items for %s, got %d", t.Size, t.Name, len(items))
+		}
+		types := make([]abiType, len(items))
+		for i := range types {
+			types[i] = *t.Elem
+		}
+		encoded, err := e.encodeTuple(types, items)
+		if err != nil {
+			return nil, err
+		}
+		if t.Size < 0 {
+			// dynamic arrays are prefixed by their length
+			encoded = append(word(big.NewInt(int64(len(items)))), encoded...)
+		}
+		return encoded, nil
+	}
+	return nil, fmt.Errorf("type %s isn't supported", t.Name)
+}
+
+// toBigInt converts a number decoded from yaml, or a decimal or 0x prefixed hex string, to an integer.
+func toBigInt(value interface{}) (*big.Int, error) {
+	switch v := value.(type) {
+	case int:
+		return big.NewInt(int64(v)), nil
+	case int64:
+		return big.NewInt(v), nil
+	case uint64:
+		return new(big.Int).SetUint64(v), nil
+	case float64:
+		return nil, fmt.Errorf("%v isn't a whole number that fits in 64 bits, write large numbers as strings", v)
+	case string:
+		base := 10
+		if strings.HasPrefix(v, "0x") {
+			v, base = v[2:], 16
+		}
+		i, ok := new(big.Int).SetString(v, base)
+		if !ok {
+			return nil, fmt.Errorf("invalid number %v", value)
+		}
+		return i, nil
+	}
+	return nil, fmt.Errorf("expected a number, got %v", value)
+}
+
+// hexValue decodes a 0x prefixed hex string.
+func hexValue(value interface{}) ([]byte, error) {
+	s, ok := value.(string)
+	if !ok || !strings.HasPrefix(s, "0x") {
+		return nil, fmt.Errorf("expected 0x prefixed hex, got %v", value)
+	}
+	return decodeHex(s)
+}
+
+// decodeHex decodes hex with or without a 0x prefix.
+func decodeHex(s string) ([]byte, error) {
+	return hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X"))
+}
+
+// word encodes a non-negative integer below 2^256 as 32 bytes.
+func word(i *big.Int) []byte {
+	return leftPad(i.Bytes())
+}
+
+func leftPad(bz []byte) []byte {
+	return append(make([]byte, 32-len(bz)), bz...)
+}
+
+// rightPad pads bytes with zeros to a multiple of 32 bytes.
+func rightPad(bz []byte) []byte {
+	padded := append([]byte{}, bz...)
+	if remainder := len(bz) % 32; remainder != 0 {
+		padded = append(padded, make([]byte, 32-remainder)...)
+	}
+	return padded
+}
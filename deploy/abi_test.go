@@ -0,0 +1,148 @@
+package deploy
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestEncodeTuple(t *testing.T) {
+	// the examples from the solidity abi specification
+	testCases := []struct {
+		name     string
+		types    []string
+		values   []interface{}
+		expected []string
+	}{
+		{
+			name:   "static",
+			types:  []string{"uint32", "bool"},
+			values: []interface{}{69, true},
+			expected: []string{
+				"0000000000000000000000000000000000000000000000000000000000000045",
+				"0000000000000000000000000000000000000000000000000000000000000001",
+			},
+		},
+		{
+			name:   "dynamic",
+			types:  []string{"bytes", "bool", "uint256[]"},
+			values: []interface{}{"0x64617665", true, []interface{}{1, 2, 3}},
+			expected: []string{
+				"0000000000000000000000000000000000000000000000000000000000000060",
+				"0000000000000000000000000000000000000000000000000000000000000001",
+				"00000000000000000000000000000000000000000000000000000000000000a0",
+				"0000000000000000000000000000000000000000000000000000000000000004",
+				"6461766500000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000000003",
+				"0000000000000000000000000000000000000000000000000000000000000001",
+				"0000000000000000000000000000000000000000000000000000000000000002",
+				"0000000000000000000000000000000000000000000000000000000000000003",
+			},
+		},
+		{
+			name:   "static and dynamic",
+			types:  []string{"uint256", "uint32[]", "bytes10", "bytes"},
+			values: []interface{}{"0x123", []interface{}{"0x456", "0x789"}, "0x31323334353637383930", "0x48656c6c6f2c20776f726c6421"},
+			expected: []string{
+				"0000000000000000000000000000000000000000000000000000000000000123",
+				"0000000000000000000000000000000000000000000000000000000000000080",
+				"3132333435363738393000000000000000000000000000000000000000000000",
+				"00000000000000000000000000000000000000000000000000000000000000e0",
+				"0000000000000000000000000000000000000000000000000000000000000002",
+				"0000000000000000000000000000000000000000000000000000000000000456",
+				"0000000000000000000000000000000000000000000000000000000000000789",
+				"000000000000000000000000000000000000000000000000000000000000000d",
+				"48656c6c6f2c20776f726c642100000000000000000000000000000000000000",
+			},
+		},
+		{
+			name:   "nested dynamic",
+			types:  []string{"uint256[][]", "string[]"},
+			values: []interface{}{[]interface{}{[]interface{}{1, 2}, []interface{}{3}}, []interface{}{"one", "two", "three"}},
+			expected: []string{
+				"0000000000000000000000000000000000000000000000000000000000000040",
+				"0000000000000000000000000000000000000000000000000000000000000140",
+				"0000000000000000000000000000000000000000000000000000000000000002",
+				"0000000000000000000000000000000000000000000000000000000000000040",
+				"00000000000000000000000000000000000000000000000000000000000000a0",
+				"0000000000000000000000000000000000000000000000000000000000000002",
+				"0000000000000000000000000000000000000000000000000000000000000001",
+				"0000000000000000000000000000000000000000000000000000000000000002",
+				"0000000000000000000000000000000000000000000000000000000000000001",
+				"0000000000000000000000000000000000000000000000000000000000000003",
+				"0000000000000000000000000000000000000000000000000000000000000003",
+				"0000000000000000000000000000000000000000000000000000000000000060",
+				"00000000000000000000000000000000000000000000000000000000000000a0",
+				"00000000000000000000000000000000000000000000000000000000000000e0",
+				"0000000000000000000000000000000000000000000000000000000000000003",
+				"6f6e650000000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000000003",
+				"74776f0000000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000000005",
+				"7468726565000000000000000000000000000000000000000000000000000000",
+			},
+		},
+		{
+			name:   "fixed size arrays and addresses",
+			types:  []string{"address[2]", "int8"},
+			values: []interface{}{[]interface{}{"$token", "0x3535353535353535353535353535353535353535"}, -1},
+			expected: []string{
+				"0000000000000000000000001000000000000000000000000000000000000001",
+				"0000000000000000000000003535353535353535353535353535353535353535",
+				"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+			},
+		},
+	}
+	encoder := abiEncoder{addresses: map[string]string{"token": "0x1000000000000000000000000000000000000001"}}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var types []abiType
+			for _, name := range tc.types {
+				abiType, err := parseABIType(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				types = append(types, abiType)
+			}
+			encoded, err := encoder.encodeTuple(types, tc.values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual, expected := hex.EncodeToString(encoded), strings.Join(tc.expected, ""); actual != expected {
+				t.Fatalf("expected\n%s\ngot\n%s", expected, actual)
+			}
+		})
+	}
+}
+
+func TestEncodeOutOfRange(t *testing.T) {
+	testCases := []struct {
+		typeName string
+		value    interface{}
+	}{
+		{"uint8", 256},
+		{"uint256", -1},
+		{"int8", 128},
+		{"int8", -129},
+		{"bytes2", "0x010203"},
+		{"address[2]", []interface{}{"0x3535353535353535353535353535353535353535"}},
+		{"address", "$unknown"},
+	}
+	for _, tc := range testCases {
+		abiType, err := parseABIType(tc.typeName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := (abiEncoder{}).encode(abiType, tc.value); err == nil {
+			t.Fatalf("expected encoding %v as %s to fail", tc.value, tc.typeName)
+		}
+	}
+}
+
+func TestParseABIType(t *testing.T) {
+	for _, name := range []string{"uint7", "uint264", "bytes0", "bytes33", "address[0]", "tuple", "(uint256,bool)"} {
+		if _, err := parseABIType(name); err == nil {
+			t.Fatalf("expected %s to be invalid", name)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+// Package deploy deploys compiled contracts to an EVM through its JSON-RPC endpoint, signing the transactions locally.
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Jeffail/gabs/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// manifestFile lists contracts to deploy, in order.
+//
+//	contracts:
+//	  - name: token
+//	    artifact: evm/artifacts/contracts/token/Token.sol/Token.json
+//	    args: ["10000000000000000000"]
+//	  - name: vault
+//	    bytecode: build/Vault.bin
+//	    abi: build/Vault.abi
+//	    args: [$token]
+type manifestFile struct {
+	Contracts []struct {
+		Name     string        `yaml:"name"`
+		Artifact string        `yaml:"artifact"`
+		Bytecode string        `yaml:"bytecode"`
+		ABI      string        `yaml:"abi"`
+		Args     []interface{} `yaml:"args"`
+	} `yaml:"contracts"`
+}
+
+// Contract is a compiled contract to deploy.
+type Contract struct {
+	Name     string
+	Bytecode []byte
+	// constructor is the types of the constructor's arguments
+	constructor []abiType
+	// Args are the constructor's arguments. Address arguments can be $<name> to use the address of a contract deployed before this one.
+	Args []interface{}
+}
+
+// LoadManifest reads the contracts listed in a manifest file, checking their constructor arguments can be encoded.
+// Files in the manifest are relative to it.
+func LoadManifest(filename string) ([]Contract, error) {
+	bz, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(bz))
+	decoder.KnownFields(true)
+	var manifest manifestFile
+	if err := decoder.Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("could not parse manifest %s: %w", filename, err)
+	}
+	if len(manifest.Contracts) == 0 {
+		return nil, fmt.Errorf("no contracts in manifest %s", filename)
+	}
+
+	dir := filepath.Dir(filename)
+	resolve := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(dir, path)
+	}
+	var contracts []Contract
+	// placeholder addresses, to check references are to earlier contracts
+	placeholders := map[string]string{}
+	for i, c := range manifest.Contracts {
+		if c.Name == "" {
+			return nil, fmt.Errorf("contract %d in %s has no name", i, filename)
+		}
+		if _, found := placeholders[c.Name]; found {
+			return nil, fmt.Errorf("more than one contract named %s in %s", c.Name, filename)
+		}
+		if (c.Artifact == "") == (c.Bytecode == "") {
+			return nil, fmt.Errorf("contract %s in %s must have exactly one of artifact or bytecode", c.Name, filename)
+		}
+		if c.Bytecode != "" && c.ABI == "" && len(c.Args) > 0 {
+			return nil, fmt.Errorf("contract %s in %s needs an abi to encode its constructor arguments", c.Name, filename)
+		}
+		contract, err := loadContract(c.Name, resolve(c.Artifact), resolve(c.Bytecode), resolve(c.ABI))
+		if err != nil {
+			return nil, fmt.Errorf("contract %s: %w", c.Name, err)
+		}
+		contract.Args = c.Args
+		if _, err := contract.deployData(abiEncoder{addresses: placeholders}); err != nil {
+			return nil, fmt.Errorf("contract %s: %w", c.Name, err)
+		}
+		placeholders[c.Name] = "0x0000000000000000000000000000000000000000"
+		contracts = append(contracts, contract)
+	}
+	return contracts, nil
+}
+
+// loadContract reads a contract's creation bytecode and constructor, from a compiled artifact (eg from hardhat, truffle,
+// or foundry), or from a bytecode file (eg from solc --bin) and an optional abi file.
+func loadContract(name, artifactFile, bytecodeFile, abiFile string) (Contract, error) {
+	contract := Contract{Name: name}
+	var code string
+	var abi *gabs.Container
+	if artifactFile != "" {
+		artifact, err := readJSON(artifactFile)
+		if err != nil {
+			return Contract{}, err
+		}
+		for _, path := range []string{"bytecode", "bytecode.object", "evm.bytecode.object"} {
+			if s, ok := artifact.Path(path).Data().(string); ok {
+				code = s
+				break
+			}
+		}
+		abi = artifact.Path("abi")
+	} else {
+		bz, err := ioutil.ReadFile(bytecodeFile)
+		if err != nil {
+			return Contract{}, err
+		}
+		code = string(bz)
+	}
+	if abiFile != "" {
+		var err error
+		if abi, err = readJSON(abiFile); err != nil {
+			return Contract{}, err
+		}
+	}
+
+	code = strings.TrimPrefix(strings.TrimSpace(code), "0x")
+	if code == "" {
+		return Contract{}, fmt.Errorf("no bytecode, it may be an interface or abstract contract")
+	}
+	if strings.Contains(code, "__") {
+		return Contract{}, fmt.Errorf("the bytecode has unlinked libraries")
+	}
+	var err error
+	if contract.Bytecode, err = decodeHex(code); err != nil {
+		return Contract{}, fmt.Errorf("invalid bytecode: %w", err)
+	}
+
+	for _, entry := range abi.Children() {
+		if entry.Path("type").Data() != "constructor" {
+			continue
+		}
+		for _, input := range entry.Path("inputs").Children() {
+			typeName, _ := input.Path("type").Data().(string)
+			t, err := parseABIType(typeName)
+			if err != nil {
+				return Contract{}, fmt.Errorf("constructor argument %v: %w", input.Path("name").Data(), err)
+			}
+			contract.constructor = append(contract.constructor, t)
+		}
+	}
+	return contract, nil
+}
+
+// deployData is the bytecode followed by the encoded constructor arguments.
+func (c Contract) deployData(encoder abiEncoder) ([]byte, error) {
+	if len(c.Args) != len(c.constructor) {
+		return nil, fmt.Errorf("the constructor takes %d arguments, %d given", len(c.constructor), len(c.Args))
+	}
+	args, err := encoder.encodeTuple(c.constructor, c.Args)
+	if err != nil {
+		return nil, fmt.Errorf("invalid constructor arguments: %w", err)
+	}
+	return append(append([]byte{}, c.Bytecode...), args...), nil
+}
+
+// Deployments records where contracts were deployed. It's written to deployments.json.
+type Deployments struct {
+	ChainID   uint64                `json:"chainId"`
+	Deployer  string                `json:"deployer"`
+	Contracts map[string]Deployment `json:"contracts"`
+}
+
+// Deployment is a deployed contract.
+type Deployment struct {
+	Address         string `json:"address"`
+	TransactionHash string `json:"transactionHash"`
+	BlockNumber     uint64 `json:"blockNumber"`
+	GasUsed         uint64 `json:"gasUsed"`
+}
+
+// receiptInterval is how often a transaction's receipt is checked for.
+const receiptInterval = time.Second
+
+// Deploy deploys contracts in order from the key's account, waiting for each one to be included in a block.
+// It errors if a deployment reverts.
+func Deploy(ctx context.Context, rpcURL string, key *Key, contracts []Contract) (Deployments, error) {
+	client := &rpcClient{url: rpcURL}
+	chainID, err := client.quantity(ctx, "eth_chainId")
+	if err != nil {
+		return Deployments{}, err
+	}
+	nonce, err := client.quantity(ctx, "eth_getTransactionCount", key.Address(), "pending")
+	if err != nil {
+		return Deployments{}, err
+	}
+	gasPrice, err := client.quantity(ctx, "eth_gasPrice")
+	if err != nil {
+		return Deployments{}, err
+	}
+
+	deployments := Deployments{ChainID: chainID.Uint64(), Deployer: key.Address(), Contracts: map[string]Deployment{}}
+	addresses := map[string]string{}
+	for _, contract := range contracts {
+		data, err := contract.deployData(abiEncoder{addresses: addresses})
+		if err != nil {
+			return Deployments{}, fmt.Errorf("contract %s: %w", contract.Name, err)
+		}
+		gas, err := client.quantity(ctx, "eth_estimateGas", map[string]string{"from": key.Address(), "data": hexBytes(data)})
+		if err != nil {
+			return Deployments{}, fmt.Errorf("could not estimate gas to deploy %s: %w", contract.Name, err)
+		}
+		raw, hash, err := key.signLegacyTx(legacyTx{Nonce: nonce.Uint64(), GasPrice: gasPrice, Gas: gas.Uint64(), Value: new(big.Int), Data: data}, chainID)
+		if err != nil {
+			return Deployments{}, err
+		}
+		var txHash string
+		if err := client.call(ctx, &txHash, "eth_sendRawTransaction", hexBytes(raw)); err != nil {
+			return Deployments{}, fmt.Errorf("could not deploy %s: %w", contract.Name, err)
+		}
+		if !strings.EqualFold(txHash, hexBytes(hash)) {
+			return Deployments{}, fmt.Errorf("could not deploy %s: the node returned hash %s for transaction %s", contract.Name, txHash, hexBytes(hash))
+		}
+		deployment, err := waitForDeployment(ctx, client, txHash)
+		if err != nil {
+			return Deployments{}, fmt.Errorf("could not deploy %s: %w", contract.Name, err)
+		}
+		deployments.Contracts[contract.Name] = deployment
+		addresses[contract.Name] = deployment.Address
+		nonce.Add(nonce, big.NewInt(1))
+		fmt.Printf("deployed %s at %s\n", contract.Name, deployment.Address)
+	}
+	return deployments, nil
+}
+
+// waitForDeployment waits for a contract creation to be included in a block.
+func waitForDeployment(ctx context.Context, client *rpcClient, txHash string) (Deployment, error) {
+	for {
+		r, err := client.receipt(ctx, txHash)
+		if err != nil {
+			return Deployment{}, err
+		}
+		if r != nil {
+			if r.Status != "0x1" {
+				return Deployment{}, fmt.Errorf("transaction %s reverted", txHash)
+			}
+			address, err := decodeHex(r.ContractAddress)
+			if err != nil || len(address) != 20 {
+				return Deployment{}, fmt.Errorf("transaction %s has no contract address", txHash)
+			}
+			block, _ := new(big.Int).SetString(strings.TrimPrefix(r.BlockNumber, "0x"), 16)
+			gasUsed, _ := new(big.Int).SetString(strings.TrimPrefix(r.GasUsed, "0x"), 16)
+			deployment := Deployment{Address: checksumAddress(address), TransactionHash: txHash}
+			if block != nil {
+				deployment.BlockNumber = block.Uint64()
+			}
+			if gasUsed != nil {
+				deployment.GasUsed = gasUsed.Uint64()
+			}
+			return deployment, nil
+		}
+		select {
+		case <-ctx.Done():
+			return Deployment{}, fmt.Errorf("transaction %s not included in a block: %w", txHash, ctx.Err())
+		case <-time.After(receiptInterval):
+		}
+	}
+}
+
+// WriteDeployments writes the deployed contracts to a json file.
+func WriteDeployments(filename string, deployments Deployments) error {
+	bz, err := json.MarshalIndent(deployments, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(bz, '\n'), 0644)
+}
+
+func readJSON(filename string) (*gabs.Container, error) {
+	bz, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	container, err := gabs.ParseJSON(bz)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", filename, err)
+	}
+	return container, nil
+}
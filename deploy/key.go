@@ -0,0 +1,91 @@
+package deploy
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
+	"golang.org/x/crypto/sha3"
+)
+
+// Key is an ethereum account's private key, used to sign transactions.
+type Key struct {
+	private *btcec.PrivateKey
+	address []byte
+}
+
+// ParseKey reads a hex encoded secp256k1 private key, with or without a 0x prefix.
+func ParseKey(hexKey string) (*Key, error) {
+	bz, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(hexKey, "0x"), "0X"))
+	if err != nil || len(bz) != btcec.PrivKeyBytesLen {
+		return nil, fmt.Errorf("invalid private key, must be %d bytes of hex", btcec.PrivKeyBytesLen)
+	}
+	private, public := btcec.PrivKeyFromBytes(btcec.S256(), bz)
+	// an address is the last 20 bytes of the hash of the uncompressed public key, without its 0x04 prefix
+	return &Key{private: private, address: keccak256(public.SerializeUncompressed()[1:])[12:]}, nil
+}
+
+// Address returns the key's account address, with an EIP-55 checksum.
+func (k *Key) Address() string {
+	return checksumAddress(k.address)
+}
+
+// legacyTx is a pre EIP-1559 transaction.
+type legacyTx struct {
+	Nonce    uint64
+	GasPrice *big.Int
+	Gas      uint64
+	// To is empty for contract creations.
+	To    []byte
+	Value *big.Int
+	Data  []byte
+}
+
+// signLegacyTx signs a transaction with EIP-155 replay protection, returning the raw transaction and its hash.
+func (k *Key) signLegacyTx(tx legacyTx, chainID *big.Int) ([]byte, []byte, error) {
+	fields := [][]byte{
+		rlpUint(new(big.Int).SetUint64(tx.Nonce)),
+		rlpUint(tx.GasPrice),
+		rlpUint(new(big.Int).SetUint64(tx.Gas)),
+		rlpBytes(tx.To),
+		rlpUint(tx.Value),
+		rlpBytes(tx.Data),
+	}
+	signingHash := keccak256(rlpList(append(fields, rlpUint(chainID), rlpUint(new(big.Int)), rlpUint(new(big.Int)))...))
+
+	// the compact signature is the recovery id + 27, followed by r and s
+	signature, err := btcec.SignCompact(btcec.S256(), k.private, signingHash, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	v := new(big.Int).Mul(chainID, big.NewInt(2))
+	v.Add(v, big.NewInt(int64(signature[0]-27)+35))
+	r := new(big.Int).SetBytes(signature[1:33])
+	s := new(big.Int).SetBytes(signature[33:65])
+
+	raw := rlpList(append(fields, rlpUint(v), rlpUint(r), rlpUint(s))...)
+	return raw, keccak256(raw), nil
+}
+
+func keccak256(data ...[]byte) []byte {
+	hash := sha3.NewLegacyKeccak256()
+	for _, d := range data {
+		hash.Write(d)
+	}
+	return hash.Sum(nil)
+}
+
+// checksumAddress formats an address with the mixed case checksum from EIP-55.
+func checksumAddress(address []byte) string {
+	lower := hex.EncodeToString(address)
+	hash := hex.EncodeToString(keccak256([]byte(lower)))
+	checksummed := []byte(lower)
+	for i, c := range checksummed {
+		if c >= 'a' && hash[i] >= '8' {
+			checksummed[i] = c - 'a' + 'A'
+		}
+	}
+	return "0x" + string(checksummed)
+}
@@ -0,0 +1,65 @@
+package deploy
+
+import (
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestSignLegacyTx(t *testing.T) {
+	// the example transaction from EIP-155
+	key, err := ParseKey("0x4646464646464646464646464646464646464646464646464646464646464646")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "0x9d8A62f656a8d1615C1294fd71e9CFb3E4855A4F"; key.Address() != expected {
+		t.Fatalf("expected address %s, got %s", expected, key.Address())
+	}
+	to, err := decodeHex("0x3535353535353535353535353535353535353535")
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, _ := new(big.Int).SetString("1000000000000000000", 10)
+	tx := legacyTx{
+		Nonce:    9,
+		GasPrice: big.NewInt(20000000000),
+		Gas:      21000,
+		To:       to,
+		Value:    value,
+	}
+
+	raw, hash, err := key.signLegacyTx(tx, big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "f86c098504a817c800825208943535353535353535353535353535353535353535880de0b6b3a76400008025a028ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276a067cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83"
+	if actual := hex.EncodeToString(raw); actual != expected {
+		t.Fatalf("expected signed tx %s, got %s", expected, actual)
+	}
+	if actual, expected := hex.EncodeToString(hash), hex.EncodeToString(keccak256(raw)); actual != expected {
+		t.Fatalf("expected tx hash %s, got %s", expected, actual)
+	}
+}
+
+func TestChecksumAddress(t *testing.T) {
+	// the test vectors from EIP-55
+	for _, expected := range []string{
+		"0x52908400098527886E0F7030069857D2E4169EE7",
+		"0x8617E340B3D01FA5F11F306F4090FD50E238070D",
+		"0xde709f2102306220921060314715629080e2fb77",
+		"0x27b1fdb04752bbc536007a920d24acb045561c26",
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+		"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB",
+		"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb",
+	} {
+		address, err := decodeHex(strings.ToLower(expected))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := checksumAddress(address); actual != expected {
+			t.Fatalf("expected %s, got %s", expected, actual)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package deploy
+
+import (
+	"math/big"
+)
+
+// rlpBytes encodes a byte string with ethereum's recursive length prefix encoding.
+func rlpBytes(b []byte) []byte {
+	if len(b) == 1 && b[0] < 0x80 {
+		return []byte{b[0]}
+	}
+	return append(rlpLength(len(b), 0x80), b...)
+}
+
+// rlpList encodes a list of already encoded items.
+func rlpList(items ...[]byte) []byte {
+	var payload []byte
+	for _, item := range items {
+		payload = append(payload, item...)
+	}
+	return append(rlpLength(len(payload), 0xc0), payload...)
+}
+
+// rlpUint encodes an unsigned integer as a byte string without leading zeros, so zero is the empty string.
+func rlpUint(i *big.Int) []byte {
+	return rlpBytes(i.Bytes())
+}
+
+// rlpLength is the prefix of a string (offset 0x80) or list (offset 0xc0) of a given length.
+func rlpLength(length int, offset byte) []byte {
+	if length < 56 {
+		return []byte{offset + byte(length)}
+	}
+	lengthBytes := big.NewInt(int64(length)).Bytes()
+	return append([]byte{offset + 55 + byte(len(lengthBytes))}, lengthBytes...)
+}
@@ -0,0 +1,41 @@
+package deploy
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+func TestRLP(t *testing.T) {
+	testCases := []struct {
+		name     string
+		encoded  []byte
+		expected string
+	}{
+		{"zero", rlpUint(big.NewInt(0)), "80"},
+		{"small int", rlpUint(big.NewInt(15)), "0f"},
+		{"int", rlpUint(big.NewInt(1024)), "820400"},
+		{"empty string", rlpBytes(nil), "80"},
+		{"zero byte", rlpBytes([]byte{0x00}), "00"},
+		{"single byte below 0x80", rlpBytes([]byte{0x7f}), "7f"},
+		{"single byte from 0x80", rlpBytes([]byte{0x80}), "8180"},
+		{"string", rlpBytes([]byte("dog")), "83646f67"},
+		{"55 byte string", rlpBytes(bytes.Repeat([]byte{0xaa}, 55)), "b7" + hex.EncodeToString(bytes.Repeat([]byte{0xaa}, 55))},
+		{"56 byte string", rlpBytes(bytes.Repeat([]byte{0xaa}, 56)), "b838" + hex.EncodeToString(bytes.Repeat([]byte{0xaa}, 56))},
+		{"1024 byte string", rlpBytes(bytes.Repeat([]byte{0xaa}, 1024)), "b90400" + hex.EncodeToString(bytes.Repeat([]byte{0xaa}, 1024))},
+		{"empty list", rlpList(), "c0"},
+		{"list", rlpList(rlpBytes([]byte("cat")), rlpBytes([]byte("dog"))), "c88363617483646f67"},
+		{"nested list", rlpList(rlpList(), rlpList(rlpList())), "c3c0c1c0"},
+		// a 54 byte string is 55 bytes encoded, the longest payload with a single byte prefix
+		{"55 byte list", rlpList(rlpBytes(bytes.Repeat([]byte{0xaa}, 54))), "f7b6" + hex.EncodeToString(bytes.Repeat([]byte{0xaa}, 54))},
+		{"56 byte list", rlpList(rlpBytes(bytes.Repeat([]byte{0xaa}, 55))), "f838b7" + hex.EncodeToString(bytes.Repeat([]byte{0xaa}, 55))},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := hex.EncodeToString(tc.encoded); actual != tc.expected {
+				t.Fatalf("expected %s, got %s", tc.expected, actual)
+			}
+		})
+	}
+}
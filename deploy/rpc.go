@@ -0,0 +1,87 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+)
+
+// rpcClient calls the methods of an ethereum JSON-RPC server.
+type rpcClient struct {
+	url    string
+	nextID int
+}
+
+// call calls a method, decoding its result into result.
+func (c *rpcClient) call(ctx context.Context, result interface{}, method string, params ...interface{}) error {
+	c.nextID++
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": c.nextID, "method": method, "params": params})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("could not decode %s response from %s: %w", method, c.url, err)
+	}
+	if response.Error != nil {
+		return fmt.Errorf("%s failed: %s", method, response.Error.Message)
+	}
+	return json.Unmarshal(response.Result, result)
+}
+
+// quantity calls a method that returns a hex encoded number.
+func (c *rpcClient) quantity(ctx context.Context, method string, params ...interface{}) (*big.Int, error) {
+	var result string
+	if err := c.call(ctx, &result, method, params...); err != nil {
+		return nil, err
+	}
+	i, ok := new(big.Int).SetString(strings.TrimPrefix(result, "0x"), 16)
+	if !ok {
+		return nil, fmt.Errorf("%s returned an invalid number '%s'", method, result)
+	}
+	return i, nil
+}
+
+// receipt is the subset of a transaction receipt used to check a deployment.
+type receipt struct {
+	Status          string `json:"status"`
+	ContractAddress string `json:"contractAddress"`
+	BlockNumber     string `json:"blockNumber"`
+	GasUsed         string `json:"gasUsed"`
+}
+
+// receipt returns a transaction's receipt, or nil if it hasn't been included in a block yet.
+func (c *rpcClient) receipt(ctx context.Context, txHash string) (*receipt, error) {
+	var result *receipt
+	if err := c.call(ctx, &result, "eth_getTransactionReceipt", txHash); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// hexBytes encodes bytes as 0x prefixed hex, as JSON-RPC expects data.
+func hexBytes(bz []byte) string {
+	return fmt.Sprintf("0x%x", bz)
+}
@@ -1,9 +1,10 @@
 module github.com/furya-official/mgtool
 
-go 1.13
+go 1.16
 
 require (
 	github.com/Jeffail/gabs/v2 v2.6.0
+	github.com/btcsuite/btcd v0.20.1-beta
 	github.com/cosmos/cosmos-sdk v0.39.2
 	github.com/kava-labs/kava v0.13.1
 	github.com/kr/text v0.2.0 // indirect
@@ -11,10 +12,12 @@ require (
 	github.com/onsi/ginkgo v1.12.0 // indirect
 	github.com/onsi/gomega v1.9.0 // indirect
 	github.com/otiai10/copy v1.2.0
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/spf13/cobra v1.0.0
 	github.com/spf13/viper v1.7.0 // indirect
 	github.com/tendermint/tendermint v0.33.9
 	go.etcd.io/bbolt v1.3.4 // indirect
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	golang.org/x/sys v0.0.0-20220412211240-33da011f77ad // indirect
 	google.golang.org/grpc v1.30.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20200227125254-8fa46927fb4f // indirect
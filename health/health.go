@@ -0,0 +1,173 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Check reports whether an endpoint is ready, returning an error describing why not.
+type Check func(ctx context.Context) error
+
+// Wait runs the checks every interval until they all pass, or the context is done.
+// Checks that pass aren't run again.
+func Wait(ctx context.Context, interval time.Duration, checks ...Check) error {
+	pending := checks
+	for {
+		var failing []Check
+		var lastErr error
+		for _, check := range pending {
+			if err := check(ctx); err != nil {
+				failing = append(failing, check)
+				lastErr = err
+			}
+		}
+		if len(failing) == 0 {
+			return nil
+		}
+		pending = failing
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d endpoints not ready: %w", len(pending), lastErr)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// NodeStatus is the subset of a tendermint node's status used by kvtool.
+type NodeStatus struct {
+	ChainID      string
+	LatestHeight int64
+	CatchingUp   bool
+}
+
+// Status fetches the status of a tendermint node from its rpc endpoint. eg http://localhost:26657
+func Status(ctx context.Context, rpcURL string) (NodeStatus, error) {
+	var response struct {
+		Result struct {
+			NodeInfo struct {
+				Network string `json:"network"`
+			} `json:"node_info"`
+			SyncInfo struct {
+				LatestBlockHeight string `json:"latest_block_height"`
+				CatchingUp        bool   `json:"catching_up"`
+			} `json:"sync_info"`
+		} `json:"result"`
+	}
+	if err := GetJSON(ctx, rpcURL+"/status", &response); err != nil {
+		return NodeStatus{}, err
+	}
+	height, err := strconv.ParseInt(response.Result.SyncInfo.LatestBlockHeight, 10, 64)
+	if err != nil {
+		return NodeStatus{}, fmt.Errorf("could not parse block height from %s: %w", rpcURL, err)
+	}
+	return NodeStatus{
+		ChainID:      response.Result.NodeInfo.Network,
+		LatestHeight: height,
+		CatchingUp:   response.Result.SyncInfo.CatchingUp,
+	}, nil
+}
+
+// BlocksProduced checks a tendermint node has reached at least minHeight and isn't catching up.
+func BlocksProduced(rpcURL string, minHeight int64) Check {
+	return func(ctx context.Context) error {
+		status, err := Status(ctx, rpcURL)
+		if err != nil {
+			return err
+		}
+		if status.CatchingUp {
+			return fmt.Errorf("%s is catching up", rpcURL)
+		}
+		if status.LatestHeight < minHeight {
+			return fmt.Errorf("%s is at height %d, waiting for %d", rpcURL, status.LatestHeight, minHeight)
+		}
+		return nil
+	}
+}
+
+// HTTPResponding checks a server returns a response without a server error. Any other status code counts as responding.
+func HTTPResponding(url string) Check {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("%s responded with %s", url, resp.Status)
+		}
+		return nil
+	}
+}
+
+// TCPListening checks something accepts connections at an address, such as a grpc server. eg localhost:9090
+func TCPListening(address string) Check {
+	return func(ctx context.Context) error {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// EVMResponding checks an ethereum JSON-RPC server can return the latest block number.
+func EVMResponding(url string) Check {
+	return func(ctx context.Context) error {
+		body := []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		var response struct {
+			Result string `json:"result"`
+			Error  *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			return fmt.Errorf("could not decode response from %s: %w", url, err)
+		}
+		if response.Error != nil {
+			return fmt.Errorf("%s returned an error: %s", url, response.Error.Message)
+		}
+		if response.Result == "" {
+			return fmt.Errorf("%s did not return a block number", url)
+		}
+		return nil
+	}
+}
+
+// GetJSON fetches a url and decodes its JSON response into result. It errors if the response isn't 200 OK.
+func GetJSON(ctx context.Context, url string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}